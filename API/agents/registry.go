@@ -0,0 +1,151 @@
+package agents
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrNotConnected 设备当前没有 WebSocket 连接
+var ErrNotConnected = errors.New("设备未连接")
+
+// Conn 设备的 WebSocket 连接, 方法须可并发调用
+type Conn interface {
+	WriteMessage(messageType int, data []byte) error
+	Close(code int, reason string) error
+}
+
+// Info 连接信息
+type Info struct {
+	DeviceId      string    `json:"device_id"`
+	ConnectedAt   time.Time `json:"connected_at"`    // 建立连接的时间
+	RemoteIP      string    `json:"remote_ip"`       // 客户端IP
+	LastMessageAt time.Time `json:"last_message_at"` // 最近收到消息(含 pong)的时间
+	Received      int64     `json:"received"`        // 被接受的设备消息数
+	Sent          int64     `json:"sent"`            // 服务器下发的消息数
+}
+
+// Agent 一个已连接的设备
+type Agent struct {
+	conn Conn
+	info Info
+}
+
+// Registry 已连接设备的登记表, 每台设备只保留最新的一个连接
+type Registry struct {
+	mu     sync.Mutex
+	agents map[string]*Agent
+}
+
+// NewRegistry 创建登记表
+func NewRegistry() *Registry {
+	return &Registry{agents: make(map[string]*Agent)}
+}
+
+// Register 登记新连接, 同一设备已有的连接会被关闭
+func (r *Registry) Register(deviceId string, conn Conn, remoteIP string, now time.Time) *Agent {
+	agent := &Agent{conn: conn, info: Info{
+		DeviceId:      deviceId,
+		ConnectedAt:   now,
+		RemoteIP:      remoteIP,
+		LastMessageAt: now,
+	}}
+
+	r.mu.Lock()
+	previous := r.agents[deviceId]
+	r.agents[deviceId] = agent
+	r.mu.Unlock()
+
+	if previous != nil {
+		previous.conn.Close(websocket.ClosePolicyViolation, "replaced")
+	}
+	return agent
+}
+
+// Unregister 注销连接, 连接已被新连接替换时不做处理
+func (r *Registry) Unregister(agent *Agent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.agents[agent.info.DeviceId] == agent {
+		delete(r.agents, agent.info.DeviceId)
+	}
+}
+
+// Seen 记录收到消息, accepted 表示消息已被接受
+func (r *Registry) Seen(agent *Agent, now time.Time, accepted bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent.info.LastMessageAt = now
+	if accepted {
+		agent.info.Received++
+	}
+}
+
+// Get 获取设备的连接信息
+func (r *Registry) Get(deviceId string) (Info, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	agent, ok := r.agents[deviceId]
+	if !ok {
+		return Info{}, false
+	}
+	return agent.info, true
+}
+
+// Connected 设备当前是否已连接
+func (r *Registry) Connected(deviceId string) bool {
+	_, ok := r.Get(deviceId)
+	return ok
+}
+
+// List 全部连接信息, 按设备ID排序
+func (r *Registry) List() []Info {
+	r.mu.Lock()
+	list := make([]Info, 0, len(r.agents))
+	for _, agent := range r.agents {
+		list = append(list, agent.info)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].DeviceId < list[j].DeviceId })
+	return list
+}
+
+// Send 向设备下发一条文本消息
+func (r *Registry) Send(deviceId string, data []byte) error {
+	r.mu.Lock()
+	agent, ok := r.agents[deviceId]
+	r.mu.Unlock()
+	if !ok {
+		return ErrNotConnected
+	}
+	return r.SendTo(agent, data)
+}
+
+// SendTo 通过指定连接下发一条文本消息
+func (r *Registry) SendTo(agent *Agent, data []byte) error {
+	if err := agent.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	agent.info.Sent++
+	r.mu.Unlock()
+	return nil
+}
+
+// Disconnect 断开设备的连接(如设备被删除或凭证被吊销)
+func (r *Registry) Disconnect(deviceId, reason string) {
+	r.mu.Lock()
+	agent, ok := r.agents[deviceId]
+	delete(r.agents, deviceId)
+	r.mu.Unlock()
+	if ok {
+		agent.conn.Close(websocket.ClosePolicyViolation, reason)
+	}
+}
+
+// Default 全局登记表
+var Default = NewRegistry()
@@ -0,0 +1,73 @@
+package agents
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeConn struct {
+	written [][]byte
+	closed  string
+}
+
+func (c *fakeConn) WriteMessage(messageType int, data []byte) error {
+	if c.closed != "" {
+		return errors.New("closed")
+	}
+	c.written = append(c.written, data)
+	return nil
+}
+
+func (c *fakeConn) Close(code int, reason string) error {
+	c.closed = reason
+	return nil
+}
+
+func TestRegisterReplaces(t *testing.T) {
+	registry := NewRegistry()
+	now := time.Now()
+
+	first := &fakeConn{}
+	old := registry.Register("a", first, "1.1.1.1", now)
+	second := &fakeConn{}
+	registry.Register("a", second, "2.2.2.2", now.Add(time.Second))
+
+	if first.closed != "replaced" {
+		t.Errorf("旧连接应被关闭, closed = %q", first.closed)
+	}
+
+	// 旧连接退出时不应注销新连接
+	registry.Unregister(old)
+	info, ok := registry.Get("a")
+	if !ok || info.RemoteIP != "2.2.2.2" {
+		t.Errorf("Get = %+v, %v", info, ok)
+	}
+	if len(registry.List()) != 1 {
+		t.Errorf("List = %+v", registry.List())
+	}
+}
+
+func TestSend(t *testing.T) {
+	registry := NewRegistry()
+	if err := registry.Send("a", []byte("x")); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("未连接时 Send = %v", err)
+	}
+
+	conn := &fakeConn{}
+	agent := registry.Register("a", conn, "", time.Now())
+	if err := registry.Send("a", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	registry.Seen(agent, time.Now(), true)
+
+	info, _ := registry.Get("a")
+	if info.Sent != 1 || info.Received != 1 || len(conn.written) != 1 {
+		t.Errorf("Info = %+v, written = %d", info, len(conn.written))
+	}
+
+	registry.Disconnect("a", "revoked")
+	if conn.closed != "revoked" || registry.Connected("a") {
+		t.Errorf("Disconnect 后 closed = %q, connected = %v", conn.closed, registry.Connected("a"))
+	}
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+)
+
+type contextKey int
+
+const (
+	userIdKey contextKey = iota
+	sessionIdKey
+	deviceIdKey
+)
+
+// WithUserId 将当前用户ID写入上下文
+func WithUserId(ctx context.Context, userId string) context.Context {
+	return context.WithValue(ctx, userIdKey, userId)
+}
+
+// UserId 从请求上下文获取当前用户ID
+func UserId(r *http.Request) string {
+	userId, _ := r.Context().Value(userIdKey).(string)
+	return userId
+}
+
+// WithSessionId 将当前会话ID写入上下文
+func WithSessionId(ctx context.Context, sessionId string) context.Context {
+	return context.WithValue(ctx, sessionIdKey, sessionId)
+}
+
+// SessionId 从请求上下文获取当前会话ID
+func SessionId(r *http.Request) string {
+	sessionId, _ := r.Context().Value(sessionIdKey).(string)
+	return sessionId
+}
+
+// WithDeviceId 将已认证的设备ID写入上下文
+func WithDeviceId(ctx context.Context, deviceId string) context.Context {
+	return context.WithValue(ctx, deviceIdKey, deviceId)
+}
+
+// DeviceId 从请求上下文获取已认证的设备ID
+func DeviceId(r *http.Request) string {
+	deviceId, _ := r.Context().Value(deviceIdKey).(string)
+	return deviceId
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+// NewSecret 生成随机凭证(设备上报凭证, 刷新令牌等), 返回明文与哈希
+func NewSecret() (secret, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	secret = hex.EncodeToString(buf)
+	return secret, HashSecret(secret), nil
+}
+
+// HashSecret 计算凭证哈希(凭证为高熵随机值, 无需慢哈希)
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifySecret 校验凭证
+func VerifySecret(secret, hash string) bool {
+	if secret == "" || hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(HashSecret(secret)), []byte(hash)) == 1
+}
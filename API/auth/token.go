@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("令牌无效")
+	ErrExpiredToken = errors.New("令牌已过期")
+)
+
+// 令牌用途标识
+const (
+	purposeTwoFactor = "2fa"    // 两步验证登录凭据
+	purposeReauth    = "reauth" // 重新验证身份凭据
+)
+
+// 令牌头部(HS256 JWT)
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Claims 令牌载荷
+type Claims struct {
+	Subject   string `json:"sub"`           // 用户ID
+	SessionId string `json:"sid"`           // 会话ID
+	Purpose   string `json:"pur,omitempty"` // 用途(为空表示访问令牌)
+	IssuedAt  int64  `json:"iat"`           // 签发时间(秒)
+	ExpiresAt int64  `json:"exp"`           // 过期时间(秒)
+}
+
+// IssueToken 为用户会话签发访问令牌
+func IssueToken(secret, userId, sessionId string, ttl time.Duration) (string, error) {
+	return issue(secret, Claims{Subject: userId, SessionId: sessionId}, ttl)
+}
+
+// ParseToken 校验访问令牌签名与有效期, 返回载荷
+func ParseToken(secret, token string) (*Claims, error) {
+	claims, err := parse(secret, token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" || claims.SessionId == "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// IssueChallenge 签发两步验证登录凭据, 密码校验通过后用于提交验证码
+func IssueChallenge(secret, userId string, ttl time.Duration) (string, error) {
+	return issue(secret, Claims{Subject: userId, Purpose: purposeTwoFactor}, ttl)
+}
+
+// ParseChallenge 校验两步验证登录凭据, 返回用户ID
+func ParseChallenge(secret, token string) (string, error) {
+	claims, err := parse(secret, token)
+	if err != nil {
+		return "", err
+	}
+	if claims.Purpose != purposeTwoFactor {
+		return "", ErrInvalidToken
+	}
+	return claims.Subject, nil
+}
+
+// IssueReauth 签发重新验证身份凭据, 无密码账户通过外部账户重新登录后用于确认敏感操作
+func IssueReauth(secret, userId string, ttl time.Duration) (string, error) {
+	return issue(secret, Claims{Subject: userId, Purpose: purposeReauth}, ttl)
+}
+
+// ParseReauth 校验重新验证身份凭据, 返回用户ID
+func ParseReauth(secret, token string) (string, error) {
+	claims, err := parse(secret, token)
+	if err != nil {
+		return "", err
+	}
+	if claims.Purpose != purposeReauth {
+		return "", ErrInvalidToken
+	}
+	return claims.Subject, nil
+}
+
+// 签名并编码载荷
+func issue(secret string, claims Claims, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(ttl).Unix()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + sign(secret, unsigned), nil
+}
+
+// 校验签名与有效期并解码载荷
+func parse(secret, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, ErrInvalidToken
+	}
+
+	expected := sign(secret, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+	return &claims, nil
+}
+
+// 计算签名
+func sign(secret, data string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
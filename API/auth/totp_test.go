@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录B的 SHA1 密钥 "12345678901234567890"(Base32)
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPVectors(t *testing.T) {
+	// RFC 6238 的验证码为8位, 取末6位
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		step, ok := ValidateTOTP(rfcSecret, tt.code, time.Unix(tt.unix, 0), 0)
+		if !ok {
+			t.Errorf("时间 %d 的验证码 %s 校验失败", tt.unix, tt.code)
+			continue
+		}
+		if step != tt.unix/totpPeriod {
+			t.Errorf("时间 %d 匹配的时间步 = %d, 期望 %d", tt.unix, step, tt.unix/totpPeriod)
+		}
+	}
+}
+
+func TestTOTPSkew(t *testing.T) {
+	// 1111111109 所在时间步的验证码
+	at := time.Unix(1111111109, 0)
+	for _, tt := range []struct {
+		offset time.Duration
+		want   bool
+	}{
+		{0, true},
+		{-totpPeriod * time.Second, true},
+		{totpPeriod * time.Second, true},
+		{-2 * totpPeriod * time.Second, false},
+		{2 * totpPeriod * time.Second, false},
+	} {
+		if _, ok := ValidateTOTP(rfcSecret, "081804", at.Add(tt.offset), 0); ok != tt.want {
+			t.Errorf("偏移 %v: 校验结果 = %v, 期望 %v", tt.offset, ok, tt.want)
+		}
+	}
+}
+
+func TestTOTPRejectsUsedStep(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step, ok := ValidateTOTP(rfcSecret, "081804", now, 0)
+	if !ok {
+		t.Fatal("首次校验失败")
+	}
+	if _, ok := ValidateTOTP(rfcSecret, "081804", now, step); ok {
+		t.Error("已使用的时间步不应再次通过")
+	}
+	// 前一时间步的验证码也不能在之后使用
+	if _, ok := ValidateTOTP(rfcSecret, "081804", now.Add(totpPeriod*time.Second), step); ok {
+		t.Error("早于已使用时间步的验证码不应通过")
+	}
+}
+
+func TestTOTPMalformed(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "abcdef"} {
+		if _, ok := ValidateTOTP(rfcSecret, code, now, 0); ok {
+			t.Errorf("验证码 %q 不应通过", code)
+		}
+	}
+	if _, ok := ValidateTOTP("not base32!", "287082", now, 0); ok {
+		t.Error("无效密钥不应通过")
+	}
+	// 允许首尾空白与小写密钥
+	if _, ok := ValidateTOTP("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", " 287082 ", now, 0); !ok {
+		t.Error("小写密钥与带空白的验证码应通过")
+	}
+}
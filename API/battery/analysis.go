@@ -0,0 +1,177 @@
+package battery
+
+import (
+	"time"
+)
+
+// 会话类型
+const (
+	Charge    = "charge"    // 接通电源(充电中或已充满)
+	Discharge = "discharge" // 电池供电
+)
+
+// Sample 一次上报中与电池有关的字段
+type Sample struct {
+	Timestamp int64 // 上报时间(毫秒)
+	Charging  int   // 充电状态(1: 充电中, 2: 未充电, 3: 已充满, 0: 未上报)
+	Level     int   // 电量百分比
+	ScreenOn  bool  // 屏幕是否点亮
+}
+
+// 是否接通电源; 未上报电池状态时返回 ok=false
+func (s Sample) plugged() (on bool, ok bool) {
+	switch s.Charging {
+	case 1, 3:
+		return true, true
+	case 2:
+		return false, true
+	}
+	return false, false
+}
+
+// Session 一段连续的充电或放电过程
+type Session struct {
+	Kind       string   `json:"kind"`        // charge 或 discharge
+	Start      int64    `json:"start"`       // 开始时间(毫秒)
+	End        int64    `json:"end"`         // 结束时间(毫秒, 最后一次上报)
+	StartLevel int      `json:"start_level"` // 开始时电量
+	EndLevel   int      `json:"end_level"`   // 结束时电量
+	Rate       *float64 `json:"rate"`        // 平均每小时电量变化(%/h, 放电时为消耗速度), 时长为 0 时为 null
+}
+
+// Sessions 将按时间升序排列的上报划分为充电/放电会话, 供电方式变化或上报间隔超过 gap 时开始新会话
+func Sessions(samples []Sample, gap time.Duration) []Session {
+	sessions := make([]Session, 0)
+	var current *Session
+	var lastTs int64
+	for _, sample := range samples {
+		on, ok := sample.plugged()
+		if !ok {
+			continue
+		}
+		kind := Discharge
+		if on {
+			kind = Charge
+		}
+
+		if current == nil || current.Kind != kind || sample.Timestamp-lastTs > gap.Milliseconds() {
+			sessions = append(sessions, Session{
+				Kind:       kind,
+				Start:      sample.Timestamp,
+				StartLevel: sample.Level,
+			})
+			current = &sessions[len(sessions)-1]
+		}
+		current.End = sample.Timestamp
+		current.EndLevel = sample.Level
+		lastTs = sample.Timestamp
+	}
+
+	for i := range sessions {
+		session := &sessions[i]
+		if session.End > session.Start {
+			delta := float64(session.EndLevel - session.StartLevel)
+			if session.Kind == Discharge {
+				delta = -delta
+			}
+			session.Rate = perHour(delta, session.End-session.Start)
+		}
+	}
+	return sessions
+}
+
+// Rates 平均每小时电量变化(%/h), 没有足够数据时为 null
+type Rates struct {
+	Drain          *float64 `json:"drain"`            // 电池供电时的消耗速度
+	DrainScreenOn  *float64 `json:"drain_screen_on"`  // 亮屏时的消耗速度
+	DrainScreenOff *float64 `json:"drain_screen_off"` // 熄屏时的消耗速度
+	Charge         *float64 `json:"charge"`           // 充电速度(不含已充满)
+}
+
+// ComputeRates 由相邻两次上报之间的电量变化计算平均速度, 间隔超过 gap 或供电方式变化的区间不参与计算.
+// 区间按前一次上报的屏幕状态归类
+func ComputeRates(samples []Sample, gap time.Duration) Rates {
+	var drain, drainOn, drainOff, charge struct {
+		delta float64
+		ms    int64
+	}
+	for i := 1; i < len(samples); i++ {
+		prev, next := samples[i-1], samples[i]
+		ms := next.Timestamp - prev.Timestamp
+		if ms <= 0 || ms > gap.Milliseconds() || prev.Charging != next.Charging {
+			continue
+		}
+		delta := float64(next.Level - prev.Level)
+		switch prev.Charging {
+		case 1:
+			charge.delta += delta
+			charge.ms += ms
+		case 2:
+			drain.delta -= delta
+			drain.ms += ms
+			if prev.ScreenOn {
+				drainOn.delta -= delta
+				drainOn.ms += ms
+			} else {
+				drainOff.delta -= delta
+				drainOff.ms += ms
+			}
+		}
+	}
+	return Rates{
+		Drain:          perHour(drain.delta, drain.ms),
+		DrainScreenOn:  perHour(drainOn.delta, drainOn.ms),
+		DrainScreenOff: perHour(drainOff.delta, drainOff.ms),
+		Charge:         perHour(charge.delta, charge.ms),
+	}
+}
+
+// 每小时变化量, 时长为 0 时返回 nil
+func perHour(delta float64, ms int64) *float64 {
+	if ms <= 0 {
+		return nil
+	}
+	rate := delta / (float64(ms) / float64(time.Hour.Milliseconds()))
+	return &rate
+}
+
+// Estimate 按当前状态估算的剩余时间
+type Estimate struct {
+	State   string   `json:"state"`   // discharging, charging, full 或 unknown
+	Rate    *float64 `json:"rate"`    // 估算使用的速度(%/h)
+	Seconds *int64   `json:"seconds"` // 距离耗尽(放电时)或充满(充电时)的秒数, 无法估算时为 null
+}
+
+// Estimate 由当前状态与平均速度估算耗尽或充满所需时间; 放电时优先使用与当前屏幕状态对应的速度
+func (r Rates) Estimate(current Sample) Estimate {
+	var estimate Estimate
+	var remaining float64
+	switch current.Charging {
+	case 1:
+		estimate.State = "charging"
+		estimate.Rate = r.Charge
+		remaining = float64(100 - current.Level)
+	case 2:
+		estimate.State = "discharging"
+		estimate.Rate = r.DrainScreenOff
+		if current.ScreenOn {
+			estimate.Rate = r.DrainScreenOn
+		}
+		if estimate.Rate == nil {
+			estimate.Rate = r.Drain
+		}
+		remaining = float64(current.Level)
+	case 3:
+		estimate.State = "full"
+		return estimate
+	default:
+		estimate.State = "unknown"
+		return estimate
+	}
+
+	if estimate.Rate != nil && *estimate.Rate > 0 {
+		seconds := int64(remaining / *estimate.Rate * time.Hour.Seconds())
+		estimate.Seconds = &seconds
+	}
+	return estimate
+}
@@ -0,0 +1,141 @@
+package battery
+
+import (
+	"math"
+	"sloth-tracker/api/internal/testdb"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/rollup"
+	"testing"
+	"time"
+)
+
+const gap = 5 * time.Minute
+
+// 每 minutes 分钟一次上报
+func sample(minutes int, charging, level int, screenOn bool) Sample {
+	return Sample{
+		Timestamp: (time.Duration(minutes) * time.Minute).Milliseconds(),
+		Charging:  charging,
+		Level:     level,
+		ScreenOn:  screenOn,
+	}
+}
+
+func near(value *float64, want float64) bool {
+	return value != nil && math.Abs(*value-want) < 1e-9
+}
+
+func TestSessions(t *testing.T) {
+	samples := []Sample{
+		sample(0, 2, 80, true),
+		sample(3, 2, 79, true),
+		sample(6, 2, 78, true),
+		sample(9, 1, 78, true),
+		sample(12, 1, 80, true),
+		sample(13, 0, 0, false), // 未上报电池状态
+		sample(15, 3, 100, false),
+		// 超过间隔阈值, 开始新会话
+		sample(30, 3, 100, false),
+	}
+
+	sessions := Sessions(samples, gap)
+	if len(sessions) != 3 {
+		t.Fatalf("会话数 = %d, 期望 3: %+v", len(sessions), sessions)
+	}
+	if sessions[0].Kind != Discharge || sessions[0].End != sample(6, 0, 0, false).Timestamp || !near(sessions[0].Rate, 20) {
+		t.Errorf("放电会话 = %+v", sessions[0])
+	}
+	// 已充满也属于接通电源
+	if sessions[1].Kind != Charge || sessions[1].StartLevel != 78 || sessions[1].EndLevel != 100 {
+		t.Errorf("充电会话 = %+v", sessions[1])
+	}
+	if sessions[2].Start != sessions[2].End || sessions[2].Rate != nil {
+		t.Errorf("单次上报的会话 = %+v", sessions[2])
+	}
+}
+
+func TestComputeRates(t *testing.T) {
+	samples := []Sample{
+		// 亮屏 3 分钟掉 1%: 20%/h
+		sample(0, 2, 80, true),
+		sample(3, 2, 79, false),
+		// 熄屏 3 分钟不掉电
+		sample(6, 2, 79, false),
+		// 间隔过长, 不参与计算
+		sample(60, 2, 50, true),
+		// 供电方式变化, 不参与计算
+		sample(62, 1, 50, true),
+		// 充电 3 分钟增加 3%: 60%/h
+		sample(65, 1, 53, true),
+	}
+
+	rates := ComputeRates(samples, gap)
+	if !near(rates.DrainScreenOn, 20) {
+		t.Errorf("亮屏耗电 = %v", rates.DrainScreenOn)
+	}
+	if !near(rates.DrainScreenOff, 0) {
+		t.Errorf("熄屏耗电 = %v", rates.DrainScreenOff)
+	}
+	if !near(rates.Drain, 10) {
+		t.Errorf("平均耗电 = %v", rates.Drain)
+	}
+	if !near(rates.Charge, 60) {
+		t.Errorf("充电速度 = %v", rates.Charge)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	drain, drainOn, charge := 10.0, 20.0, 60.0
+	rates := Rates{Drain: &drain, DrainScreenOn: &drainOn, Charge: &charge}
+
+	// 亮屏时使用亮屏耗电速度: 50% / 20%/h = 2.5h
+	estimate := rates.Estimate(Sample{Charging: 2, Level: 50, ScreenOn: true})
+	if estimate.State != "discharging" || estimate.Seconds == nil || *estimate.Seconds != 9000 {
+		t.Errorf("亮屏估算 = %+v", estimate)
+	}
+	// 没有熄屏数据时使用平均速度: 50% / 10%/h = 5h
+	estimate = rates.Estimate(Sample{Charging: 2, Level: 50})
+	if estimate.Seconds == nil || *estimate.Seconds != 18000 {
+		t.Errorf("熄屏估算 = %+v", estimate)
+	}
+	// 充电: 40% / 60%/h = 40min
+	estimate = rates.Estimate(Sample{Charging: 1, Level: 60})
+	if estimate.State != "charging" || estimate.Seconds == nil || *estimate.Seconds != 2400 {
+		t.Errorf("充电估算 = %+v", estimate)
+	}
+	if estimate := (Rates{}).Estimate(Sample{Charging: 2, Level: 50}); estimate.Seconds != nil {
+		t.Errorf("没有速度时不应估算: %+v", estimate)
+	}
+	if estimate := rates.Estimate(Sample{Charging: 3, Level: 100}); estimate.State != "full" || estimate.Seconds != nil {
+		t.Errorf("已充满 = %+v", estimate)
+	}
+}
+
+func TestCapacityTrend(t *testing.T) {
+	db := testdb.Open(t, &model.StatusRollup{})
+	day := (24 * time.Hour).Milliseconds()
+	for i, data := range []string{
+		`{"numeric":{"battery.capacity":{"min":0,"max":5000,"avg":2500,"count":2}}}`,
+		`{"numeric":{}}`,
+		`{"numeric":{"battery.capacity":{"min":4500,"max":4500,"avg":4500,"count":1}}}`,
+	} {
+		db.Create(&model.StatusRollup{
+			Id:          string(rune('a' + i)),
+			DeviceId:    "device",
+			Resolution:  rollup.Day.Name,
+			BucketStart: int64(i) * day,
+			Data:        data,
+		})
+	}
+
+	health, err := CapacityTrend(db, "device", 365)
+	if err != nil {
+		t.Fatalf("计算容量趋势失败: %v", err)
+	}
+	if len(health.Points) != 2 || health.Points[0].Capacity != 5000 || health.Points[1].Date != 2*day {
+		t.Errorf("容量变化 = %+v", health.Points)
+	}
+	if !near(health.Peak, 5000) || !near(health.Latest, 4500) || !near(health.Percent, 90) {
+		t.Errorf("电池健康 = %+v", health)
+	}
+}
@@ -0,0 +1,57 @@
+package battery
+
+import (
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/rollup"
+
+	"gorm.io/gorm"
+)
+
+// CapacityPoint 一天内上报的最大电池容量
+type CapacityPoint struct {
+	Date     int64   `json:"date"`     // 天聚合时间桶起点(毫秒, UTC 对齐)
+	Capacity float64 `json:"capacity"` // 电池容量(mAh)
+}
+
+// Health 电池容量变化趋势
+type Health struct {
+	Points  []CapacityPoint `json:"points"`  // 按时间升序排列
+	Peak    *float64        `json:"peak"`    // 记录到的最大容量
+	Latest  *float64        `json:"latest"`  // 最近一天的容量
+	Percent *float64        `json:"percent"` // 最近容量占最大容量的百分比
+}
+
+// CapacityTrend 由最近 days 天的天聚合数据计算容量趋势. 每天取最大值, 以忽略未上报容量(0)的样本
+func CapacityTrend(db *gorm.DB, deviceId string, days int) (*Health, error) {
+	var rows []model.StatusRollup
+	if err := db.Where("device_id = ? AND resolution = ?", deviceId, rollup.Day.Name).
+		Order("bucket_start DESC").Limit(days).
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	health := &Health{Points: make([]CapacityPoint, 0, len(rows))}
+	for i := len(rows) - 1; i >= 0; i-- {
+		agg, err := rollup.Decode(rows[i].Data)
+		if err != nil {
+			return nil, err
+		}
+		stats, ok := agg.Numeric["battery.capacity"]
+		if !ok || stats.Max <= 0 {
+			continue
+		}
+		health.Points = append(health.Points, CapacityPoint{Date: rows[i].BucketStart, Capacity: stats.Max})
+		if health.Peak == nil || stats.Max > *health.Peak {
+			peak := stats.Max
+			health.Peak = &peak
+		}
+	}
+
+	if len(health.Points) > 0 {
+		latest := health.Points[len(health.Points)-1].Capacity
+		percent := latest / *health.Peak * 100
+		health.Latest = &latest
+		health.Percent = &percent
+	}
+	return health, nil
+}
@@ -0,0 +1,208 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sloth-tracker/api/model"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 命令类型
+const (
+	ReportNow   = "report_now"   // 立即上报一次状态
+	SetInterval = "set_interval" // 修改上报间隔, 参数 {"seconds": 60}
+	Pause       = "pause"        // 暂停上报, 参数 {"minutes": 30}
+	Message     = "message"      // 在设备上显示消息, 参数 {"text": "..."}
+)
+
+// 命令状态
+const (
+	Pending   = "pending"   // 等待下发
+	Delivered = "delivered" // 已下发, 等待设备确认
+	Acked     = "acked"     // 设备已执行
+	Failed    = "failed"    // 设备执行失败或过期未确认
+)
+
+const (
+	MaxOpen        = 50              // 每台设备最多未完成(等待下发或确认)的命令数
+	RedeliverAfter = 2 * time.Minute // 已下发但未确认的命令在该时间后重新下发
+	maxInterval    = 86400           // 上报间隔上限(秒)
+	maxPause       = 1440            // 暂停时长上限(分钟)
+	maxTextLen     = 500             // 消息长度上限(字符)
+	maxResultLen   = 500             // 设备返回结果的长度上限(字符)
+)
+
+var (
+	ErrInvalid  = errors.New("命令格式不正确")
+	ErrTooMany  = fmt.Errorf("每台设备最多 %d 条未完成的命令", MaxOpen)
+	ErrNotFound = errors.New("命令不存在")
+	ErrFinished = errors.New("命令已完成")
+)
+
+// Validate 校验命令类型与参数, 返回规范化后的参数
+func Validate(kind string, payload json.RawMessage) (json.RawMessage, error) {
+	if len(payload) == 0 || bytes.Equal(bytes.TrimSpace(payload), []byte("null")) {
+		payload = json.RawMessage("{}")
+	}
+
+	var params struct {
+		Seconds *int    `json:"seconds"`
+		Minutes *int    `json:"minutes"`
+		Text    *string `json:"text"`
+	}
+	if err := json.Unmarshal(payload, &params); err != nil {
+		return nil, fmt.Errorf("%w: payload 应为对象", ErrInvalid)
+	}
+
+	var normalized any
+	switch kind {
+	case ReportNow:
+		normalized = map[string]any{}
+	case SetInterval:
+		if params.Seconds == nil || *params.Seconds < 1 || *params.Seconds > maxInterval {
+			return nil, fmt.Errorf("%w: seconds 应在 1 到 %d 之间", ErrInvalid, maxInterval)
+		}
+		normalized = map[string]any{"seconds": *params.Seconds}
+	case Pause:
+		if params.Minutes == nil || *params.Minutes < 1 || *params.Minutes > maxPause {
+			return nil, fmt.Errorf("%w: minutes 应在 1 到 %d 之间", ErrInvalid, maxPause)
+		}
+		normalized = map[string]any{"minutes": *params.Minutes}
+	case Message:
+		if params.Text == nil || *params.Text == "" || utf8.RuneCountInString(*params.Text) > maxTextLen {
+			return nil, fmt.Errorf("%w: text 不能为空且不超过 %d 个字符", ErrInvalid, maxTextLen)
+		}
+		normalized = map[string]any{"text": *params.Text}
+	default:
+		return nil, fmt.Errorf("%w: 不支持的命令类型 %q", ErrInvalid, kind)
+	}
+	return json.Marshal(normalized)
+}
+
+// Enqueue 创建一条等待下发的命令
+func Enqueue(db *gorm.DB, deviceId, issuerId, kind string, payload json.RawMessage, ttl time.Duration, now time.Time) (*model.DeviceCommand, error) {
+	payload, err := Validate(kind, payload)
+	if err != nil {
+		return nil, err
+	}
+	if err := Expire(db, deviceId, now); err != nil {
+		return nil, err
+	}
+
+	var open int64
+	if err := db.Model(&model.DeviceCommand{}).
+		Where("device_id = ? AND status IN ?", deviceId, []string{Pending, Delivered}).
+		Count(&open).Error; err != nil {
+		return nil, err
+	}
+	if open >= MaxOpen {
+		return nil, ErrTooMany
+	}
+
+	command := &model.DeviceCommand{
+		Id:        uuid.New().String(),
+		DeviceId:  deviceId,
+		IssuerId:  issuerId,
+		Type:      kind,
+		Payload:   payload,
+		Status:    Pending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := db.Create(command).Error; err != nil {
+		return nil, err
+	}
+	return command, nil
+}
+
+// Deliver 取出需要下发的命令(等待下发的, 以及已下发但长时间未确认的), 按创建时间排序并标记为已下发.
+// 每条命令通过带状态条件的更新认领, 并发调用时同一命令只会被其中一次下发
+func Deliver(db *gorm.DB, deviceId string, now time.Time) ([]model.DeviceCommand, error) {
+	if err := Expire(db, deviceId, now); err != nil {
+		return nil, err
+	}
+
+	const dueCondition = "status = ? OR (status = ? AND delivered_at <= ?)"
+	redeliverBefore := now.Add(-RedeliverAfter)
+
+	var due []model.DeviceCommand
+	if err := db.Where("device_id = ?", deviceId).
+		Where(dueCondition, Pending, Delivered, redeliverBefore).
+		Order("created_at").
+		Find(&due).Error; err != nil {
+		return nil, err
+	}
+
+	claimed := due[:0]
+	for _, command := range due {
+		result := db.Model(&model.DeviceCommand{}).
+			Where("id = ?", command.Id).
+			Where(dueCondition, Pending, Delivered, redeliverBefore).
+			Updates(map[string]any{"status": Delivered, "delivered_at": now})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		// 已被其他请求认领或状态已变化
+		if result.RowsAffected == 0 {
+			continue
+		}
+		command.Status = Delivered
+		command.DeliveredAt = &now
+		claimed = append(claimed, command)
+	}
+	return claimed, nil
+}
+
+// Ack 记录设备的执行结果
+func Ack(db *gorm.DB, deviceId, id string, success bool, result string, now time.Time) (*model.DeviceCommand, error) {
+	if utf8.RuneCountInString(result) > maxResultLen {
+		result = string([]rune(result)[:maxResultLen])
+	}
+	if err := Expire(db, deviceId, now); err != nil {
+		return nil, err
+	}
+
+	var command model.DeviceCommand
+	if err := db.Where("id = ? AND device_id = ?", id, deviceId).First(&command).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if command.Status != Pending && command.Status != Delivered {
+		return nil, ErrFinished
+	}
+
+	command.Status = Failed
+	if success {
+		command.Status = Acked
+	}
+	command.Result = result
+	command.AckedAt = &now
+	if err := db.Model(&command).Updates(map[string]any{
+		"status":   command.Status,
+		"result":   command.Result,
+		"acked_at": now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return &command, nil
+}
+
+// Expire 将过期仍未确认的命令标记为失败
+func Expire(db *gorm.DB, deviceId string, now time.Time) error {
+	return db.Model(&model.DeviceCommand{}).
+		Where("device_id = ? AND status IN ? AND expires_at <= ?", deviceId, []string{Pending, Delivered}, now).
+		Updates(map[string]any{"status": Failed, "result": "expired"}).Error
+}
+
+// Delete 删除设备的全部命令
+func Delete(db *gorm.DB, deviceIds []string) error {
+	return db.Where("device_id IN ?", deviceIds).Delete(&model.DeviceCommand{}).Error
+}
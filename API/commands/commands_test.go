@@ -0,0 +1,170 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"sloth-tracker/api/internal/testdb"
+	"sloth-tracker/api/model"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func setupDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return testdb.Open(t, &model.DeviceCommand{})
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		kind    string
+		payload string
+		want    string
+	}{
+		{ReportNow, ``, `{}`},
+		{ReportNow, `null`, `{}`},
+		{SetInterval, `{"seconds": 60, "extra": 1}`, `{"seconds":60}`},
+		{Pause, `{"minutes": 30}`, `{"minutes":30}`},
+		{Message, `{"text": "你好"}`, `{"text":"你好"}`},
+		{SetInterval, `{"seconds": 0}`, ""},
+		{SetInterval, `{}`, ""},
+		{Pause, `{"minutes": 1441}`, ""},
+		{Message, `{"text": ""}`, ""},
+		{Message, `"text"`, ""},
+		{"reboot", `{}`, ""},
+	}
+	for _, tt := range tests {
+		got, err := Validate(tt.kind, json.RawMessage(tt.payload))
+		if tt.want == "" {
+			if !errors.Is(err, ErrInvalid) {
+				t.Errorf("Validate(%s, %s) 错误 = %v, 期望 ErrInvalid", tt.kind, tt.payload, err)
+			}
+			continue
+		}
+		if err != nil || string(got) != tt.want {
+			t.Errorf("Validate(%s, %s) = %s, %v, 期望 %s", tt.kind, tt.payload, got, err, tt.want)
+		}
+	}
+}
+
+func TestDeliverAndAck(t *testing.T) {
+	db := setupDB(t)
+	now := time.Now()
+
+	first, err := Enqueue(db, "d1", "u1", ReportNow, nil, time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Enqueue(db, "d1", "u1", Message, json.RawMessage(`{"text":"hi"}`), time.Hour, now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Enqueue(db, "d2", "u1", ReportNow, nil, time.Hour, now); err != nil {
+		t.Fatal(err)
+	}
+
+	delivered, err := Deliver(db, "d1", now.Add(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 2 || delivered[0].Id != first.Id || delivered[1].Id != second.Id || delivered[0].Status != Delivered {
+		t.Fatalf("下发的命令 = %+v", delivered)
+	}
+
+	// 已下发的命令在重新下发时间之前不会重复下发
+	if again, _ := Deliver(db, "d1", now.Add(time.Minute)); len(again) != 0 {
+		t.Errorf("不应重复下发: %+v", again)
+	}
+
+	acked, err := Ack(db, "d1", first.Id, true, "", now.Add(time.Minute))
+	if err != nil || acked.Status != Acked {
+		t.Fatalf("Ack = %+v, %v", acked, err)
+	}
+	if _, err := Ack(db, "d1", first.Id, true, "", now.Add(time.Minute)); !errors.Is(err, ErrFinished) {
+		t.Errorf("重复确认错误 = %v", err)
+	}
+	if _, err := Ack(db, "d2", second.Id, true, "", now.Add(time.Minute)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("其他设备确认错误 = %v", err)
+	}
+
+	// 未确认的命令超过重新下发时间后再次下发
+	again, err := Deliver(db, "d1", now.Add(2*time.Second+RedeliverAfter))
+	if err != nil || len(again) != 1 || again[0].Id != second.Id {
+		t.Errorf("重新下发 = %+v, %v", again, err)
+	}
+
+	failed, err := Ack(db, "d1", second.Id, false, "不支持", now.Add(5*time.Minute))
+	if err != nil || failed.Status != Failed || failed.Result != "不支持" {
+		t.Errorf("失败确认 = %+v, %v", failed, err)
+	}
+}
+
+func TestDeliverSkipsClaimed(t *testing.T) {
+	db := setupDB(t)
+	now := time.Now()
+
+	first, err := Enqueue(db, "d1", "u1", ReportNow, nil, time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Enqueue(db, "d1", "u1", ReportNow, nil, time.Hour, now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟另一个请求在查询之后, 标记之前下发了第一条命令
+	claim := true
+	db.Callback().Query().After("gorm:query").Register("test:claim", func(tx *gorm.DB) {
+		if claim && tx.Statement.Table == "device_commands" {
+			claim = false
+			tx.Session(&gorm.Session{NewDB: true}).Model(&model.DeviceCommand{}).
+				Where("id = ?", first.Id).
+				Updates(map[string]any{"status": Delivered, "delivered_at": now})
+		}
+	})
+	defer db.Callback().Query().Remove("test:claim")
+
+	delivered, err := Deliver(db, "d1", now.Add(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 1 || delivered[0].Id != second.Id {
+		t.Errorf("已被认领的命令不应再次下发: %+v", delivered)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	db := setupDB(t)
+	now := time.Now()
+
+	command, err := Enqueue(db, "d1", "u1", ReportNow, nil, time.Minute, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivered, _ := Deliver(db, "d1", now.Add(2*time.Minute)); len(delivered) != 0 {
+		t.Errorf("过期的命令不应下发: %+v", delivered)
+	}
+
+	var stored model.DeviceCommand
+	db.First(&stored, "id = ?", command.Id)
+	if stored.Status != Failed || stored.Result != "expired" {
+		t.Errorf("过期命令 = %+v", stored)
+	}
+	if _, err := Ack(db, "d1", command.Id, true, "", now.Add(2*time.Minute)); !errors.Is(err, ErrFinished) {
+		t.Errorf("确认过期命令错误 = %v", err)
+	}
+}
+
+func TestEnqueueLimit(t *testing.T) {
+	db := setupDB(t)
+	now := time.Now()
+	for i := 0; i < MaxOpen; i++ {
+		if _, err := Enqueue(db, "d1", "u1", ReportNow, nil, time.Hour, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Enqueue(db, "d1", "u1", ReportNow, nil, time.Hour, now); !errors.Is(err, ErrTooMany) {
+		t.Errorf("超出上限错误 = %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config 服务器配置, 均从环境变量读取
+type Config struct {
+	TokenSecret string        // 令牌签名密钥(SLOTH_TOKEN_SECRET)
+	TokenTTL    time.Duration // 访问令牌有效期(SLOTH_TOKEN_TTL)
+	RefreshTTL  time.Duration // 刷新令牌(会话)有效期(SLOTH_REFRESH_TTL)
+	TrustProxy  bool          // 是否信任反向代理的 X-Forwarded-For 头(SLOTH_TRUST_PROXY)
+
+	// 密码校验限流
+	LoginFreeAttempts  int           // 无延迟的失败次数(SLOTH_LOGIN_FREE_ATTEMPTS)
+	LoginBaseDelay     time.Duration // 超出后的初始延迟, 每次失败翻倍(SLOTH_LOGIN_BASE_DELAY)
+	LoginMaxDelay      time.Duration // 延迟上限(SLOTH_LOGIN_MAX_DELAY)
+	LockoutThreshold   int           // 账户连续失败多少次后锁定(SLOTH_LOCKOUT_THRESHOLD)
+	LockoutDuration    time.Duration // 锁定时长(SLOTH_LOCKOUT_DURATION)
+	IPLockoutThreshold int           // 单个IP连续失败多少次后锁定(SLOTH_IP_LOCKOUT_THRESHOLD)
+
+	// 密码哈希与强度策略
+	PasswordHash      string   // 新密码使用的哈希算法: argon2id 或 bcrypt(SLOTH_PASSWORD_HASH)
+	BcryptCost        int      // bcrypt 计算强度(SLOTH_BCRYPT_COST)
+	Argon2Memory      int      // argon2id 内存开销, 单位 KiB(SLOTH_ARGON2_MEMORY)
+	Argon2Time        int      // argon2id 迭代次数(SLOTH_ARGON2_TIME)
+	Argon2Threads     int      // argon2id 并行度(SLOTH_ARGON2_THREADS)
+	PasswordMinLength int      // 密码最小长度(SLOTH_PASSWORD_MIN_LENGTH)
+	PasswordDenylist  []string // 禁用密码文件路径, 逗号分隔(SLOTH_PASSWORD_DENYLIST)
+
+	// 历史数据保留与聚合(保留时长为 0 表示永久保留)
+	HistoryRawRetention    time.Duration // 原始上报保留时长(SLOTH_HISTORY_RAW_RETENTION)
+	HistoryMinuteRetention time.Duration // 分钟聚合保留时长(SLOTH_HISTORY_MINUTE_RETENTION)
+	HistoryHourRetention   time.Duration // 小时聚合保留时长(SLOTH_HISTORY_HOUR_RETENTION)
+	HistoryDayRetention    time.Duration // 天聚合保留时长(SLOTH_HISTORY_DAY_RETENTION)
+	HistoryCompactInterval time.Duration // 聚合任务执行间隔(SLOTH_HISTORY_COMPACT_INTERVAL)
+	HistoryMaxGap          time.Duration // 单次上报最多代表的时长, 用于计算状态持续时间(SLOTH_HISTORY_MAX_GAP)
+
+	// 状态上报
+	StatusStrict bool // 上报中出现未知字段时拒绝请求(SLOTH_STATUS_STRICT)
+
+	// 远程命令
+	CommandTTL time.Duration // 命令的有效期, 过期未确认视为失败(SLOTH_COMMAND_TTL)
+
+	// Webhook 投递
+	WebhookMaxAttempts  int           // 每次投递最多尝试次数(SLOTH_WEBHOOK_MAX_ATTEMPTS)
+	WebhookRetryDelay   time.Duration // 首次重试的等待时间, 之后每次翻倍(SLOTH_WEBHOOK_RETRY_DELAY)
+	WebhookTimeout      time.Duration // 单次请求超时(SLOTH_WEBHOOK_TIMEOUT)
+	WebhookDisableAfter int           // 连续失败多少次后自动停用(SLOTH_WEBHOOK_DISABLE_AFTER)
+	WebhookAllowPrivate bool          // 允许投递到本机或内网地址(SLOTH_WEBHOOK_ALLOW_PRIVATE)
+	WebhookRetention    time.Duration // 投递记录保留时长(SLOTH_WEBHOOK_RETENTION)
+
+	// 设备在线状态
+	HeartbeatTimeout      time.Duration // 超过该时间未上报视为 stale(SLOTH_HEARTBEAT_TIMEOUT)
+	OfflineTimeout        time.Duration // 超过该时间未上报视为 offline(SLOTH_OFFLINE_TIMEOUT)
+	PresenceCheckInterval time.Duration // 后台检测状态变化的间隔(SLOTH_PRESENCE_CHECK_INTERVAL)
+	PresenceRetention     time.Duration // 在线状态变化记录的保留时长(SLOTH_PRESENCE_RETENTION)
+
+	// OpenID Connect 登录(未配置发行方时不启用)
+	OIDCIssuer        string   // 发行方地址(SLOTH_OIDC_ISSUER)
+	OIDCClientId      string   // 客户端ID(SLOTH_OIDC_CLIENT_ID)
+	OIDCClientSecret  string   // 客户端密钥(SLOTH_OIDC_CLIENT_SECRET)
+	OIDCRedirectURL   string   // 回调地址, 指向 /api/oidc/callback(SLOTH_OIDC_REDIRECT_URL)
+	OIDCScopes        []string // 权限范围, 逗号分隔(SLOTH_OIDC_SCOPES)
+	OIDCUsernameClaim string   // 用作用户名的声明(SLOTH_OIDC_USERNAME_CLAIM)
+	OIDCAutoCreate    bool     // 首次登录时自动创建账户(SLOTH_OIDC_AUTO_CREATE)
+	OIDCReturnURLs    []string // 允许登录后跳转的客户端地址前缀, 逗号分隔(SLOTH_OIDC_RETURN_URLS)
+}
+
+var (
+	instance *Config
+	once     sync.Once
+)
+
+// Get 获取全局配置(首次调用时加载)
+func Get() *Config {
+	once.Do(func() {
+		instance = load()
+	})
+	return instance
+}
+
+func load() *Config {
+	cfg := &Config{
+		TokenSecret: getString("SLOTH_TOKEN_SECRET", ""),
+		TokenTTL:    getDuration("SLOTH_TOKEN_TTL", 15*time.Minute),
+		RefreshTTL:  getDuration("SLOTH_REFRESH_TTL", 30*24*time.Hour),
+		TrustProxy:  getBool("SLOTH_TRUST_PROXY", false),
+
+		LoginFreeAttempts:  getInt("SLOTH_LOGIN_FREE_ATTEMPTS", 3),
+		LoginBaseDelay:     getDuration("SLOTH_LOGIN_BASE_DELAY", time.Second),
+		LoginMaxDelay:      getDuration("SLOTH_LOGIN_MAX_DELAY", time.Minute),
+		LockoutThreshold:   getInt("SLOTH_LOCKOUT_THRESHOLD", 10),
+		LockoutDuration:    getDuration("SLOTH_LOCKOUT_DURATION", 15*time.Minute),
+		IPLockoutThreshold: getInt("SLOTH_IP_LOCKOUT_THRESHOLD", 50),
+
+		PasswordHash:      getString("SLOTH_PASSWORD_HASH", "argon2id"),
+		BcryptCost:        getInt("SLOTH_BCRYPT_COST", 10),
+		Argon2Memory:      getIntRange("SLOTH_ARGON2_MEMORY", 19*1024, 1024, 4*1024*1024),
+		Argon2Time:        getIntRange("SLOTH_ARGON2_TIME", 2, 1, 100),
+		Argon2Threads:     getIntRange("SLOTH_ARGON2_THREADS", 1, 1, 255),
+		PasswordMinLength: getInt("SLOTH_PASSWORD_MIN_LENGTH", 8),
+		PasswordDenylist:  getList("SLOTH_PASSWORD_DENYLIST", nil),
+
+		HistoryRawRetention:    getDuration("SLOTH_HISTORY_RAW_RETENTION", 7*24*time.Hour),
+		HistoryMinuteRetention: getDuration("SLOTH_HISTORY_MINUTE_RETENTION", 30*24*time.Hour),
+		HistoryHourRetention:   getDuration("SLOTH_HISTORY_HOUR_RETENTION", 365*24*time.Hour),
+		HistoryDayRetention:    getDuration("SLOTH_HISTORY_DAY_RETENTION", 0),
+		HistoryCompactInterval: getDuration("SLOTH_HISTORY_COMPACT_INTERVAL", 5*time.Minute),
+		HistoryMaxGap:          getDuration("SLOTH_HISTORY_MAX_GAP", 5*time.Minute),
+
+		StatusStrict: getBool("SLOTH_STATUS_STRICT", false),
+
+		CommandTTL: getDuration("SLOTH_COMMAND_TTL", time.Hour),
+
+		WebhookMaxAttempts:  getInt("SLOTH_WEBHOOK_MAX_ATTEMPTS", 6),
+		WebhookRetryDelay:   getDuration("SLOTH_WEBHOOK_RETRY_DELAY", 30*time.Second),
+		WebhookTimeout:      getDuration("SLOTH_WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookDisableAfter: getInt("SLOTH_WEBHOOK_DISABLE_AFTER", 20),
+		WebhookAllowPrivate: getBool("SLOTH_WEBHOOK_ALLOW_PRIVATE", false),
+		WebhookRetention:    getDuration("SLOTH_WEBHOOK_RETENTION", 30*24*time.Hour),
+
+		HeartbeatTimeout:      getDuration("SLOTH_HEARTBEAT_TIMEOUT", 2*time.Minute),
+		OfflineTimeout:        getDuration("SLOTH_OFFLINE_TIMEOUT", 15*time.Minute),
+		PresenceCheckInterval: getDuration("SLOTH_PRESENCE_CHECK_INTERVAL", 30*time.Second),
+		PresenceRetention:     getDuration("SLOTH_PRESENCE_RETENTION", 30*24*time.Hour),
+
+		OIDCIssuer:        getString("SLOTH_OIDC_ISSUER", ""),
+		OIDCClientId:      getString("SLOTH_OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:  getString("SLOTH_OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:   getString("SLOTH_OIDC_REDIRECT_URL", ""),
+		OIDCScopes:        getList("SLOTH_OIDC_SCOPES", []string{"openid", "profile", "email"}),
+		OIDCUsernameClaim: getString("SLOTH_OIDC_USERNAME_CLAIM", "preferred_username"),
+		OIDCAutoCreate:    getBool("SLOTH_OIDC_AUTO_CREATE", true),
+		OIDCReturnURLs:    getList("SLOTH_OIDC_RETURN_URLS", nil),
+	}
+
+	// 未配置密钥时随机生成, 重启后已签发的令牌全部失效
+	if cfg.TokenSecret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			log.Fatal("生成令牌密钥失败: ", err)
+		}
+		cfg.TokenSecret = hex.EncodeToString(buf)
+		log.Printf("⚠️ 未设置 SLOTH_TOKEN_SECRET, 已使用随机密钥, 重启后需重新登录")
+	}
+
+	return cfg
+}
+
+// 读取字符串配置
+func getString(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// 读取逗号分隔的列表配置
+func getList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// 读取整数配置
+func getInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("⚠️ 配置 %s 无效, 使用默认值 %d", key, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+// 读取整数配置, 超出 [minValue, maxValue] 时使用默认值
+func getIntRange(key string, defaultValue, minValue, maxValue int) int {
+	n := getInt(key, defaultValue)
+	if n < minValue || n > maxValue {
+		log.Printf("⚠️ 配置 %s 应在 %d 到 %d 之间, 使用默认值 %d", key, minValue, maxValue, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+// 读取布尔配置
+func getBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("⚠️ 配置 %s 无效, 使用默认值 %t", key, defaultValue)
+		return defaultValue
+	}
+	return b
+}
+
+// 读取时长配置(如: 30s, 15m, 24h)
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("⚠️ 配置 %s 无效, 使用默认值 %s", key, defaultValue)
+		return defaultValue
+	}
+	return d
+}
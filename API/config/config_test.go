@@ -0,0 +1,26 @@
+package config
+
+import "testing"
+
+func TestArgon2Range(t *testing.T) {
+	tests := []struct {
+		memory, time, threads string
+		want                  [3]int
+	}{
+		{"", "", "", [3]int{19 * 1024, 2, 1}},
+		{"65536", "3", "4", [3]int{65536, 3, 4}},
+		{"0", "0", "0", [3]int{19 * 1024, 2, 1}},
+		{"-1", "-1", "-1", [3]int{19 * 1024, 2, 1}},
+		{"8589934592", "1000", "256", [3]int{19 * 1024, 2, 1}},
+		{"abc", "1.5", "x", [3]int{19 * 1024, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Setenv("SLOTH_ARGON2_MEMORY", tt.memory)
+		t.Setenv("SLOTH_ARGON2_TIME", tt.time)
+		t.Setenv("SLOTH_ARGON2_THREADS", tt.threads)
+		cfg := load()
+		if got := [3]int{cfg.Argon2Memory, cfg.Argon2Time, cfg.Argon2Threads}; got != tt.want {
+			t.Errorf("memory=%q time=%q threads=%q: 配置 = %v, 期望 %v", tt.memory, tt.time, tt.threads, got, tt.want)
+		}
+	}
+}
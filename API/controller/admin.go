@@ -0,0 +1,302 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/events"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/stats"
+	"sloth-tracker/api/utils"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 转义 LIKE 模式中的通配符, 配合 ESCAPE '\' 使用
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// 管理员获取用户列表 GET
+func AdminListUsers(db any) http.HandlerFunc {
+	type UserInfo struct {
+		Id           string    `json:"id"`
+		Name         string    `json:"name"`
+		IsAdmin      bool      `json:"is_admin"`
+		Disabled     bool      `json:"disabled"`
+		TotpEnabled  bool      `json:"totp_enabled"`
+		DeviceCount  int64     `json:"device_count"`
+		RegisteredAt time.Time `json:"registered_at"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+		page, pageSize := utils.GetPagination(r)
+
+		// 按用户名或ID搜索
+		query := gormDB.Model(&model.User{})
+		if keyword := utils.GetQueryParam(r, "q"); keyword != "" {
+			query = query.Where(`name LIKE ? ESCAPE '\' OR id = ?`, "%"+likeEscaper.Replace(keyword)+"%", keyword)
+		}
+
+		var total int64
+		query.Count(&total)
+
+		var users []model.User
+		query.Order("registered_at DESC").
+			Offset((page - 1) * pageSize).
+			Limit(pageSize).
+			Find(&users)
+
+		result := []UserInfo{}
+		for _, user := range users {
+			var deviceCount int64
+			gormDB.Model(&model.Device{}).Where("owner_id = ?", user.Id).Count(&deviceCount)
+			result = append(result, UserInfo{
+				Id:           user.Id,
+				Name:         user.Name,
+				IsAdmin:      user.IsAdmin,
+				Disabled:     user.Disabled,
+				TotpEnabled:  user.TotpEnabled,
+				DeviceCount:  deviceCount,
+				RegisteredAt: user.RegisteredAt,
+			})
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "查询成功",
+			"users":   result,
+			"total":   total,
+			"page":    page,
+		})
+	}
+}
+
+// 管理员禁用/启用用户 PUT
+func AdminDisableUser(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			Id       string `json:"id"`
+			Disabled bool   `json:"disabled"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		if req.Id == auth.UserId(r) {
+			utils.Error(w, http.StatusOK, "不能禁用自己")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		var user model.User
+		if err := gormDB.First(&user, "id = ?", req.Id).Error; err != nil {
+			utils.Error(w, http.StatusOK, "用户不存在")
+			return
+		}
+
+		if err := gormDB.Model(&user).Update("disabled", req.Disabled).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "更新用户失败")
+			return
+		}
+
+		// 禁用后立即踢下线
+		if req.Disabled {
+			if err := revokeSessions(gormDB, user.Id, ""); err != nil {
+				utils.Error(w, http.StatusInternalServerError, "吊销会话失败")
+				return
+			}
+		}
+
+		message := "用户已启用"
+		if req.Disabled {
+			message = "用户已禁用"
+		}
+		utils.Success(w, map[string]any{
+			"message": message,
+		})
+	}
+}
+
+// 管理员删除用户 DELETE
+func AdminDeleteUser(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			Id string `json:"id"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		if req.Id == auth.UserId(r) {
+			utils.Error(w, http.StatusOK, "不能删除自己")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		var user model.User
+		if err := gormDB.First(&user, "id = ?", req.Id).Error; err != nil {
+			utils.Error(w, http.StatusOK, "用户不存在")
+			return
+		}
+
+		// 删除用户及其关联数据
+		tx := gormDB.Begin()
+		deviceIds, step, err := deleteUserCascade(tx, user.Id)
+		if err != nil {
+			tx.Rollback()
+			utils.Error(w, http.StatusInternalServerError, "删除用户失败-"+step)
+			return
+		}
+		tx.Commit()
+
+		events.Publish(events.UserDeleted{UserId: user.Id, DeviceIds: deviceIds})
+
+		utils.Success(w, map[string]any{
+			"message": "用户已删除",
+		})
+	}
+}
+
+// 管理员获取所有设备 GET
+func AdminListDevices(db any) http.HandlerFunc {
+	type DeviceInfo struct {
+		model.Device
+		OwnerName string `json:"owner_name"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+		page, pageSize := utils.GetPagination(r)
+
+		query := gormDB.Model(&model.Device{})
+		if ownerId := utils.GetQueryParam(r, "owner_id"); ownerId != "" {
+			query = query.Where("owner_id = ?", ownerId)
+		}
+
+		var total int64
+		query.Count(&total)
+
+		var devices []model.Device
+		query.Order("registered_at DESC").
+			Offset((page - 1) * pageSize).
+			Limit(pageSize).
+			Find(&devices)
+
+		result := []DeviceInfo{}
+		for _, device := range devices {
+			var owner model.User
+			gormDB.Select("name").First(&owner, "id = ?", device.OwnerId)
+			result = append(result, DeviceInfo{
+				Device:    device,
+				OwnerName: owner.Name,
+			})
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "查询成功",
+			"devices": result,
+			"total":   total,
+			"page":    page,
+		})
+	}
+}
+
+// 管理员获取服务器统计 GET
+func AdminStats(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		var userCount, adminCount, disabledCount, deviceCount, approvedShares, pendingShares, activeSessions int64
+		gormDB.Model(&model.User{}).Count(&userCount)
+		gormDB.Model(&model.User{}).Where("is_admin = ?", true).Count(&adminCount)
+		gormDB.Model(&model.User{}).Where("disabled = ?", true).Count(&disabledCount)
+		gormDB.Model(&model.Device{}).Count(&deviceCount)
+		gormDB.Model(&model.SharedDevice{}).Where("authorization = ?", 1).Count(&approvedShares)
+		gormDB.Model(&model.SharedDevice{}).Where("authorization = ?", 2).Count(&pendingShares)
+		gormDB.Model(&model.Session{}).Where("revoked_at IS NULL AND expires_at > ?", time.Now()).Count(&activeSessions)
+
+		utils.Success(w, map[string]any{
+			"message": "查询成功",
+			"stats": map[string]any{
+				"user_count":         userCount,
+				"admin_count":        adminCount,
+				"disabled_count":     disabledCount,
+				"device_count":       deviceCount,
+				"approved_shares":    approvedShares,
+				"pending_shares":     pendingShares,
+				"active_sessions":    activeSessions,
+				"reports_per_minute": stats.ReportsPerMinute(),
+			},
+			"subscribers": events.Default.Stats(),
+		})
+	}
+}
+
+// 管理员强制撤销共享 DELETE
+func AdminRevokeShare(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			AccessId string `json:"id"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		var shared model.SharedDevice
+		if err := gormDB.Where("id = ?", req.AccessId).First(&shared).Error; err != nil {
+			utils.Error(w, http.StatusOK, "授权记录不存在")
+			return
+		}
+
+		if err := gormDB.Delete(&shared).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "撤销共享失败")
+			return
+		}
+		publishShareRevoked(gormDB, &shared, shared.Authorization == 1)
+
+		utils.Success(w, map[string]any{
+			"message": "共享已撤销",
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sloth-tracker/api/agents"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/policy"
+	"sloth-tracker/api/presence"
+	"sloth-tracker/api/utils"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+const (
+	agentPingInterval = 30 * time.Second // 向设备发送 ping 的间隔, 同时重新校验凭证
+	agentReadTimeout  = 75 * time.Second // 超过该时间未收到任何消息(含 pong)视为断开
+	agentMaxMessage   = 256 << 10        // 设备单条消息的最大字节数
+	agentMaxPush      = 16 << 10         // 下发消息内容的最大字节数
+	agentWriteTimeout = 10 * time.Second // 单条消息的写入超时
+)
+
+var agentUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	// 设备客户端不是浏览器, 不会携带 Origin; 拒绝来自网页的跨站连接
+	CheckOrigin: func(r *http.Request) bool {
+		return r.Header.Get("Origin") == ""
+	},
+}
+
+// 设备连接, 串行化写入(gorilla/websocket 不支持并发写)
+type agentConn struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+func (c *agentConn) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(agentWriteTimeout))
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// Close 发送关闭帧后断开连接, 可重复调用
+func (c *agentConn) Close(code int, reason string) error {
+	message := websocket.FormatCloseMessage(code, reason)
+	c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	return c.conn.Close()
+}
+
+// 设备通过 WebSocket 发送的消息
+type agentFrame struct {
+	Type string          `json:"type"` // 消息类型(status, command_ack)
+	Id   string          `json:"id"`   // 客户端生成的消息ID, 在回复中原样返回
+	Data json.RawMessage `json:"data"` // 消息内容, status 与 PUT /api/status/update 的请求体相同, command_ack 与 POST /api/command/ack 的请求体相同
+}
+
+// 服务器通过 WebSocket 发送的消息
+type agentReply struct {
+	Type     string                `json:"type"`               // ack, error, message 或 command
+	Id       string                `json:"id,omitempty"`       // 对应的设备消息ID
+	Error    string                `json:"error,omitempty"`    // 错误信息
+	Fields   utils.FieldErrors     `json:"fields,omitempty"`   // 字段校验错误
+	Data     json.RawMessage       `json:"data,omitempty"`     // 服务器下发的消息内容
+	Commands []model.DeviceCommand `json:"commands,omitempty"` // 需要执行的命令(随状态上报的确认返回)
+}
+
+// 设备建立 WebSocket 连接 GET
+// 连接期间可持续上报状态并接收服务器下发的消息, 保持连接的设备视为在线
+func AgentConnect(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		// 设备已通过凭证认证
+		deviceID := auth.DeviceId(r)
+		secret := r.Header.Get("X-Device-Secret")
+		gormDB := db.(*gorm.DB)
+
+		// 升级失败时 Upgrader 已返回错误响应
+		ws, err := agentUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn := &agentConn{conn: ws}
+		agent := agents.Default.Register(deviceID, conn, utils.ClientIP(r), time.Now())
+		defer func() {
+			agents.Default.Unregister(agent)
+			conn.Close(websocket.CloseGoingAway, "")
+		}()
+
+		ws.SetReadLimit(agentMaxMessage)
+		ws.SetReadDeadline(time.Now().Add(agentReadTimeout))
+		ws.SetPongHandler(func(string) error {
+			ws.SetReadDeadline(time.Now().Add(agentReadTimeout))
+			agents.Default.Seen(agent, time.Now(), false)
+			touchAgent(gormDB, deviceID)
+			return nil
+		})
+
+		// 定期发送 ping, 凭证失效时断开
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(agentPingInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if !agentAllowed(gormDB, deviceID, secret) {
+						conn.Close(websocket.ClosePolicyViolation, "unauthorized")
+						return
+					}
+					if ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(agentWriteTimeout)) != nil {
+						conn.Close(websocket.CloseGoingAway, "")
+						return
+					}
+				}
+			}
+		}()
+
+		for {
+			messageType, message, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if messageType != websocket.TextMessage {
+				conn.Close(websocket.CloseUnsupportedData, "text frames only")
+				return
+			}
+			if !utf8.Valid(message) {
+				conn.Close(websocket.CloseInvalidFramePayloadData, "invalid utf-8")
+				return
+			}
+			ws.SetReadDeadline(time.Now().Add(agentReadTimeout))
+			touchAgent(gormDB, deviceID)
+
+			reply := handleAgentFrame(gormDB, deviceID, message)
+			agents.Default.Seen(agent, time.Now(), reply.Type == "ack")
+
+			data, _ := json.Marshal(reply)
+			if agents.Default.SendTo(agent, data) != nil {
+				return
+			}
+		}
+	}
+}
+
+// 处理设备发送的一条消息
+func handleAgentFrame(gormDB *gorm.DB, deviceID string, message []byte) agentReply {
+	var frame agentFrame
+	if err := json.Unmarshal(message, &frame); err != nil {
+		return agentReply{Type: "error", Error: "消息格式不正确"}
+	}
+
+	switch frame.Type {
+	case "status":
+		if len(frame.Data) == 0 {
+			return agentReply{Type: "error", Id: frame.Id, Error: "参数错误: data 不能为空"}
+		}
+		if _, failure := saveStatus(gormDB, deviceID, frame.Data); failure != nil {
+			return agentReply{Type: "error", Id: frame.Id, Error: failure.Message, Fields: failure.Fields}
+		}
+		return agentReply{Type: "ack", Id: frame.Id, Commands: deliverCommands(gormDB, deviceID)}
+	case "command_ack":
+		var ack commandAck
+		if err := json.Unmarshal(frame.Data, &ack); err != nil {
+			return agentReply{Type: "error", Id: frame.Id, Error: "参数错误"}
+		}
+		if _, failure := ackCommand(gormDB, deviceID, ack); failure != nil {
+			return agentReply{Type: "error", Id: frame.Id, Error: failure.Message}
+		}
+		return agentReply{Type: "ack", Id: frame.Id}
+	default:
+		return agentReply{Type: "error", Id: frame.Id, Error: "不支持的消息类型"}
+	}
+}
+
+// 记录设备在线
+func touchAgent(gormDB *gorm.DB, deviceID string) {
+	var device model.Device
+	if err := gormDB.Select("id", "presence").First(&device, "id = ?", deviceID).Error; err != nil {
+		return
+	}
+	if err := presence.Touch(gormDB, &device, time.Now()); err != nil {
+		log.Printf("更新设备在线状态失败: %s: %v", deviceID, err)
+	}
+}
+
+// 连接仍然有效: 设备未被删除且凭证未被轮换或吊销
+func agentAllowed(gormDB *gorm.DB, deviceID, secret string) bool {
+	var device model.Device
+	if err := gormDB.Select("secret_hash").First(&device, "id = ?", deviceID).Error; err != nil {
+		return false
+	}
+	return auth.VerifySecret(secret, device.SecretHash)
+}
+
+// 获取设备的 WebSocket 连接状态 GET
+func GetAgentConnection(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		deviceId := utils.GetQueryParam(r, "device_id")
+		if deviceId == "" {
+			utils.Error(w, http.StatusBadRequest, "参数错误: device_id 不能为空")
+			return
+		}
+
+		if !authorizeDevice(w, r, db.(*gorm.DB), policy.Manage, deviceId) {
+			return
+		}
+
+		info, connected := agents.Default.Get(deviceId)
+		var connection *agents.Info
+		if connected {
+			connection = &info
+		}
+
+		utils.Success(w, map[string]any{
+			"message":    "查询成功",
+			"connected":  connected,
+			"connection": connection,
+		})
+	}
+}
+
+// 向已连接的设备下发消息 POST
+func SendAgentMessage(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			DeviceId string          `json:"device_id"`
+			Message  json.RawMessage `json:"message"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		if req.DeviceId == "" || len(req.Message) == 0 || string(req.Message) == "null" {
+			utils.Error(w, http.StatusBadRequest, "参数错误: device_id 和 message 不能为空")
+			return
+		}
+		if len(req.Message) > agentMaxPush {
+			utils.Error(w, http.StatusBadRequest, "参数错误: message 过长")
+			return
+		}
+
+		if !authorizeDevice(w, r, db.(*gorm.DB), policy.Manage, req.DeviceId) {
+			return
+		}
+
+		data, _ := json.Marshal(agentReply{Type: "message", Data: req.Message})
+		if err := agents.Default.Send(req.DeviceId, data); err != nil {
+			if errors.Is(err, agents.ErrNotConnected) {
+				utils.Error(w, http.StatusConflict, "设备未连接")
+			} else {
+				utils.Error(w, http.StatusBadGateway, "消息发送失败")
+			}
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "消息已发送",
+		})
+	}
+}
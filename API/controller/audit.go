@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 审计操作类型
+const (
+	auditRecoveryCodesGenerated = "recovery_codes_generated" // 生成恢复码
+	auditPasswordRecovered      = "password_recovered"       // 使用恢复码重置密码
+	auditRecoveryFailed         = "recovery_failed"          // 恢复码校验失败
+)
+
+// 记录审计日志, 写入失败不影响请求本身
+func recordAudit(gormDB *gorm.DB, r *http.Request, userId, action, detail string) {
+	entry := model.AuditLog{
+		Id:        uuid.New().String(),
+		UserId:    userId,
+		Action:    action,
+		Ip:        utils.ClientIP(r),
+		Detail:    detail,
+		CreatedAt: time.Now(),
+	}
+	if err := gormDB.Create(&entry).Error; err != nil {
+		log.Printf("审计日志写入失败: %s %s: %v", action, userId, err)
+	}
+}
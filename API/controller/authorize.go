@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/policy"
+	"sloth-tracker/api/utils"
+
+	"gorm.io/gorm"
+)
+
+// 校验当前用户对设备的权限, 不通过时写入错误响应并返回false
+func authorizeDevice(w http.ResponseWriter, r *http.Request, gormDB *gorm.DB, action policy.Action, deviceId string) bool {
+	ok, err := policy.Can(gormDB, auth.UserId(r), action, deviceId)
+	if err != nil {
+		if errors.Is(err, policy.ErrDeviceNotFound) {
+			utils.Error(w, http.StatusOK, "设备不存在")
+		} else {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+		}
+		return false
+	}
+	if !ok {
+		utils.Error(w, http.StatusForbidden, "无权操作该设备")
+		return false
+	}
+	return true
+}
+
+// 处理共享记录的权限判断结果, 不通过时写入错误响应并返回false
+func authorizeShare(w http.ResponseWriter, ok bool, err error) bool {
+	if err != nil {
+		if errors.Is(err, policy.ErrShareNotFound) {
+			utils.Error(w, http.StatusOK, "授权记录不存在")
+		} else {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+		}
+		return false
+	}
+	if !ok {
+		utils.Error(w, http.StatusForbidden, "无权操作该授权记录")
+		return false
+	}
+	return true
+}
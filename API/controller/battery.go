@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"sloth-tracker/api/battery"
+	"sloth-tracker/api/config"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/policy"
+	"sloth-tracker/api/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	batteryDefaultRange = 7 * 24 * time.Hour  // 默认分析最近 7 天
+	batteryMaxRange     = 31 * 24 * time.Hour // 单次最多分析 31 天的原始数据
+	batteryCapacityDays = 365                 // 容量趋势最多返回的天数
+)
+
+// 获取电池分析 GET
+func GetBatteryAnalysis(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		deviceId := utils.GetQueryParam(r, "device_id")
+		if deviceId == "" {
+			utils.Error(w, http.StatusBadRequest, "参数错误: device_id 不能为空")
+			return
+		}
+
+		// 时间范围(毫秒时间戳或 RFC3339), 默认最近 7 天, 不早于原始历史记录的保留期
+		now := time.Now().UnixMilli()
+		start := rawHistoryStart(time.UnixMilli(now))
+		to, hasTo, err := utils.GetTimeParam(r, "to")
+		if err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误: to 格式不正确")
+			return
+		}
+		if !hasTo {
+			to = now
+		}
+		from, hasFrom, err := utils.GetTimeParam(r, "from")
+		if err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误: from 格式不正确")
+			return
+		}
+		if !hasFrom {
+			from = max(to-batteryDefaultRange.Milliseconds(), start)
+		}
+		if from < start {
+			utils.Error(w, http.StatusBadRequest, "参数错误: from 早于原始历史记录的保留期")
+			return
+		}
+		if from >= to || to-from > batteryMaxRange.Milliseconds() {
+			utils.Error(w, http.StatusBadRequest, "参数错误: 时间范围无效或超过 31 天")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		if !authorizeDevice(w, r, gormDB, policy.View, deviceId) {
+			return
+		}
+
+		var rows []model.DeviceStatusHistory
+		if err := gormDB.Select("timestamp", "battery_charging", "battery_level", "other_screen_on").
+			Where("device_id = ? AND timestamp >= ? AND timestamp < ?", deviceId, from, to).
+			Order("timestamp ASC").
+			Find(&rows).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+		samples := make([]battery.Sample, 0, len(rows))
+		for _, row := range rows {
+			samples = append(samples, batterySample(row.Timestamp, row.Battery, row.Other))
+		}
+
+		gap := config.Get().HistoryMaxGap
+		rates := battery.ComputeRates(samples, gap)
+
+		// 按最新状态估算剩余时间
+		var estimate *battery.Estimate
+		var status model.DeviceStatus
+		err = gormDB.Where("device_id = ?", deviceId).First(&status).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+		if err == nil {
+			result := rates.Estimate(batterySample(status.Timestamp, status.Battery, status.Other))
+			estimate = &result
+		}
+
+		health, err := battery.CapacityTrend(gormDB, deviceId, batteryCapacityDays)
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message":   "查询成功",
+			"device_id": deviceId,
+			"from":      from,
+			"to":        to,
+			"sessions":  battery.Sessions(samples, gap),
+			"rates":     rates,
+			"estimate":  estimate,
+			"health":    health,
+		})
+	}
+}
+
+// 由上报状态生成电池分析样本
+func batterySample(timestamp int64, status model.BatteryStatus, other model.OtherStatus) battery.Sample {
+	return battery.Sample{
+		Timestamp: timestamp,
+		Charging:  status.Charging,
+		Level:     status.Level,
+		ScreenOn:  other.ScreenOn == 1,
+	}
+}
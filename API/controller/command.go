@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sloth-tracker/api/agents"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/commands"
+	"sloth-tracker/api/config"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/policy"
+	"sloth-tracker/api/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 设备确认命令的请求体, HTTP 与 WebSocket 共用
+type commandAck struct {
+	Id      string `json:"id"`      // 命令ID
+	Success bool   `json:"success"` // 是否执行成功
+	Result  string `json:"result"`  // 执行结果或失败原因(可选)
+}
+
+// 取出需要下发给设备的命令, 随上报响应返回; 查询失败时不影响上报本身
+func deliverCommands(gormDB *gorm.DB, deviceID string) []model.DeviceCommand {
+	due, err := commands.Deliver(gormDB, deviceID, time.Now())
+	if err != nil {
+		log.Printf("下发命令失败: %s: %v", deviceID, err)
+		return []model.DeviceCommand{}
+	}
+	return due
+}
+
+// 设备已通过 WebSocket 连接时立即下发命令, 下发失败的命令会在设备下次上报时重新下发
+func pushCommands(gormDB *gorm.DB, deviceID string) {
+	if !agents.Default.Connected(deviceID) {
+		return
+	}
+	for _, command := range deliverCommands(gormDB, deviceID) {
+		data, _ := json.Marshal(map[string]any{"type": "command", "data": command})
+		if err := agents.Default.Send(deviceID, data); err != nil {
+			return
+		}
+	}
+}
+
+// 记录设备对命令的确认
+func ackCommand(gormDB *gorm.DB, deviceID string, ack commandAck) (*model.DeviceCommand, *reportError) {
+	if ack.Id == "" {
+		return nil, &reportError{Code: http.StatusBadRequest, Message: "参数错误: id 不能为空"}
+	}
+	command, err := commands.Ack(gormDB, deviceID, ack.Id, ack.Success, ack.Result, time.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, commands.ErrNotFound):
+			return nil, &reportError{Code: http.StatusNotFound, Message: "命令不存在"}
+		case errors.Is(err, commands.ErrFinished):
+			return nil, &reportError{Code: http.StatusConflict, Message: "命令已完成"}
+		default:
+			return nil, &reportError{Code: http.StatusInternalServerError, Message: "保存命令结果失败"}
+		}
+	}
+	return command, nil
+}
+
+// 向设备下发命令 POST
+func SendCommand(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			DeviceId string          `json:"device_id"`
+			Type     string          `json:"type"`
+			Payload  json.RawMessage `json:"payload"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		if req.DeviceId == "" || req.Type == "" {
+			utils.Error(w, http.StatusBadRequest, "参数错误: device_id 和 type 不能为空")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		if !authorizeDevice(w, r, gormDB, policy.Manage, req.DeviceId) {
+			return
+		}
+
+		command, err := commands.Enqueue(gormDB, req.DeviceId, auth.UserId(r), req.Type, req.Payload, config.Get().CommandTTL, time.Now())
+		if err != nil {
+			if errors.Is(err, commands.ErrInvalid) || errors.Is(err, commands.ErrTooMany) {
+				utils.Error(w, http.StatusBadRequest, err.Error())
+			} else {
+				utils.Error(w, http.StatusInternalServerError, "创建命令失败")
+			}
+			return
+		}
+
+		pushCommands(gormDB, req.DeviceId)
+		gormDB.First(command, "id = ?", command.Id)
+
+		utils.Success(w, map[string]any{
+			"message": "命令已创建",
+			"command": command,
+		})
+	}
+}
+
+// 获取设备的命令记录 GET
+func GetCommands(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		deviceId := utils.GetQueryParam(r, "device_id")
+		if deviceId == "" {
+			utils.Error(w, http.StatusBadRequest, "参数错误: device_id 不能为空")
+			return
+		}
+		status := utils.GetQueryParam(r, "status")
+		switch status {
+		case "", commands.Pending, commands.Delivered, commands.Acked, commands.Failed:
+		default:
+			utils.Error(w, http.StatusBadRequest, "参数错误: status 无效")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		if !authorizeDevice(w, r, gormDB, policy.Manage, deviceId) {
+			return
+		}
+
+		if err := commands.Expire(gormDB, deviceId, time.Now()); err != nil {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+
+		page, pageSize := utils.GetPagination(r)
+		query := gormDB.Model(&model.DeviceCommand{}).Where("device_id = ?", deviceId)
+		if status != "" {
+			query = query.Where("status = ?", status)
+		}
+
+		var total int64
+		query.Count(&total)
+
+		var list []model.DeviceCommand
+		query.Order("created_at DESC").
+			Offset((page - 1) * pageSize).
+			Limit(pageSize).
+			Find(&list)
+
+		utils.Success(w, map[string]any{
+			"message":  "查询成功",
+			"commands": list,
+			"total":    total,
+			"page":     page,
+		})
+	}
+}
+
+// 设备确认命令的执行结果 POST
+func AckCommand(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req commandAck
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		command, failure := ackCommand(db.(*gorm.DB), auth.DeviceId(r), req)
+		if failure != nil {
+			failure.write(w)
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "确认成功",
+			"command": command,
+		})
+	}
+}
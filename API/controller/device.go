@@ -3,7 +3,13 @@ package controller
 import (
 	"encoding/json"
 	"net/http"
+	"sloth-tracker/api/agents"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/commands"
+	"sloth-tracker/api/events"
+	"sloth-tracker/api/metrics"
 	"sloth-tracker/api/model"
+	"sloth-tracker/api/policy"
 	"sloth-tracker/api/utils"
 	"time"
 
@@ -20,7 +26,6 @@ func RegisterDevice(db any) http.HandlerFunc {
 		}
 
 		var req struct {
-			OwnerId     string `json:"ownerId"`
 			DeviceName  string `json:"deviceName"`
 			Platform    string `json:"platform"`
 			Description string `json:"description"`
@@ -31,21 +36,42 @@ func RegisterDevice(db any) http.HandlerFunc {
 			return
 		}
 
+		// 生成设备上报凭证
+		secret, secretHash, err := auth.NewSecret()
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "设备凭证生成失败")
+			return
+		}
+
 		gormDB := db.(*gorm.DB)
 		deviceId := uuid.New().String()
+		now := time.Now()
 		device := model.Device{
-			Id:           deviceId,
-			OwnerId:      req.OwnerId,
-			Name:         req.DeviceName,
-			Platform:     req.Platform,
-			Description:  req.Description,
-			RegisteredAt: time.Now(),
+			Id:              deviceId,
+			OwnerId:         auth.UserId(r),
+			Name:            req.DeviceName,
+			Platform:        req.Platform,
+			Description:     req.Description,
+			SecretHash:      secretHash,
+			SecretUpdatedAt: now,
+			RegisteredAt:    now,
+		}
+		if err := gormDB.Create(&device).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "注册失败")
+			return
 		}
-		gormDB.Create(&device)
+
+		events.Publish(events.DeviceRegistered{
+			DeviceId: deviceId,
+			OwnerId:  device.OwnerId,
+			Name:     device.Name,
+			Platform: device.Platform,
+		})
 
 		utils.Success(w, map[string]any{
-			"message":   "注册成功",
-			"device_id": deviceId,
+			"message":       "注册成功",
+			"device_id":     deviceId,
+			"device_secret": secret,
 		})
 	}
 }
@@ -72,6 +98,11 @@ func UpdateDeviceInfo(db any) http.HandlerFunc {
 
 		gormDB := db.(*gorm.DB)
 
+		// 检查操作权限
+		if !authorizeDevice(w, r, gormDB, policy.Manage, req.DeviceId) {
+			return
+		}
+
 		// 更新设备信息
 		result := gormDB.Where("id = ?", req.DeviceId).Updates(&model.Device{
 			Name:        req.Name,
@@ -98,13 +129,7 @@ func GetDeviceList(db any) http.HandlerFunc {
 			return
 		}
 
-		// 从查询参数获取user_id
-		userId := utils.GetQueryParam(r, "user_id")
-		if userId == "" {
-			utils.Error(w, http.StatusBadRequest, "参数错误: user_id 不能为空")
-			return
-		}
-
+		userId := auth.UserId(r)
 		gormDB := db.(*gorm.DB)
 
 		// 查询设备列表
@@ -113,7 +138,7 @@ func GetDeviceList(db any) http.HandlerFunc {
 
 		utils.Success(w, map[string]any{
 			"message": "查询成功",
-			"devices": devices,
+			"devices": withPresence(devices),
 		})
 	}
 }
@@ -126,13 +151,7 @@ func GetSharedDeviceList(db any) http.HandlerFunc {
 			return
 		}
 
-		// 从查询参数获取user_id
-		userId := utils.GetQueryParam(r, "user_id")
-		if userId == "" {
-			utils.Error(w, http.StatusBadRequest, "参数错误: user_id 不能为空")
-			return
-		}
-
+		userId := auth.UserId(r)
 		gormDB := db.(*gorm.DB)
 
 		// 获取共享给用户的设备(已授权的设备)
@@ -149,7 +168,7 @@ func GetSharedDeviceList(db any) http.HandlerFunc {
 		if len(deviceIds) == 0 {
 			utils.Success(w, map[string]interface{}{
 				"message": "查询成功",
-				"devices": []model.Device{},
+				"devices": []deviceWithPresence{},
 			})
 			return
 		}
@@ -160,7 +179,7 @@ func GetSharedDeviceList(db any) http.HandlerFunc {
 
 		utils.Success(w, map[string]any{
 			"message": "查询成功",
-			"devices": devices,
+			"devices": withPresence(devices),
 		})
 	}
 }
@@ -182,6 +201,11 @@ func GetDeviceInfo(db any) http.HandlerFunc {
 
 		gormDB := db.(*gorm.DB)
 
+		// 检查查看权限
+		if !authorizeDevice(w, r, gormDB, policy.View, deviceId) {
+			return
+		}
+
 		// 查询设备
 		var device model.Device
 		if err := gormDB.Where("id = ?", deviceId).First(&device).Error; err != nil {
@@ -196,6 +220,110 @@ func GetDeviceInfo(db any) http.HandlerFunc {
 	}
 }
 
+// 轮换设备凭证 PUT
+func RotateDeviceSecret(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			DeviceId string `json:"deviceId"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		// 检查操作权限
+		if !authorizeDevice(w, r, gormDB, policy.Report, req.DeviceId) {
+			return
+		}
+
+		// 查询设备
+		var device model.Device
+		if err := gormDB.Where("id = ?", req.DeviceId).First(&device).Error; err != nil {
+			utils.Error(w, http.StatusOK, "设备不存在")
+			return
+		}
+
+		// 生成新凭证, 旧凭证立即失效
+		secret, secretHash, err := auth.NewSecret()
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "设备凭证生成失败")
+			return
+		}
+
+		if err := gormDB.Model(&device).Updates(map[string]any{
+			"secret_hash":       secretHash,
+			"secret_updated_at": time.Now(),
+		}).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "设备凭证更新失败")
+			return
+		}
+
+		// 使用旧凭证建立的 WebSocket 连接立即断开
+		agents.Default.Disconnect(device.Id, "secret rotated")
+
+		utils.Success(w, map[string]any{
+			"message":       "设备凭证轮换成功",
+			"device_secret": secret,
+		})
+	}
+}
+
+// 吊销设备凭证 PUT
+func RevokeDeviceSecret(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			DeviceId string `json:"deviceId"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		// 检查操作权限
+		if !authorizeDevice(w, r, gormDB, policy.Manage, req.DeviceId) {
+			return
+		}
+
+		// 查询设备
+		var device model.Device
+		if err := gormDB.Where("id = ?", req.DeviceId).First(&device).Error; err != nil {
+			utils.Error(w, http.StatusOK, "设备不存在")
+			return
+		}
+
+		// 清空凭证, 设备需重新轮换后才能上报
+		if err := gormDB.Model(&device).Updates(map[string]any{
+			"secret_hash":       "",
+			"secret_updated_at": time.Now(),
+		}).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "设备凭证吊销失败")
+			return
+		}
+
+		agents.Default.Disconnect(device.Id, "secret revoked")
+
+		utils.Success(w, map[string]any{
+			"message": "设备凭证已吊销",
+		})
+	}
+}
+
 // 注销设备 DELETE
 func DeleteDevice(db any) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -215,6 +343,11 @@ func DeleteDevice(db any) http.HandlerFunc {
 
 		gormDB := db.(*gorm.DB)
 
+		// 检查操作权限
+		if !authorizeDevice(w, r, gormDB, policy.Manage, req.Id) {
+			return
+		}
+
 		// 查询设备
 		var device model.Device
 		if err := gormDB.Where("id = ?", req.Id).First(&device).Error; err != nil {
@@ -232,15 +365,59 @@ func DeleteDevice(db any) http.HandlerFunc {
 		}
 
 		// 删除设备状态
-		if err := tx.Where("id = ?", req.Id).Delete(&model.DeviceStatus{}).Error; err != nil {
+		if err := tx.Where("device_id = ?", req.Id).Delete(&model.DeviceStatus{}).Error; err != nil {
 			tx.Rollback()
 			utils.Error(w, http.StatusInternalServerError, "设备注销失败-删除设备状态失败")
 			return
 		}
 
+		// 删除历史状态
+		if err := tx.Where("device_id = ?", req.Id).Delete(&model.DeviceStatusHistory{}).Error; err != nil {
+			tx.Rollback()
+			utils.Error(w, http.StatusInternalServerError, "设备注销失败-删除历史状态失败")
+			return
+		}
+
+		// 删除聚合数据
+		if err := tx.Where("device_id = ?", req.Id).Delete(&model.StatusRollup{}).Error; err != nil {
+			tx.Rollback()
+			utils.Error(w, http.StatusInternalServerError, "设备注销失败-删除聚合数据失败")
+			return
+		}
+
+		// 删除在线状态记录
+		if err := tx.Where("device_id = ?", req.Id).Delete(&model.PresenceEvent{}).Error; err != nil {
+			tx.Rollback()
+			utils.Error(w, http.StatusInternalServerError, "设备注销失败-删除在线状态记录失败")
+			return
+		}
+
+		// 删除自定义指标
+		if err := metrics.Delete(tx, []string{req.Id}); err != nil {
+			tx.Rollback()
+			utils.Error(w, http.StatusInternalServerError, "设备注销失败-删除自定义指标失败")
+			return
+		}
+
+		// 删除应用会话
+		if err := tx.Where("device_id = ?", req.Id).Delete(&model.AppSession{}).Error; err != nil {
+			tx.Rollback()
+			utils.Error(w, http.StatusInternalServerError, "设备注销失败-删除应用会话失败")
+			return
+		}
+
+		// 删除远程命令
+		if err := commands.Delete(tx, []string{req.Id}); err != nil {
+			tx.Rollback()
+			utils.Error(w, http.StatusInternalServerError, "设备注销失败-删除远程命令失败")
+			return
+		}
+
 		// 提交事务
 		tx.Commit()
 
+		events.Publish(events.DeviceDeleted{DeviceId: device.Id, OwnerId: device.OwnerId})
+
 		utils.Success(w, map[string]any{
 			"message": "注销成功",
 		})
@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"math"
+	"net/http"
+	"sloth-tracker/api/config"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/policy"
+	"sloth-tracker/api/rollup"
+	"sloth-tracker/api/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 获取设备历史状态 GET
+func GetStatusHistory(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		deviceID := utils.GetQueryParam(r, "device_id")
+		if deviceID == "" {
+			utils.Error(w, http.StatusBadRequest, "参数错误: device_id 不能为空")
+			return
+		}
+
+		// 时间范围(毫秒时间戳或 RFC3339), 均可省略
+		from, _, err := utils.GetTimeParam(r, "from")
+		if err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误: from 格式不正确")
+			return
+		}
+		to, hasTo, err := utils.GetTimeParam(r, "to")
+		if err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误: to 格式不正确")
+			return
+		}
+		if !hasTo {
+			to = math.MaxInt64
+		}
+
+		fields, err := parseStatusFields(utils.GetQueryParam(r, "fields"))
+		if err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误: "+err.Error())
+			return
+		}
+
+		// 聚合粒度, 默认返回原始数据
+		resolution := utils.GetQueryParamDefault(r, "resolution", "raw")
+		var res rollup.Resolution
+		if resolution != "raw" {
+			var ok bool
+			if res, ok = rollup.ParseResolution(resolution); !ok {
+				utils.Error(w, http.StatusBadRequest, "参数错误: resolution 仅支持 raw, minute, hour, day")
+				return
+			}
+			if fields, err = aggregatedStatusFields(fields, utils.GetQueryParam(r, "fields")); err != nil {
+				utils.Error(w, http.StatusBadRequest, "参数错误: "+err.Error())
+				return
+			}
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		// 与获取最新状态相同, 仅设备所有者或已授权的共享用户可查看
+		if !authorizeDevice(w, r, gormDB, policy.View, deviceID) {
+			return
+		}
+
+		paths := make([]string, 0, len(fields))
+		for _, field := range fields {
+			paths = append(paths, field.Path)
+		}
+
+		page, pageSize := utils.GetPagination(r)
+		var records []map[string]any
+		var total int64
+		if resolution == "raw" {
+			records, total, err = queryRawHistory(gormDB, deviceID, from, to, fields, page, pageSize)
+		} else {
+			records, total, err = queryRollupHistory(gormDB, deviceID, res, from, to, fields, page, pageSize)
+		}
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message":    "查询成功",
+			"device_id":  deviceID,
+			"resolution": resolution,
+			"fields":     paths,
+			"records":    records,
+			"total":      total,
+			"page":       page,
+			"page_size":  pageSize,
+		})
+	}
+}
+
+// 原始历史记录的保留起点(毫秒), 早于该时刻的原始记录已被清理; 永久保留时返回 0
+func rawHistoryStart(now time.Time) int64 {
+	retention := config.Get().HistoryRawRetention
+	if retention <= 0 {
+		return 0
+	}
+	return now.Add(-retention).UnixMilli()
+}
+
+// 查询原始历史数据
+func queryRawHistory(gormDB *gorm.DB, deviceID string, from, to int64, fields []model.StatusField, page, pageSize int) ([]map[string]any, int64, error) {
+	query := gormDB.Model(&model.DeviceStatusHistory{}).
+		Where("device_id = ? AND timestamp >= ? AND timestamp <= ?", deviceID, from, to)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	columns := []string{"timestamp"}
+	for _, field := range fields {
+		columns = append(columns, field.Column)
+	}
+
+	var rows []map[string]any
+	if err := query.Select(columns).
+		Order("timestamp ASC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&rows).Error; err != nil {
+		return nil, 0, err
+	}
+
+	records := make([]map[string]any, 0, len(rows))
+	for _, row := range rows {
+		records = append(records, nestStatusValues(map[string]any{"timestamp": row["timestamp"]}, fields, func(field model.StatusField) (any, bool) {
+			return row[field.Column], true
+		}))
+	}
+	return records, total, nil
+}
+
+// 查询聚合历史数据, 数值字段返回最小/最大/平均值, 状态字段返回各状态持续时间(毫秒)
+func queryRollupHistory(gormDB *gorm.DB, deviceID string, res rollup.Resolution, from, to int64, fields []model.StatusField, page, pageSize int) ([]map[string]any, int64, error) {
+	// 包含 from 所在的时间桶
+	query := gormDB.Model(&model.StatusRollup{}).
+		Where("device_id = ? AND resolution = ? AND bucket_start >= ? AND bucket_start <= ?",
+			deviceID, res.Name, from-from%res.Size.Milliseconds(), to)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var rows []model.StatusRollup
+	if err := query.Order("bucket_start ASC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&rows).Error; err != nil {
+		return nil, 0, err
+	}
+
+	records := make([]map[string]any, 0, len(rows))
+	for _, row := range rows {
+		agg, err := rollup.Decode(row.Data)
+		if err != nil {
+			return nil, 0, err
+		}
+		record := map[string]any{"timestamp": row.BucketStart, "samples": row.Samples}
+		records = append(records, nestStatusValues(record, fields, func(field model.StatusField) (any, bool) {
+			if field.Kind == model.NumericField {
+				stats, ok := agg.Numeric[field.Path]
+				return stats, ok
+			}
+			states, ok := agg.States[field.Path]
+			return states, ok
+		}))
+	}
+	return records, total, nil
+}
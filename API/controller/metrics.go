@@ -0,0 +1,294 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sloth-tracker/api/metrics"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/policy"
+	"sloth-tracker/api/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 取出上报请求体中的自定义指标, 请求体已在合并状态时校验为 JSON 对象
+func rawMetrics(body []byte) json.RawMessage {
+	var req struct {
+		Metrics json.RawMessage `json:"metrics"`
+	}
+	json.Unmarshal(body, &req)
+	return req.Metrics
+}
+
+// 解析或记录指标失败的原因, 校验错误以字段错误的形式返回
+func metricsFailure(err error, prefix string) *reportError {
+	if errors.Is(err, metrics.ErrInvalid) || errors.Is(err, metrics.ErrTypeMismatch) || errors.Is(err, metrics.ErrTooMany) {
+		return &reportError{
+			Code:    http.StatusBadRequest,
+			Message: "参数校验失败",
+			Fields:  utils.FieldErrors{{Path: prefix + "metrics", Reason: err.Error()}},
+		}
+	}
+	return &reportError{Code: http.StatusInternalServerError, Message: "保存指标失败"}
+}
+
+// 解析或记录指标失败时的错误响应
+func metricsError(w http.ResponseWriter, err error, prefix string) {
+	metricsFailure(err, prefix).write(w)
+}
+
+// 获取设备的自定义指标 GET
+func GetMetrics(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		deviceId := utils.GetQueryParam(r, "device_id")
+		if deviceId == "" {
+			utils.Error(w, http.StatusBadRequest, "参数错误: device_id 不能为空")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		if !authorizeDevice(w, r, gormDB, policy.View, deviceId) {
+			return
+		}
+
+		list, err := metrics.Latest(gormDB, deviceId)
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "查询成功",
+			"metrics": list,
+		})
+	}
+}
+
+// 注册或修改自定义指标 PUT
+// 指标类型注册后不能修改, 单位与描述可以修改
+func DefineMetric(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			DeviceId    string `json:"device_id"`
+			Name        string `json:"name"`
+			Type        string `json:"type"`
+			Unit        string `json:"unit"`
+			Description string `json:"description"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		if req.DeviceId == "" {
+			utils.Error(w, http.StatusBadRequest, "参数错误: device_id 不能为空")
+			return
+		}
+		if err := metrics.ValidateName(req.Name); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误: "+err.Error())
+			return
+		}
+		if !metrics.ValidType(req.Type) {
+			utils.Error(w, http.StatusBadRequest, "参数错误: type 仅支持 gauge, counter, enum, string")
+			return
+		}
+		if err := metrics.ValidateUnit(req.Unit); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误: "+err.Error())
+			return
+		}
+		if len(req.Description) > 256 {
+			utils.Error(w, http.StatusBadRequest, "参数错误: description 过长")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		if !authorizeDevice(w, r, gormDB, policy.Manage, req.DeviceId) {
+			return
+		}
+
+		var definition model.MetricDefinition
+		err := gormDB.Where("device_id = ? AND name = ?", req.DeviceId, req.Name).First(&definition).Error
+		switch {
+		case err == nil:
+			if definition.Type != req.Type {
+				utils.Error(w, http.StatusBadRequest, "指标已注册为 "+definition.Type+", 类型不能修改")
+				return
+			}
+			definition.Unit = req.Unit
+			definition.Description = req.Description
+			err = gormDB.Save(&definition).Error
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			var count int64
+			if err := gormDB.Model(&model.MetricDefinition{}).Where("device_id = ?", req.DeviceId).Count(&count).Error; err != nil {
+				utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+				return
+			}
+			if count >= metrics.MaxPerDevice {
+				utils.Error(w, http.StatusBadRequest, metrics.ErrTooMany.Error())
+				return
+			}
+			definition = model.MetricDefinition{
+				Id:          uuid.New().String(),
+				DeviceId:    req.DeviceId,
+				Name:        req.Name,
+				Type:        req.Type,
+				Unit:        req.Unit,
+				Description: req.Description,
+				CreatedAt:   time.Now(),
+			}
+			err = gormDB.Create(&definition).Error
+		}
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "保存指标失败")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "指标保存成功",
+			"metric":  definition,
+		})
+	}
+}
+
+// 删除自定义指标及其历史 DELETE
+func DeleteMetric(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			DeviceId string `json:"device_id"`
+			Name     string `json:"name"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		if req.DeviceId == "" || req.Name == "" {
+			utils.Error(w, http.StatusBadRequest, "参数错误: device_id 与 name 不能为空")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		if !authorizeDevice(w, r, gormDB, policy.Manage, req.DeviceId) {
+			return
+		}
+
+		if err := gormDB.Transaction(func(tx *gorm.DB) error {
+			return metrics.Delete(tx, []string{req.DeviceId}, req.Name)
+		}); err != nil {
+			utils.Error(w, http.StatusInternalServerError, "删除指标失败")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "指标删除成功",
+		})
+	}
+}
+
+// 获取自定义指标的历史记录 GET
+func GetMetricHistory(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		deviceId := utils.GetQueryParam(r, "device_id")
+		name := utils.GetQueryParam(r, "name")
+		if deviceId == "" || name == "" {
+			utils.Error(w, http.StatusBadRequest, "参数错误: device_id 与 name 不能为空")
+			return
+		}
+
+		// 时间范围(毫秒时间戳或 RFC3339), 均可省略
+		from, _, err := utils.GetTimeParam(r, "from")
+		if err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误: from 格式不正确")
+			return
+		}
+		to, hasTo, err := utils.GetTimeParam(r, "to")
+		if err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误: to 格式不正确")
+			return
+		}
+		if !hasTo {
+			to = math.MaxInt64
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		if !authorizeDevice(w, r, gormDB, policy.View, deviceId) {
+			return
+		}
+
+		var definition model.MetricDefinition
+		if err := gormDB.Where("device_id = ? AND name = ?", deviceId, name).First(&definition).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				utils.Error(w, http.StatusNotFound, "指标不存在")
+			} else {
+				utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			}
+			return
+		}
+
+		query := gormDB.Model(&model.MetricSample{}).
+			Where("device_id = ? AND name = ? AND timestamp >= ? AND timestamp <= ?", deviceId, name, from, to)
+
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+
+		page, pageSize := utils.GetPagination(r)
+		var samples []model.MetricSample
+		if err := query.Order("timestamp ASC").
+			Offset((page - 1) * pageSize).
+			Limit(pageSize).
+			Find(&samples).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+
+		records := make([]map[string]any, 0, len(samples))
+		for _, sample := range samples {
+			records = append(records, map[string]any{
+				"timestamp": sample.Timestamp,
+				"value":     metrics.Value(sample.Number, sample.Text),
+			})
+		}
+
+		utils.Success(w, map[string]any{
+			"message":   "查询成功",
+			"metric":    definition,
+			"records":   records,
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		})
+	}
+}
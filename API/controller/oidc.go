@@ -0,0 +1,421 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/config"
+	"sloth-tracker/api/limiter"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/oidc"
+	"sloth-tracker/api/utils"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OIDC 登录 GET, 跳转到身份提供方(format=json 时返回授权地址)
+func OIDCLogin(db any, provider *oidc.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		returnTo := utils.GetQueryParam(r, "return_to")
+		if returnTo != "" && !allowedReturnURL(returnTo) {
+			utils.Error(w, http.StatusBadRequest, "参数错误: return_to 不在允许列表中")
+			return
+		}
+
+		authURL, binding, err := provider.Begin(r.Context(), "", returnTo)
+		if err != nil {
+			beginError(w, "登录", err)
+			return
+		}
+		setOIDCBinding(w, r, binding)
+
+		if utils.GetQueryParam(r, "format") == "json" {
+			utils.Success(w, map[string]any{
+				"message": "请在浏览器中完成登录",
+				"url":     authURL,
+			})
+			return
+		}
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// 绑定外部账户 POST, 返回身份提供方的授权地址
+func OIDCLink(db any, provider *oidc.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			Password    string `json:"password"`
+			ReauthToken string `json:"reauth_token"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		returnTo := utils.GetQueryParam(r, "return_to")
+		if returnTo != "" && !allowedReturnURL(returnTo) {
+			utils.Error(w, http.StatusBadRequest, "参数错误: return_to 不在允许列表中")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		var user model.User
+		if err := gormDB.First(&user, "id = ?", auth.UserId(r)).Error; err != nil {
+			utils.Error(w, http.StatusOK, "用户不存在")
+			return
+		}
+
+		// 绑定后可通过外部账户登录及重新验证身份, 需确认身份
+		if err := confirmIdentity(gormDB, &user, req.Password, req.ReauthToken); err != nil {
+			limiter.Failed(r)
+			utils.Error(w, http.StatusOK, err.Error())
+			return
+		}
+		limiter.Succeeded(r)
+
+		authURL, binding, err := provider.Begin(r.Context(), user.Id, returnTo)
+		if err != nil {
+			beginError(w, "绑定", err)
+			return
+		}
+		setOIDCBinding(w, r, binding)
+
+		utils.Success(w, map[string]any{
+			"message": "请在浏览器中完成绑定",
+			"url":     authURL,
+		})
+	}
+}
+
+// 重新验证身份 POST, 无密码账户通过已绑定的外部账户重新登录, 回调返回 reauth_token 用于确认敏感操作
+func OIDCReauth(db any, provider *oidc.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		returnTo := utils.GetQueryParam(r, "return_to")
+		if returnTo != "" && !allowedReturnURL(returnTo) {
+			utils.Error(w, http.StatusBadRequest, "参数错误: return_to 不在允许列表中")
+			return
+		}
+
+		authURL, binding, err := provider.BeginReauth(r.Context(), auth.UserId(r), returnTo)
+		if err != nil {
+			beginError(w, "重新验证", err)
+			return
+		}
+		setOIDCBinding(w, r, binding)
+
+		utils.Success(w, map[string]any{
+			"message": "请在浏览器中完成验证",
+			"url":     authURL,
+		})
+	}
+}
+
+// OIDC 回调 GET
+func OIDCCallback(db any, provider *oidc.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		if errCode := utils.GetQueryParam(r, "error"); errCode != "" {
+			utils.Error(w, http.StatusUnauthorized, "身份提供方拒绝登录: "+errCode)
+			return
+		}
+
+		var binding string
+		if cookie, err := r.Cookie(oidcBindingCookie); err == nil {
+			binding = cookie.Value
+		}
+		clearOIDCBinding(w, r)
+
+		claims, pending, err := provider.Complete(r.Context(), utils.GetQueryParam(r, "state"), binding, utils.GetQueryParam(r, "code"))
+		if err != nil {
+			switch {
+			case errors.Is(err, oidc.ErrUnknownState):
+				utils.Error(w, http.StatusBadRequest, "登录请求不存在或已过期, 请重新登录")
+			case errors.Is(err, oidc.ErrBindingMismatch):
+				utils.Error(w, http.StatusBadRequest, "登录请求不是由当前浏览器发起, 请重新登录")
+			default:
+				log.Printf("OIDC 回调失败: %v", err)
+				utils.Error(w, http.StatusUnauthorized, "外部账户校验失败")
+			}
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+		subject := oidc.ClaimString(claims, "sub")
+
+		// 绑定到已登录的账户
+		if pending.LinkUserId != "" {
+			if err := linkIdentity(gormDB, pending.LinkUserId, provider.Issuer(), subject, claims); err != nil {
+				utils.Error(w, http.StatusOK, err.Error())
+				return
+			}
+			respondOIDC(w, r, pending.ReturnTo, map[string]any{
+				"message": "外部账户绑定成功",
+			})
+			return
+		}
+
+		// 重新验证身份, 外部账户须已绑定到发起验证的账户
+		if pending.ReauthUserId != "" {
+			var count int64
+			if err := gormDB.Model(&model.ExternalIdentity{}).
+				Where("issuer = ? AND subject = ? AND user_id = ?", provider.Issuer(), subject, pending.ReauthUserId).
+				Count(&count).Error; err != nil {
+				utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+				return
+			}
+			if count == 0 {
+				utils.Error(w, http.StatusForbidden, "该外部账户未绑定当前账户")
+				return
+			}
+			token, err := auth.IssueReauth(config.Get().TokenSecret, pending.ReauthUserId, reauthTTL)
+			if err != nil {
+				utils.Error(w, http.StatusInternalServerError, "令牌签发失败")
+				return
+			}
+			respondOIDC(w, r, pending.ReturnTo, map[string]any{
+				"message":      "身份验证成功",
+				"reauth_token": token,
+			})
+			return
+		}
+
+		// 查找已绑定的账户, 未绑定时按配置自动创建
+		var identity model.ExternalIdentity
+		err = gormDB.Where("issuer = ? AND subject = ?", provider.Issuer(), subject).First(&identity).Error
+		userId := identity.UserId
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if !config.Get().OIDCAutoCreate {
+				utils.Error(w, http.StatusForbidden, "该外部账户未绑定本站账户")
+				return
+			}
+			userId, err = createOIDCUser(gormDB, provider.Issuer(), subject, claims)
+		}
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+
+		// 已启用两步验证时与密码登录相同, 需提交验证码后才能登录
+		var user model.User
+		if err := gormDB.Select("id", "disabled", "totp_enabled").First(&user, "id = ?", userId).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+		if user.TotpEnabled && !user.Disabled {
+			challenge, err := auth.IssueChallenge(config.Get().TokenSecret, user.Id, challengeTTL)
+			if err != nil {
+				utils.Error(w, http.StatusInternalServerError, "令牌签发失败")
+				return
+			}
+			respondOIDC(w, r, pending.ReturnTo, map[string]any{
+				"message":             "请输入两步验证码",
+				"two_factor_required": true,
+				"challenge":           challenge,
+			})
+			return
+		}
+
+		tokens, err := createSession(gormDB, r, userId, "")
+		if err != nil {
+			sessionError(w, err)
+			return
+		}
+
+		tokens["message"] = "登录成功"
+		respondOIDC(w, r, pending.ReturnTo, tokens)
+	}
+}
+
+// 开始登录失败时的响应
+func beginError(w http.ResponseWriter, action string, err error) {
+	if errors.Is(err, oidc.ErrTooManyPending) {
+		utils.Error(w, http.StatusServiceUnavailable, "登录请求过多, 请稍后再试")
+		return
+	}
+	log.Printf("OIDC %s失败: %v", action, err)
+	utils.Error(w, http.StatusBadGateway, "连接身份提供方失败")
+}
+
+// 将外部账户绑定到用户
+func linkIdentity(gormDB *gorm.DB, userId, issuer, subject string, claims map[string]any) error {
+	var existing model.ExternalIdentity
+	err := gormDB.Where("issuer = ? AND subject = ?", issuer, subject).First(&existing).Error
+	if err == nil {
+		if existing.UserId == userId {
+			return nil
+		}
+		return errors.New("该外部账户已绑定其他账户")
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.New("查询数据库出错")
+	}
+
+	if err := gormDB.Create(&model.ExternalIdentity{
+		Id:        uuid.New().String(),
+		UserId:    userId,
+		Issuer:    issuer,
+		Subject:   subject,
+		Email:     oidc.ClaimString(claims, "email"),
+		CreatedAt: time.Now(),
+	}).Error; err != nil {
+		return errors.New("外部账户绑定失败")
+	}
+	return nil
+}
+
+// 为外部账户创建本站账户(无密码, 只能通过外部账户登录)
+func createOIDCUser(gormDB *gorm.DB, issuer, subject string, claims map[string]any) (string, error) {
+	userId := uuid.New().String()
+	err := gormDB.Transaction(func(tx *gorm.DB) error {
+		name, err := uniqueUsername(tx, oidcUsername(claims))
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if err := tx.Create(&model.User{
+			Id:           userId,
+			Name:         name,
+			RegisteredAt: now,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.ExternalIdentity{
+			Id:        uuid.New().String(),
+			UserId:    userId,
+			Issuer:    issuer,
+			Subject:   subject,
+			Email:     oidc.ClaimString(claims, "email"),
+			CreatedAt: now,
+		}).Error
+	})
+	return userId, err
+}
+
+// 按配置的声明映射用户名, 依次回退到邮箱前缀与 sub
+func oidcUsername(claims map[string]any) string {
+	if name := oidc.ClaimString(claims, config.Get().OIDCUsernameClaim); name != "" {
+		return name
+	}
+	if email := oidc.ClaimString(claims, "email"); email != "" {
+		local, _, _ := strings.Cut(email, "@")
+		return local
+	}
+	return oidc.ClaimString(claims, "sub")
+}
+
+// 用户名已存在时追加数字后缀
+func uniqueUsername(tx *gorm.DB, base string) (string, error) {
+	name := base
+	for i := 2; ; i++ {
+		var count int64
+		if err := tx.Model(&model.User{}).Where("name = ?", name).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return name, nil
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// 保存发起登录的浏览器绑定值的 Cookie
+const oidcBindingCookie = "sloth_oidc_binding"
+
+// 在发起登录的浏览器中保存绑定值, 回调时校验, 防止授权地址被转交给他人完成登录或绑定
+// (通过接口获取授权地址的客户端需携带凭据发送请求, 使浏览器保存该 Cookie)
+func setOIDCBinding(w http.ResponseWriter, r *http.Request, binding string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcBindingCookie,
+		Value:    binding,
+		Path:     "/api/oidc",
+		MaxAge:   int(oidc.PendingTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// 回调后删除绑定值
+func clearOIDCBinding(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcBindingCookie,
+		Path:     "/api/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// 请求是否通过 HTTPS 到达(信任反向代理时读取 X-Forwarded-Proto)
+func secureRequest(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return config.Get().TrustProxy && r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// 返回登录结果, 指定了客户端地址时通过 URL 片段携带结果跳转
+func respondOIDC(w http.ResponseWriter, r *http.Request, returnTo string, data map[string]any) {
+	if returnTo == "" {
+		utils.Success(w, data)
+		return
+	}
+	fragment := url.Values{}
+	for key, value := range data {
+		fragment.Set(key, fmt.Sprint(value))
+	}
+	http.Redirect(w, r, returnTo+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// 检查跳转地址是否在允许列表中: 协议, 主机与端口须完全一致, 路径按段匹配前缀
+func allowedReturnURL(returnTo string) bool {
+	target, err := url.Parse(returnTo)
+	if err != nil || target.User != nil || target.Host == "" || target.Opaque != "" {
+		return false
+	}
+	for _, allowed := range config.Get().OIDCReturnURLs {
+		base, err := url.Parse(allowed)
+		if err != nil || base.User != nil || base.Host == "" {
+			continue
+		}
+		if !strings.EqualFold(target.Scheme, base.Scheme) || !strings.EqualFold(target.Host, base.Host) {
+			continue
+		}
+		prefix := strings.TrimSuffix(base.EscapedPath(), "/")
+		path := target.EscapedPath()
+		if prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
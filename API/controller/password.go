@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/config"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/password"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 校验用户密码, 哈希算法或参数过时时顺便用当前配置重新生成
+func checkPassword(gormDB *gorm.DB, user *model.User, plain string) bool {
+	ok, rehash := password.Default().Verify(user.Password, plain)
+	if !ok {
+		return false
+	}
+	if rehash {
+		hashedPassword, err := password.Default().Hash(plain)
+		if err == nil {
+			err = gormDB.Model(user).Update("password", hashedPassword).Error
+		}
+		if err != nil {
+			log.Printf("密码哈希升级失败: %s: %v", user.Id, err)
+		}
+	}
+	return true
+}
+
+// 重新验证身份凭据的有效期
+const reauthTTL = 5 * time.Minute
+
+// 敏感操作前确认用户身份: 有密码的账户校验当前密码;
+// 通过外部账户创建的无密码账户需先经 /api/oidc/reauth 重新登录, 提交得到的 reauth_token
+func confirmIdentity(gormDB *gorm.DB, user *model.User, plain, reauthToken string) error {
+	if user.Password != "" {
+		if !checkPassword(gormDB, user, plain) {
+			return errors.New("密码错误")
+		}
+		return nil
+	}
+	userId, err := auth.ParseReauth(config.Get().TokenSecret, reauthToken)
+	if err != nil || userId != user.Id {
+		return errors.New("请先通过外部账户重新验证身份")
+	}
+	return nil
+}
+
+// 设置新密码并吊销该用户的所有会话
+func setPassword(gormDB *gorm.DB, user *model.User, plain string) error {
+	hashedPassword, err := password.Default().Hash(plain)
+	if err != nil {
+		return err
+	}
+	return gormDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+		return revokeSessions(tx, user.Id, "")
+	})
+}
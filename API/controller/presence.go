@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"net/http"
+	"sloth-tracker/api/agents"
+	"sloth-tracker/api/config"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/policy"
+	"sloth-tracker/api/presence"
+	"sloth-tracker/api/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 设备信息及在线状态
+type deviceWithPresence struct {
+	model.Device
+	Presence   presence.State `json:"presence"`    // 在线状态(online, stale, offline)
+	AgeSeconds *int64         `json:"age_seconds"` // 距最近上报的秒数(从未上报时为空)
+	Connected  bool           `json:"connected"`   // 是否保持 WebSocket 连接
+}
+
+// 按服务器配置计算在线状态
+func devicePresence(lastSeen *time.Time) (presence.State, *int64) {
+	cfg := config.Get()
+	state, age := presence.Of(lastSeen, time.Now(), presence.Options{
+		HeartbeatTimeout: cfg.HeartbeatTimeout,
+		OfflineTimeout:   cfg.OfflineTimeout,
+	})
+	if age < 0 {
+		return state, nil
+	}
+	seconds := int64(age.Seconds())
+	return state, &seconds
+}
+
+// 为设备列表附加在线状态
+func withPresence(devices []model.Device) []deviceWithPresence {
+	result := make([]deviceWithPresence, 0, len(devices))
+	for _, device := range devices {
+		state, age := devicePresence(device.LastSeenAt)
+		result = append(result, deviceWithPresence{
+			Device:     device,
+			Presence:   state,
+			AgeSeconds: age,
+			Connected:  agents.Default.Connected(device.Id),
+		})
+	}
+	return result
+}
+
+// 获取设备在线状态变化记录 GET
+func GetPresenceEvents(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		deviceId := utils.GetQueryParam(r, "device_id")
+		if deviceId == "" {
+			utils.Error(w, http.StatusBadRequest, "参数错误: device_id 不能为空")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		if !authorizeDevice(w, r, gormDB, policy.View, deviceId) {
+			return
+		}
+
+		page, pageSize := utils.GetPagination(r)
+		query := gormDB.Model(&model.PresenceEvent{}).Where("device_id = ?", deviceId)
+
+		var total int64
+		query.Count(&total)
+
+		var events []model.PresenceEvent
+		query.Order("created_at DESC").
+			Offset((page - 1) * pageSize).
+			Limit(pageSize).
+			Find(&events)
+
+		utils.Success(w, map[string]any{
+			"message": "查询成功",
+			"events":  events,
+			"total":   total,
+			"page":    page,
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/limiter"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/password"
+	"sloth-tracker/api/utils"
+
+	"gorm.io/gorm"
+)
+
+// 统计未使用的恢复码数量
+func remainingRecoveryCodes(gormDB *gorm.DB, userId string) int64 {
+	var count int64
+	gormDB.Model(&model.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userId).Count(&count)
+	return count
+}
+
+// 重新生成恢复码 POST
+func RegenerateRecoveryCodes(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			Password    string `json:"password"`
+			ReauthToken string `json:"reauth_token"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		var user model.User
+		if err := gormDB.First(&user, "id = ?", auth.UserId(r)).Error; err != nil {
+			utils.Error(w, http.StatusOK, "用户不存在")
+			return
+		}
+
+		// 生成前需确认身份(当前密码或重新验证凭据)
+		if err := confirmIdentity(gormDB, &user, req.Password, req.ReauthToken); err != nil {
+			limiter.Failed(r)
+			utils.Error(w, http.StatusOK, err.Error())
+			return
+		}
+		limiter.Succeeded(r)
+
+		var codes []string
+		err := gormDB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			codes, err = generateRecoveryCodes(tx, user.Id)
+			return err
+		})
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "恢复码生成失败")
+			return
+		}
+		recordAudit(gormDB, r, user.Id, auditRecoveryCodesGenerated, "")
+
+		utils.Success(w, map[string]any{
+			"message":        "恢复码已重新生成, 旧恢复码已失效, 请妥善保存",
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// 使用恢复码找回账户 POST
+func RecoverAccount(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			Name         string `json:"name"`
+			RecoveryCode string `json:"recovery_code"`
+			TotpCode     string `json:"totp_code"` // 已启用两步验证时必填
+			NewPassword  string `json:"new_password"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		if err := password.Default().Validate(req.NewPassword); err != nil {
+			utils.Error(w, http.StatusOK, err.Error())
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		// 用户不存在, 账户已禁用与校验失败返回相同提示; 禁用账户的恢复码不会被消耗
+		const failed = "用户名, 恢复码或两步验证码错误"
+		var user model.User
+		if err := gormDB.Where("name = ?", req.Name).First(&user).Error; err != nil || user.Disabled {
+			limiter.Failed(r)
+			utils.Error(w, http.StatusOK, failed)
+			return
+		}
+
+		// 恢复码同时可用于两步验证登录, 已启用两步验证时还需提交验证码, 先于恢复码校验以免消耗恢复码
+		if user.TotpEnabled && !useTOTPCode(gormDB, &user, req.TotpCode) {
+			limiter.Failed(r)
+			recordAudit(gormDB, r, user.Id, auditRecoveryFailed, "两步验证码错误")
+			utils.Error(w, http.StatusOK, failed)
+			return
+		}
+
+		ok, err := useRecoveryCode(gormDB, user.Id, req.RecoveryCode)
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+		if !ok {
+			limiter.Failed(r)
+			recordAudit(gormDB, r, user.Id, auditRecoveryFailed, "")
+			utils.Error(w, http.StatusOK, failed)
+			return
+		}
+		limiter.Succeeded(r)
+
+		if err := setPassword(gormDB, &user, req.NewPassword); err != nil {
+			utils.Error(w, http.StatusInternalServerError, "密码重置失败")
+			return
+		}
+		recordAudit(gormDB, r, user.Id, auditPasswordRecovered, "")
+
+		utils.Success(w, map[string]any{
+			"message":                  "密码重置成功, 请重新登录",
+			"recovery_codes_remaining": remainingRecoveryCodes(gormDB, user.Id),
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/config"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/utils"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var errUserDisabled = errors.New("账户已被禁用")
+
+// 创建会话并签发令牌, 返回登录响应中的令牌字段
+func createSession(gormDB *gorm.DB, r *http.Request, userId, device string) (map[string]any, error) {
+	var user model.User
+	if err := gormDB.Select("id", "disabled").First(&user, "id = ?", userId).Error; err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, errUserDisabled
+	}
+
+	secret, secretHash, err := auth.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if device == "" {
+		device = r.UserAgent()
+	}
+
+	cfg := config.Get()
+	now := time.Now()
+	session := model.Session{
+		Id:          uuid.New().String(),
+		UserId:      userId,
+		RefreshHash: secretHash,
+		Device:      device,
+		Ip:          utils.ClientIP(r),
+		CreatedAt:   now,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(cfg.RefreshTTL),
+	}
+	if err := gormDB.Create(&session).Error; err != nil {
+		return nil, err
+	}
+
+	return issueSessionTokens(session, secret)
+}
+
+// 为会话签发访问令牌, 刷新令牌格式为 <会话ID>.<随机凭证>
+func issueSessionTokens(session model.Session, secret string) (map[string]any, error) {
+	cfg := config.Get()
+	token, err := auth.IssueToken(cfg.TokenSecret, session.UserId, session.Id, cfg.TokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"user_id":       session.UserId,
+		"session_id":    session.Id,
+		"access_token":  token,
+		"refresh_token": session.Id + "." + secret,
+		"expires_in":    int64(cfg.TokenTTL.Seconds()),
+	}, nil
+}
+
+// 会话创建失败时写入错误响应
+func sessionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUserDisabled) {
+		utils.Error(w, http.StatusForbidden, "账户已被禁用")
+		return
+	}
+	utils.Error(w, http.StatusInternalServerError, "令牌签发失败")
+}
+
+// 吊销用户的会话, exceptId 不为空时保留该会话
+func revokeSessions(gormDB *gorm.DB, userId, exceptId string) error {
+	query := gormDB.Model(&model.Session{}).Where("user_id = ? AND revoked_at IS NULL", userId)
+	if exceptId != "" {
+		query = query.Where("id <> ?", exceptId)
+	}
+	return query.Update("revoked_at", time.Now()).Error
+}
+
+// 刷新令牌 POST
+func RefreshSession(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		sessionId, secret, ok := strings.Cut(req.RefreshToken, ".")
+		if !ok || sessionId == "" || secret == "" {
+			utils.Error(w, http.StatusUnauthorized, "刷新令牌无效")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		// 检查会话是否有效
+		var session model.Session
+		if err := gormDB.Where("id = ?", sessionId).First(&session).Error; err != nil {
+			utils.Error(w, http.StatusUnauthorized, "刷新令牌无效")
+			return
+		}
+		now := time.Now()
+		if session.RevokedAt != nil || now.After(session.ExpiresAt) {
+			utils.Error(w, http.StatusUnauthorized, "会话已失效")
+			return
+		}
+
+		// 检查账户是否被禁用
+		var user model.User
+		if err := gormDB.Select("id", "disabled").First(&user, "id = ?", session.UserId).Error; err != nil || user.Disabled {
+			utils.Error(w, http.StatusForbidden, "账户已被禁用")
+			return
+		}
+
+		// 刷新令牌每次使用后轮换, 旧令牌被重复使用说明可能已泄露, 直接吊销会话
+		if !auth.VerifySecret(secret, session.RefreshHash) {
+			gormDB.Model(&session).Update("revoked_at", now)
+			utils.Error(w, http.StatusUnauthorized, "刷新令牌无效, 会话已吊销")
+			return
+		}
+
+		newSecret, newHash, err := auth.NewSecret()
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "令牌签发失败")
+			return
+		}
+		if err := gormDB.Model(&session).Updates(map[string]any{
+			"refresh_hash": newHash,
+			"last_used_at": now,
+			"ip":           utils.ClientIP(r),
+		}).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "会话更新失败")
+			return
+		}
+
+		tokens, err := issueSessionTokens(session, newSecret)
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "令牌签发失败")
+			return
+		}
+		tokens["message"] = "刷新成功"
+		utils.Success(w, tokens)
+	}
+}
+
+// 退出登录 POST
+func Logout(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		if err := gormDB.Model(&model.Session{}).
+			Where("id = ? AND user_id = ?", auth.SessionId(r), auth.UserId(r)).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "退出登录失败")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "退出登录成功",
+		})
+	}
+}
+
+// 退出所有设备 POST
+func LogoutAll(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		if err := revokeSessions(gormDB, auth.UserId(r), ""); err != nil {
+			utils.Error(w, http.StatusInternalServerError, "退出登录失败")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "已退出所有设备",
+		})
+	}
+}
+
+// 退出其他设备 POST
+func LogoutOthers(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		if err := revokeSessions(gormDB, auth.UserId(r), auth.SessionId(r)); err != nil {
+			utils.Error(w, http.StatusInternalServerError, "退出登录失败")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "已退出其他设备",
+		})
+	}
+}
+
+// 获取会话列表 GET
+func GetSessions(db any) http.HandlerFunc {
+	type SessionInfo struct {
+		model.Session
+		Current bool `json:"current"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		// 查询有效会话
+		var sessions []model.Session
+		gormDB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", auth.UserId(r), time.Now()).
+			Order("last_used_at DESC").
+			Find(&sessions)
+
+		result := []SessionInfo{}
+		for _, session := range sessions {
+			result = append(result, SessionInfo{
+				Session: session,
+				Current: session.Id == auth.SessionId(r),
+			})
+		}
+
+		utils.Success(w, map[string]any{
+			"message":  "查询成功",
+			"sessions": result,
+		})
+	}
+}
+
+// 吊销指定会话 DELETE
+func RevokeSession(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			Id string `json:"id"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		result := gormDB.Model(&model.Session{}).
+			Where("id = ? AND user_id = ? AND revoked_at IS NULL", req.Id, auth.UserId(r)).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			utils.Error(w, http.StatusInternalServerError, "吊销会话失败")
+			return
+		}
+		if result.RowsAffected == 0 {
+			utils.Error(w, http.StatusOK, "会话不存在")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "会话已吊销",
+		})
+	}
+}
@@ -3,7 +3,10 @@ package controller
 import (
 	"encoding/json"
 	"net/http"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/events"
 	"sloth-tracker/api/model"
+	"sloth-tracker/api/policy"
 	"sloth-tracker/api/utils"
 	"time"
 
@@ -21,7 +24,6 @@ func ApplyShare(db any) http.HandlerFunc {
 
 		var req struct {
 			DeviceId string `json:"deviceId"`
-			ViewerId string `json:"viewerId"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -29,6 +31,7 @@ func ApplyShare(db any) http.HandlerFunc {
 			return
 		}
 
+		viewerId := auth.UserId(r)
 		gormDB := db.(*gorm.DB)
 
 		// 检查设备是否存在
@@ -41,21 +44,21 @@ func ApplyShare(db any) http.HandlerFunc {
 
 		// 检查用户是否存在
 		var viewer model.User
-		gormDB.Where("id = ?", req.ViewerId).First(&viewer)
+		gormDB.Where("id = ?", viewerId).First(&viewer)
 		if viewer.Id == "" {
 			utils.Error(w, http.StatusOK, "用户不存在")
 			return
 		}
 
 		// 禁止申请自己的设备
-		if device.OwnerId == req.ViewerId {
+		if device.OwnerId == viewerId {
 			utils.Error(w, http.StatusOK, "禁止申请自己的设备")
 			return
 		}
 
 		// 检查是否已存在授权记录
 		var existingShared model.SharedDevice
-		gormDB.Where("device_id = ? AND viewer_id = ?", req.DeviceId, req.ViewerId).First(&existingShared)
+		gormDB.Where("device_id = ? AND viewer_id = ?", req.DeviceId, viewerId).First(&existingShared)
 		if existingShared.Id != "" {
 			utils.Error(w, http.StatusOK, "已存在授权记录")
 			return
@@ -65,11 +68,21 @@ func ApplyShare(db any) http.HandlerFunc {
 		shared := model.SharedDevice{
 			Id:            uuid.New().String(),
 			DeviceId:      req.DeviceId,
-			ViewerId:      req.ViewerId,
+			ViewerId:      viewerId,
 			Authorization: 2, // 2表示待授权
 			CreatedAt:     time.Now(),
 		}
-		gormDB.Create(&shared)
+		if err := gormDB.Create(&shared).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "申请分享失败")
+			return
+		}
+
+		events.Publish(events.ShareRequested{
+			ShareId:  shared.Id,
+			DeviceId: device.Id,
+			OwnerId:  device.OwnerId,
+			ViewerId: viewerId,
+		})
 
 		utils.Success(w, map[string]any{
 			"message": "申请分享成功, 等待设备所有者授权",
@@ -94,13 +107,7 @@ func GetUserApplications(db any) http.HandlerFunc {
 			return
 		}
 
-		// 从查询参数获取user_id
-		userId := utils.GetQueryParam(r, "user_id")
-		if userId == "" {
-			utils.Error(w, http.StatusBadRequest, "参数错误: user_id 不能为空")
-			return
-		}
-
+		userId := auth.UserId(r)
 		gormDB := db.(*gorm.DB)
 
 		// 查询用户申请的授权
@@ -151,13 +158,7 @@ func GetSharedAuthorizations(db any) http.HandlerFunc {
 			return
 		}
 
-		// 从查询参数获取user_id
-		userId := utils.GetQueryParam(r, "user_id")
-		if userId == "" {
-			utils.Error(w, http.StatusBadRequest, "参数错误: user_id 不能为空")
-			return
-		}
-
+		userId := auth.UserId(r)
 		gormDB := db.(*gorm.DB)
 
 		// 获取用户的所有设备ID
@@ -224,11 +225,9 @@ func AuthorizeDevice(db any) http.HandlerFunc {
 
 		gormDB := db.(*gorm.DB)
 
-		// 检查授权记录是否存在
-		var shared model.SharedDevice
-		gormDB.Where("id = ?", req.AccessId).First(&shared)
-		if shared.Id == "" {
-			utils.Error(w, http.StatusOK, "授权记录不存在")
+		// 检查授权记录是否存在及审批权限
+		shared, ok, err := policy.CanApproveShare(gormDB, auth.UserId(r), req.AccessId)
+		if !authorizeShare(w, ok, err) {
 			return
 		}
 
@@ -239,8 +238,24 @@ func AuthorizeDevice(db any) http.HandlerFunc {
 		}
 
 		// 更新授权状态
+		approved := shared.Authorization == 1
 		shared.Authorization = req.Status
-		gormDB.Save(&shared)
+		if err := gormDB.Save(shared).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "授权操作失败")
+			return
+		}
+
+		switch {
+		case req.Status == 1 && !approved:
+			events.Publish(events.ShareApproved{
+				ShareId:  shared.Id,
+				DeviceId: shared.DeviceId,
+				OwnerId:  deviceOwner(gormDB, shared.DeviceId),
+				ViewerId: shared.ViewerId,
+			})
+		case req.Status == 2 && approved:
+			publishShareRevoked(gormDB, shared, true)
+		}
 
 		utils.Success(w, map[string]interface{}{
 			"message": "授权操作成功",
@@ -267,19 +282,39 @@ func DeleteShare(db any) http.HandlerFunc {
 
 		gormDB := db.(*gorm.DB)
 
-		// 检查授权记录是否存在
-		var shared model.SharedDevice
-		gormDB.Where("id = ?", req.AccessId).First(&shared)
-		if shared.Id == "" {
-			utils.Error(w, http.StatusOK, "授权记录不存在")
+		// 检查授权记录是否存在及删除权限
+		shared, ok, err := policy.CanDeleteShare(gormDB, auth.UserId(r), req.AccessId)
+		if !authorizeShare(w, ok, err) {
 			return
 		}
 
 		// 删除共享申请
-		gormDB.Delete(&shared)
+		if err := gormDB.Delete(shared).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "删除共享申请失败")
+			return
+		}
+		publishShareRevoked(gormDB, shared, shared.Authorization == 1)
 
 		utils.Success(w, map[string]any{
 			"message": "删除共享申请成功",
 		})
 	}
 }
+
+// 设备所有者ID, 设备已注销时为空
+func deviceOwner(gormDB *gorm.DB, deviceId string) string {
+	var device model.Device
+	gormDB.Select("owner_id").Where("id = ?", deviceId).Limit(1).Find(&device)
+	return device.OwnerId
+}
+
+// 发布共享被撤销事件, approved 表示撤销前是否已授权
+func publishShareRevoked(gormDB *gorm.DB, shared *model.SharedDevice, approved bool) {
+	events.Publish(events.ShareRevoked{
+		ShareId:  shared.Id,
+		DeviceId: shared.DeviceId,
+		OwnerId:  deviceOwner(gormDB, shared.DeviceId),
+		ViewerId: shared.ViewerId,
+		Approved: approved,
+	})
+}
@@ -2,19 +2,38 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"sloth-tracker/api/agents"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/config"
+	"sloth-tracker/api/metrics"
 	"sloth-tracker/api/model"
+	"sloth-tracker/api/policy"
+	"sloth-tracker/api/presence"
+	"sloth-tracker/api/rollup"
+	"sloth-tracker/api/stats"
+	"sloth-tracker/api/usage"
 	"sloth-tracker/api/utils"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // 获取设备状态
 func GetStatus(db any) http.HandlerFunc {
 	type DeviceStatusWithSource struct {
-		Source string `json:"source"`
+		Source     string           `json:"source"`
+		Presence   presence.State   `json:"presence"`     // 在线状态(online, stale, offline)
+		LastSeenAt *time.Time       `json:"last_seen_at"` // 最近上报时间
+		AgeSeconds *int64           `json:"age_seconds"`  // 距最近上报的秒数
+		Connected  bool             `json:"connected"`    // 是否保持 WebSocket 连接
+		Metrics    []metrics.Metric `json:"metrics"`      // 自定义指标及最新值
 		model.DeviceStatus
 	}
 
@@ -25,52 +44,40 @@ func GetStatus(db any) http.HandlerFunc {
 		}
 
 		// 从查询参数获取参数
-		userID := utils.GetQueryParam(r, "user_id")
+		userID := auth.UserId(r)
 		deviceID := utils.GetQueryParam(r, "device_id")
 
 		// 校验参数
-		if deviceID == "" || userID == "" {
-			utils.Error(w, http.StatusBadRequest, "参数错误: user_id 和 device_id 不能为空")
-			return
-		}
-
-		// 校验参数
-		if deviceID == "" || userID == "" {
-			utils.Error(w, http.StatusBadRequest, "参数错误")
+		if deviceID == "" {
+			utils.Error(w, http.StatusBadRequest, "参数错误: device_id 不能为空")
 			return
 		}
 
 		gormDB := db.(*gorm.DB)
-		source := "账户"
 
-		// 检查设备是否归属用户
-		var device model.Device
-		result := gormDB.Where("id = ? AND owner_id = ?", deviceID, userID).First(&device)
-		if result.Error != nil {
-			if result.Error == gorm.ErrRecordNotFound {
-				// 不是所有者, 检查是否为已授权的共享设备
-				var sharedDevice model.SharedDevice
-				result = gormDB.Where("device_id = ? AND viewer_id = ? AND authorization = 1", deviceID, userID).First(&sharedDevice)
-				source = "共享"
-				if result.Error != nil {
-					if result.Error == gorm.ErrRecordNotFound {
-						// 既不是设备所有者, 也不是授权用户, 权限不足
-						utils.Error(w, http.StatusForbidden, "无权获取该设备状态")
-					} else {
-						utils.Error(w, http.StatusInternalServerError, "数据库查询错误")
-					}
-					return
-				}
+		// 检查是否为设备所有者或已授权的共享用户
+		role, err := policy.RoleOf(gormDB, userID, deviceID)
+		if err != nil {
+			if errors.Is(err, policy.ErrDeviceNotFound) {
+				utils.Error(w, http.StatusNotFound, "设备不存在")
 			} else {
 				utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
-				return
 			}
+			return
+		}
+		if !policy.Allowed(role, policy.View) {
+			utils.Error(w, http.StatusForbidden, "无权获取该设备状态")
+			return
+		}
+		source := "账户"
+		if role == policy.Viewer {
+			source = "共享"
 		}
 
 		// 查询设备状态
 		var status DeviceStatusWithSource
 		status.DeviceStatus = model.DeviceStatus{}
-		result = gormDB.Where("device_id = ?", deviceID).First(&status.DeviceStatus)
+		result := gormDB.Where("device_id = ?", deviceID).First(&status.DeviceStatus)
 		if result.Error != nil {
 			if result.Error == gorm.ErrRecordNotFound {
 				utils.Error(w, http.StatusNotFound, "设备状态未找到")
@@ -82,6 +89,20 @@ func GetStatus(db any) http.HandlerFunc {
 
 		status.Source = source
 
+		// 附加在线状态
+		var device model.Device
+		if err := gormDB.Select("last_seen_at").First(&device, "id = ?", deviceID).Error; err == nil {
+			status.LastSeenAt = device.LastSeenAt
+		}
+		status.Presence, status.AgeSeconds = devicePresence(status.LastSeenAt)
+		status.Connected = agents.Default.Connected(deviceID)
+
+		// 附加自定义指标
+		if status.Metrics, err = metrics.Latest(gormDB, deviceID); err != nil {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+
 		utils.Success(w, map[string]any{
 			"message": "查询成功",
 			"status":  status,
@@ -89,93 +110,350 @@ func GetStatus(db any) http.HandlerFunc {
 	}
 }
 
-// 更新设备状态 PUT
+// 更新设备状态 PUT/PATCH
+// 仅更新请求体中出现的分组或字段, 显式为 null 的字段会被清零
 func UpdateStatus(db any) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPut {
+		if r.Method != http.MethodPut && r.Method != http.MethodPatch {
 			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
 			return
 		}
 
-		// 从查询参数获取参数
-		userID := utils.GetQueryParam(r, "user_id")
-		deviceID := utils.GetQueryParam(r, "device_id")
+		// 设备已通过凭证认证
+		deviceID := auth.DeviceId(r)
 
-		// 校验参数
-		if deviceID == "" || userID == "" {
-			utils.Error(w, http.StatusBadRequest, "参数错误: user_id 和 device_id 不能为空")
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		if _, failure := saveStatus(gormDB, deviceID, body); failure != nil {
+			failure.write(w)
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message":  "状态更新成功",
+			"commands": deliverCommands(gormDB, deviceID),
+		})
+	}
+}
+
+// 保存一次状态上报: 在当前状态的基础上合并, 追加历史记录并推送给订阅者
+func saveStatus(gormDB *gorm.DB, deviceID string, body []byte) (*model.DeviceStatus, *reportError) {
+	var existing model.DeviceStatus
+	err := gormDB.Where("device_id = ?", deviceID).First(&existing).Error
+	found := err == nil
+	var previous *model.DeviceStatus
+	if found {
+		snapshot := existing
+		previous = &snapshot
+	}
+
+	// 在当前状态的基础上合并本次上报
+	status := existing
+	fields, err := mergeStatusPatch(&status, body, config.Get().StatusStrict)
+	if err != nil {
+		return nil, statusPatchFailure(err, "")
+	}
+	reports, err := metrics.Parse(rawMetrics(body))
+	if err != nil {
+		return nil, metricsFailure(err, "")
+	}
+
+	// now时间戳获取到毫秒
+	now := time.Now().UnixNano() / 1e6
+	status.Timestamp = now
+
+	tx := gormDB.Begin()
+
+	if found {
+		// 更新现有记录, 只写入本次上报的字段
+		updateData := map[string]any{
+			"timestamp": now,
+		}
+		for _, field := range fields {
+			updateData[field.Column] = statusFieldValue(&status, field)
+		}
+
+		if err := tx.Model(&existing).Updates(updateData).Error; err != nil {
+			tx.Rollback()
+			return nil, &reportError{Code: http.StatusInternalServerError, Message: "更新失败"}
+		}
+	} else {
+		// 不存在, 创建新记录
+		status.Id = uuid.New().String()
+		status.DeviceId = deviceID
+		if err := tx.Create(&status).Error; err != nil {
+			tx.Rollback()
+			return nil, &reportError{Code: http.StatusInternalServerError, Message: "创建失败"}
+		}
+	}
+
+	// 追加历史记录
+	history := model.DeviceStatusHistory{
+		Id:         uuid.New().String(),
+		DeviceId:   deviceID,
+		Timestamp:  now,
+		Battery:    status.Battery,
+		Network:    status.Network,
+		Foreground: status.Foreground,
+		Other:      status.Other,
+	}
+	if err := tx.Create(&history).Error; err != nil {
+		tx.Rollback()
+		return nil, &reportError{Code: http.StatusInternalServerError, Message: "保存历史记录失败"}
+	}
+
+	// 更新应用会话
+	if err := usage.Track(tx, deviceID, usageReport(&status, now), config.Get().HistoryMaxGap); err != nil {
+		tx.Rollback()
+		return nil, &reportError{Code: http.StatusInternalServerError, Message: "更新应用会话失败"}
+	}
+
+	// 记录自定义指标
+	if err := metrics.Record(tx, deviceID, reports, now); err != nil {
+		tx.Rollback()
+		return nil, metricsFailure(err, "")
+	}
+
+	tx.Commit()
+
+	stats.RecordReport()
+	statusSaved(deviceID, status, previous)
+
+	return &status, nil
+}
+
+const (
+	maxBatchSnapshots = 500             // 单次批量上报的最大快照数
+	maxClockSkew      = 5 * time.Minute // 允许客户端时间超前服务器的范围
+)
+
+// 查询设备在 timestamp(毫秒)及之前最近一次记录的状态, 没有记录时返回 nil
+func statusAt(tx *gorm.DB, deviceID string, timestamp int64) (*model.DeviceStatus, error) {
+	var history model.DeviceStatusHistory
+	result := tx.Where("device_id = ? AND timestamp <= ?", deviceID, timestamp).
+		Order("timestamp DESC").Limit(1).Find(&history)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return &model.DeviceStatus{
+		DeviceId:   deviceID,
+		Timestamp:  history.Timestamp,
+		Battery:    history.Battery,
+		Network:    history.Network,
+		Foreground: history.Foreground,
+		Other:      history.Other,
+	}, nil
+}
+
+// 批量上报离线期间缓存的状态 POST
+// 快照按时间顺序依次合并, 同一快照ID重复上报时忽略
+func UpdateStatusBatch(db any) http.HandlerFunc {
+	type snapshotMeta struct {
+		Id        string `json:"id"`        // 客户端生成的快照ID
+		Timestamp int64  `json:"timestamp"` // 客户端采集时间(毫秒)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
 			return
 		}
 
-		var req model.DeviceStatus
+		deviceID := auth.DeviceId(r)
+
+		var req struct {
+			Snapshots []json.RawMessage `json:"snapshots"`
+		}
+
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			utils.Error(w, http.StatusBadRequest, "参数错误")
 			return
 		}
 
-		gormDB := db.(*gorm.DB)
+		if len(req.Snapshots) == 0 {
+			utils.Error(w, http.StatusBadRequest, "参数错误: snapshots 不能为空")
+			return
+		}
+		if len(req.Snapshots) > maxBatchSnapshots {
+			utils.Error(w, http.StatusBadRequest, fmt.Sprintf("参数错误: 单次最多上报 %d 条快照", maxBatchSnapshots))
+			return
+		}
 
-		// 检查设备是否归属用户
-		var device model.Device
-		result := gormDB.Where("id = ? AND owner_id = ?", deviceID, userID).First(&device)
-		if result.Error != nil {
-			if result.Error == gorm.ErrRecordNotFound {
-				utils.Error(w, http.StatusForbidden, "无权更新该设备状态")
+		// 校验快照ID与时间戳
+		now := time.Now()
+		metas := make([]snapshotMeta, len(req.Snapshots))
+		for i, raw := range req.Snapshots {
+			if err := json.Unmarshal(raw, &metas[i]); err != nil {
+				utils.Error(w, http.StatusBadRequest, fmt.Sprintf("参数错误: 第 %d 条快照格式不正确", i+1))
 				return
-			} else {
-				utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			}
+			if metas[i].Id == "" || len(metas[i].Id) > 64 {
+				utils.Error(w, http.StatusBadRequest, fmt.Sprintf("参数错误: 第 %d 条快照 id 无效", i+1))
+				return
+			}
+			if metas[i].Timestamp <= 0 || metas[i].Timestamp > now.Add(maxClockSkew).UnixMilli() {
+				utils.Error(w, http.StatusBadRequest, fmt.Sprintf("参数错误: 第 %d 条快照 timestamp 无效", i+1))
 				return
 			}
 		}
 
+		// 按采集时间排序
+		order := make([]int, len(req.Snapshots))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			return metas[order[a]].Timestamp < metas[order[b]].Timestamp
+		})
+
+		gormDB := db.(*gorm.DB)
+		cfg := config.Get()
+
 		var existing model.DeviceStatus
-		err := gormDB.Where("device_id = ?", deviceID).First(&existing).Error
-
-		// now时间戳获取到毫秒
-		now := time.Now().UnixNano() / 1e6
-
-		if err == nil {
-			// 更新现有记录
-			updateData := map[string]any{
-				"timestamp":                   now,
-				"battery_charging":            req.Battery.Charging,
-				"battery_level":               req.Battery.Level,
-				"battery_temperature":         req.Battery.Temperature,
-				"battery_capacity":            req.Battery.Capacity,
-				"network_wifi_connected":      req.Network.WifiConnected,
-				"network_wifi_ss_id":          req.Network.WifiSSId,
-				"network_mobile_data_active":  req.Network.MobileDataActive,
-				"network_mobile_signal_dbm":   req.Network.MobileSignalDbm,
-				"network_network_type":        req.Network.NetworkType,
-				"network_upload_speed_kbps":   req.Network.UploadSpeedKbps,
-				"network_download_speed_kbps": req.Network.DownloadSpeedKbps,
-				"network_traffic_used_mb":     req.Network.TrafficUsedMB,
-				"foreground_app_name":         req.Foreground.AppName,
-				"foreground_app_title":        req.Foreground.AppTitle,
-				"foreground_speaker_playing":  req.Foreground.SpeakerPlaying,
-				"other_screen_on":             req.Other.ScreenOn,
-				"other_is_charging_via_usb":   req.Other.IsChargingViaUSB,
-				"other_is_charging_via_ac":    req.Other.IsChargingViaAC,
-				"other_is_low_power_mode":     req.Other.IsLowPowerMode,
-			}
-
-			if err := gormDB.Model(&existing).Updates(updateData).Error; err != nil {
-				utils.Error(w, http.StatusInternalServerError, "更新失败")
+		found := gormDB.Where("device_id = ?", deviceID).First(&existing).Error == nil
+		var previous *model.DeviceStatus
+		if found {
+			snapshot := existing
+			previous = &snapshot
+		}
+
+		// 超出原始数据保留时长的快照会被清理, 直接丢弃
+		expiredBefore := rawHistoryStart(now)
+
+		var status model.DeviceStatus
+		accepted, duplicates, expired := 0, 0, 0
+		var firstTs, lastTs int64
+
+		tx := gormDB.Begin()
+		for _, i := range order {
+			meta := metas[i]
+			// 以快照采集时间之前最近的状态为基础合并, 避免较早的快照继承服务器上更新的字段
+			base, err := statusAt(tx, deviceID, meta.Timestamp)
+			if err != nil {
+				tx.Rollback()
+				utils.Error(w, http.StatusInternalServerError, "查询历史记录失败")
 				return
 			}
-		} else {
-			// 不存在, 创建新记录
-			req.Id = uuid.New().String()
-			req.DeviceId = deviceID
-			req.Timestamp = now
-			if err := gormDB.Create(&req).Error; err != nil {
-				utils.Error(w, http.StatusInternalServerError, "创建失败")
+			if found && existing.Timestamp <= meta.Timestamp && (base == nil || existing.Timestamp > base.Timestamp) {
+				base = &existing
+			}
+			status = model.DeviceStatus{}
+			if base != nil {
+				status = *base
+			}
+			if _, err := mergeStatusPatch(&status, req.Snapshots[i], cfg.StatusStrict); err != nil {
+				tx.Rollback()
+				statusPatchError(w, err, fmt.Sprintf("snapshots.%d.", i))
+				return
+			}
+			reports, err := metrics.Parse(rawMetrics(req.Snapshots[i]))
+			if err != nil {
+				tx.Rollback()
+				metricsError(w, err, fmt.Sprintf("snapshots.%d.", i))
+				return
+			}
+			if meta.Timestamp < expiredBefore {
+				expired++
+				continue
+			}
+
+			snapshotId := meta.Id
+			history := model.DeviceStatusHistory{
+				Id:         uuid.New().String(),
+				DeviceId:   deviceID,
+				SnapshotId: &snapshotId,
+				Timestamp:  meta.Timestamp,
+				Battery:    status.Battery,
+				Network:    status.Network,
+				Foreground: status.Foreground,
+				Other:      status.Other,
+			}
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&history)
+			if result.Error != nil {
+				tx.Rollback()
+				utils.Error(w, http.StatusInternalServerError, "保存历史记录失败")
 				return
 			}
+			if result.RowsAffected == 0 {
+				duplicates++
+				continue
+			}
+			if err := metrics.Record(tx, deviceID, reports, meta.Timestamp); err != nil {
+				tx.Rollback()
+				metricsError(w, err, fmt.Sprintf("snapshots.%d.", i))
+				return
+			}
+			accepted++
+			if firstTs == 0 {
+				firstTs = meta.Timestamp
+			}
+			lastTs = meta.Timestamp
+		}
+
+		// 最新一条快照比服务器上的状态更新时才更新最新状态
+		newest := metas[order[len(order)-1]].Timestamp
+		latestUpdated := accepted > 0 && (!found || newest > existing.Timestamp)
+		if latestUpdated {
+			status.Timestamp = newest
+			if found {
+				status.Id = existing.Id
+				status.DeviceId = deviceID
+				updateData := map[string]any{
+					"timestamp": newest,
+				}
+				for _, field := range model.StatusFields {
+					updateData[field.Column] = statusFieldValue(&status, field)
+				}
+				if err := tx.Model(&existing).Updates(updateData).Error; err != nil {
+					tx.Rollback()
+					utils.Error(w, http.StatusInternalServerError, "更新失败")
+					return
+				}
+			} else {
+				status.Id = uuid.New().String()
+				status.DeviceId = deviceID
+				if err := tx.Create(&status).Error; err != nil {
+					tx.Rollback()
+					utils.Error(w, http.StatusInternalServerError, "创建失败")
+					return
+				}
+			}
+		}
+
+		// 补传的数据可能落在已有的应用会话与聚合的时间段内, 重新生成受影响的会话与聚合
+		if accepted > 0 {
+			if err := usage.Rebuild(tx, deviceID, firstTs, cfg.HistoryMaxGap); err != nil {
+				tx.Rollback()
+				utils.Error(w, http.StatusInternalServerError, "更新应用会话失败")
+				return
+			}
+			if err := rollup.Refresh(tx, deviceID, firstTs, lastTs, now, cfg.HistoryMaxGap); err != nil {
+				tx.Rollback()
+				utils.Error(w, http.StatusInternalServerError, "更新聚合数据失败")
+				return
+			}
+		}
+
+		tx.Commit()
+
+		for range accepted {
+			stats.RecordReport()
+		}
+		if latestUpdated {
+			statusSaved(deviceID, status, previous)
 		}
 
 		utils.Success(w, map[string]any{
-			"message": "状态更新成功",
+			"message":    "批量上报成功",
+			"accepted":   accepted,
+			"duplicates": duplicates,
+			"expired":    expired,
 		})
 	}
 }
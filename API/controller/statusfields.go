@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"fmt"
+	"sloth-tracker/api/model"
+	"strings"
+)
+
+// 解析逗号分隔的字段列表, 支持分组名(如 battery)或具体字段(如 battery.level), 为空时返回全部字段
+func parseStatusFields(param string) ([]model.StatusField, error) {
+	if param == "" {
+		return model.StatusFields, nil
+	}
+
+	var fields []model.StatusField
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		matched := false
+		for _, field := range model.StatusFields {
+			if field.Path == name || strings.HasPrefix(field.Path, name+".") {
+				matched = true
+				if !seen[field.Path] {
+					seen[field.Path] = true
+					fields = append(fields, field)
+				}
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("未知字段: %s", name)
+		}
+	}
+	if len(fields) == 0 {
+		return model.StatusFields, nil
+	}
+	return fields, nil
+}
+
+// 筛选可聚合的字段(排除文本字段), 明确请求了文本字段时返回错误
+func aggregatedStatusFields(fields []model.StatusField, param string) ([]model.StatusField, error) {
+	requested := make(map[string]bool)
+	for _, name := range strings.Split(param, ",") {
+		requested[strings.TrimSpace(name)] = true
+	}
+
+	var result []model.StatusField
+	for _, field := range fields {
+		if field.Kind != model.TextField {
+			result = append(result, field)
+		} else if requested[field.Path] {
+			return nil, fmt.Errorf("字段 %s 不支持聚合查询", field.Path)
+		}
+	}
+	return result, nil
+}
+
+// 将按字段路径组织的值还原为分组结构, 与 DeviceStatus 的 JSON 结构一致
+func nestStatusValues(nested map[string]any, fields []model.StatusField, value func(field model.StatusField) (any, bool)) map[string]any {
+	for _, field := range fields {
+		v, ok := value(field)
+		if !ok {
+			continue
+		}
+		group, name, _ := strings.Cut(field.Path, ".")
+		values, ok := nested[group].(map[string]any)
+		if !ok {
+			values = make(map[string]any)
+			nested[group] = values
+		}
+		values[name] = v
+	}
+	return nested
+}
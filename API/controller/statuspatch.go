@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/utils"
+	"sort"
+	"strings"
+)
+
+var jsonNull = []byte("null")
+
+// 按 JSON 字段名查找结构体字段
+func fieldByJSONName(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := range t.NumField() {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if tag == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// 上报中允许出现的非状态字段
+var statusMetaKeys = map[string]bool{
+	"id":        true,
+	"device_id": true,
+	"timestamp": true,
+	"metrics":   true,
+}
+
+// 字段类型不匹配时的提示
+func expectedType(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Int:
+		return "类型应为整数"
+	case reflect.Float64:
+		return "类型应为数值"
+	case reflect.String:
+		return "类型应为字符串"
+	}
+	return "类型不正确"
+}
+
+// 将请求体中出现的分组/字段合并到状态中, 未出现的字段保持不变, 显式为 null 的字段清零.
+// 返回被更新的字段; 字段类型或取值不合法时返回 utils.FieldErrors, strict 为 true 时未知字段也视为错误
+func mergeStatusPatch(status *model.DeviceStatus, body []byte, strict bool) ([]model.StatusField, error) {
+	var groups map[string]json.RawMessage
+	if err := json.Unmarshal(body, &groups); err != nil {
+		return nil, errors.New("请求体应为 JSON 对象")
+	}
+
+	fieldsByPath := make(map[string]model.StatusField, len(model.StatusFields))
+	for _, field := range model.StatusFields {
+		fieldsByPath[field.Path] = field
+	}
+
+	statusValue := reflect.ValueOf(status).Elem()
+	var updated []model.StatusField
+	var fieldErrors utils.FieldErrors
+	for groupName, raw := range groups {
+		group, ok := fieldByJSONName(statusValue, groupName)
+		if !ok || group.Kind() != reflect.Struct {
+			// 忽略 id, timestamp 等非状态字段
+			if strict && !statusMetaKeys[groupName] {
+				fieldErrors = append(fieldErrors, utils.FieldError{Path: groupName, Reason: "未知字段"})
+			}
+			continue
+		}
+
+		// 整个分组为 null 时清空该分组
+		var values map[string]json.RawMessage
+		if bytes.Equal(bytes.TrimSpace(raw), jsonNull) {
+			values = make(map[string]json.RawMessage)
+			for _, field := range model.StatusFields {
+				if prefix, name, _ := strings.Cut(field.Path, "."); prefix == groupName {
+					values[name] = jsonNull
+				}
+			}
+		} else if err := json.Unmarshal(raw, &values); err != nil {
+			fieldErrors = append(fieldErrors, utils.FieldError{Path: groupName, Reason: "应为对象或 null"})
+			continue
+		}
+
+		for name, value := range values {
+			field, ok := fieldsByPath[groupName+"."+name]
+			if !ok {
+				if strict {
+					fieldErrors = append(fieldErrors, utils.FieldError{Path: groupName + "." + name, Reason: "未知字段"})
+				}
+				continue
+			}
+			target, ok := fieldByJSONName(group, name)
+			if !ok {
+				continue
+			}
+			if bytes.Equal(bytes.TrimSpace(value), jsonNull) {
+				target.Set(reflect.Zero(target.Type()))
+			} else if err := json.Unmarshal(value, target.Addr().Interface()); err != nil {
+				fieldErrors = append(fieldErrors, utils.FieldError{Path: field.Path, Reason: expectedType(target.Kind())})
+				continue
+			}
+			if reason := field.Check(target.Interface()); reason != "" {
+				fieldErrors = append(fieldErrors, utils.FieldError{Path: field.Path, Reason: reason})
+				continue
+			}
+			updated = append(updated, field)
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		sort.Slice(fieldErrors, func(i, j int) bool { return fieldErrors[i].Path < fieldErrors[j].Path })
+		return nil, fieldErrors
+	}
+	return updated, nil
+}
+
+// 状态上报失败的原因, HTTP 与 WebSocket 上报共用
+type reportError struct {
+	Code    int               // 对应的 HTTP 状态码
+	Message string            // 错误信息
+	Fields  utils.FieldErrors // 字段校验错误
+}
+
+func (e *reportError) Error() string {
+	return e.Message
+}
+
+// 写入错误响应
+func (e *reportError) write(w http.ResponseWriter) {
+	if len(e.Fields) > 0 {
+		utils.ValidationError(w, e.Fields)
+		return
+	}
+	utils.Error(w, e.Code, e.Message)
+}
+
+// 合并上报失败的原因, 字段校验错误逐项列出; prefix 用于批量上报时标明快照位置
+func statusPatchFailure(err error, prefix string) *reportError {
+	var fieldErrors utils.FieldErrors
+	if errors.As(err, &fieldErrors) {
+		for i := range fieldErrors {
+			fieldErrors[i].Path = prefix + fieldErrors[i].Path
+		}
+		return &reportError{Code: http.StatusBadRequest, Message: "参数校验失败", Fields: fieldErrors}
+	}
+	return &reportError{Code: http.StatusBadRequest, Message: "参数错误: " + err.Error()}
+}
+
+// 合并上报失败时的错误响应
+func statusPatchError(w http.ResponseWriter, err error, prefix string) {
+	statusPatchFailure(err, prefix).write(w)
+}
+
+// 读取状态中指定字段的值
+func statusFieldValue(status *model.DeviceStatus, field model.StatusField) any {
+	groupName, name, _ := strings.Cut(field.Path, ".")
+	group, _ := fieldByJSONName(reflect.ValueOf(status).Elem(), groupName)
+	value, _ := fieldByJSONName(group, name)
+	return value.Interface()
+}
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"errors"
+	"slices"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/utils"
+	"strings"
+	"testing"
+)
+
+func TestMergeStatusPatch(t *testing.T) {
+	status := model.DeviceStatus{
+		Battery: model.BatteryStatus{Level: 80, Charging: 1},
+		Network: model.NetworkStatus{WifiSSId: "home"},
+	}
+	fields, err := mergeStatusPatch(&status, []byte(`{"battery": {"level": 60}, "network": null, "metrics": {}}`), true)
+	if err != nil {
+		t.Fatalf("合并失败: %v", err)
+	}
+	if status.Battery.Level != 60 || status.Battery.Charging != 1 {
+		t.Errorf("未出现的字段应保持不变: %+v", status.Battery)
+	}
+	if status.Network.WifiSSId != "" {
+		t.Errorf("为 null 的分组应清空: %+v", status.Network)
+	}
+	if len(fields) != 1+8 {
+		t.Errorf("更新字段数 = %d, 期望 9", len(fields))
+	}
+}
+
+func TestMergeStatusPatchFields(t *testing.T) {
+	status := model.DeviceStatus{
+		Id:         "status",
+		Timestamp:  100,
+		Battery:    model.BatteryStatus{Level: 80, Charging: 1, Temperature: 30},
+		Network:    model.NetworkStatus{WifiConnected: 1, WifiSSId: "home", NetworkType: "WiFi"},
+		Foreground: model.ForegroundStatus{AppName: "Code", AppTitle: "main.go"},
+	}
+
+	// 元数据字段不参与合并, 空对象不修改任何字段
+	fields, err := mergeStatusPatch(&status, []byte(`{"id": "other", "timestamp": 200, "battery": {}}`), true)
+	if err != nil {
+		t.Fatalf("合并失败: %v", err)
+	}
+	if len(fields) != 0 || status.Id != "status" || status.Timestamp != 100 || status.Battery.Level != 80 {
+		t.Errorf("不应修改任何字段: %v %+v", fields, status)
+	}
+
+	// 只更新出现的字段, 显式为 null 的字段清零
+	fields, err = mergeStatusPatch(&status, []byte(`{
+		"network": {"wifi_ssid": "office", "network_type": null},
+		"foreground": {"app_title": null}
+	}`), true)
+	if err != nil {
+		t.Fatalf("合并失败: %v", err)
+	}
+	paths := make([]string, 0, len(fields))
+	for _, field := range fields {
+		paths = append(paths, field.Path)
+	}
+	slices.Sort(paths)
+	if want := []string{"foreground.app_title", "network.network_type", "network.wifi_ssid"}; !slices.Equal(paths, want) {
+		t.Errorf("更新字段 = %v, 期望 %v", paths, want)
+	}
+	if status.Network.WifiSSId != "office" || status.Network.NetworkType != "" || status.Network.WifiConnected != 1 {
+		t.Errorf("网络状态合并错误: %+v", status.Network)
+	}
+	if status.Foreground.AppTitle != "" || status.Foreground.AppName != "Code" {
+		t.Errorf("前台应用状态合并错误: %+v", status.Foreground)
+	}
+	if status.Battery != (model.BatteryStatus{Level: 80, Charging: 1, Temperature: 30}) {
+		t.Errorf("未出现的分组应保持不变: %+v", status.Battery)
+	}
+}
+
+func TestMergeStatusPatchFieldErrors(t *testing.T) {
+	body := []byte(`{
+		"battery": {"level": 250, "charging": 9, "temperature": "hot"},
+		"network": {"upload_speed_kbps": -1, "wifi_ssid": "` + strings.Repeat("x", 65) + `"},
+		"other": 1,
+		"foreground": {"app_name": "Code", "unknown": 1},
+		"extra": {}
+	}`)
+
+	status := model.DeviceStatus{Battery: model.BatteryStatus{Level: 80}}
+	_, err := mergeStatusPatch(&status, body, true)
+	var fieldErrors utils.FieldErrors
+	if !errors.As(err, &fieldErrors) {
+		t.Fatalf("应返回字段错误, 实际为 %v", err)
+	}
+
+	want := []string{
+		"battery.charging",
+		"battery.level",
+		"battery.temperature",
+		"extra",
+		"foreground.unknown",
+		"network.upload_speed_kbps",
+		"network.wifi_ssid",
+		"other",
+	}
+	if len(fieldErrors) != len(want) {
+		t.Fatalf("字段错误 = %+v", fieldErrors)
+	}
+	for i, path := range want {
+		if fieldErrors[i].Path != path || fieldErrors[i].Reason == "" {
+			t.Errorf("第 %d 个错误 = %+v, 期望路径 %s", i, fieldErrors[i], path)
+		}
+	}
+
+	// 非严格模式忽略未知字段
+	_, err = mergeStatusPatch(&model.DeviceStatus{}, []byte(`{"foreground": {"unknown": 1}, "extra": {}}`), false)
+	if err != nil {
+		t.Errorf("非严格模式不应报错: %v", err)
+	}
+
+	// 0 表示未上报, 总是允许
+	if _, err := mergeStatusPatch(&model.DeviceStatus{}, []byte(`{"battery": {"charging": 0}}`), true); err != nil {
+		t.Errorf("0 应被允许: %v", err)
+	}
+
+	if _, err := mergeStatusPatch(&model.DeviceStatus{}, []byte(`[]`), false); err == nil || errors.As(err, &fieldErrors) {
+		t.Errorf("非对象请求体应返回普通错误, 实际为 %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/events"
+	"sloth-tracker/api/live"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/policy"
+	"sloth-tracker/api/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	streamKeepalive  = 15 * time.Second // 保活注释的发送间隔, 同时重新检查权限
+	streamRetry      = 3000             // 建议客户端的重连间隔(毫秒)
+	streamMaxDevices = 100              // 单个事件流最多订阅的设备数量
+	liveStatusEvent  = "status"         // 设备状态更新事件
+	streamCloseEvent = "close"          // 服务器主动结束事件流
+)
+
+// 推送设备最新状态给订阅者
+func publishStatus(status *model.DeviceStatus) {
+	data, err := json.Marshal(map[string]any{
+		"device_id": status.DeviceId,
+		"status":    status,
+	})
+	if err != nil {
+		return
+	}
+	live.Publish(status.DeviceId, liveStatusEvent, data)
+}
+
+// 状态已保存: 先推送给实时事件流, 再发布领域事件.
+// 实时事件在此同步分配事件ID, 不经过事件总线, 避免总线丢弃事件导致断线重连时无法察觉
+func statusSaved(deviceId string, status model.DeviceStatus, previous *model.DeviceStatus) {
+	publishStatus(&status)
+	events.Publish(events.StatusUpdated{DeviceId: deviceId, Status: status, Previous: previous})
+}
+
+// 订阅单个设备的状态更新(SSE) GET
+func StreamStatus(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deviceId := utils.GetQueryParam(r, "device_id")
+		if deviceId == "" {
+			utils.Error(w, http.StatusBadRequest, "参数错误: device_id 不能为空")
+			return
+		}
+		streamStatus(db.(*gorm.DB), w, r, []string{deviceId})
+	}
+}
+
+// 订阅多个设备的状态更新(SSE) GET
+// device_ids 为逗号分隔的设备ID, 省略时订阅用户拥有及被共享的全部设备
+func StreamStatuses(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gormDB := db.(*gorm.DB)
+
+		var deviceIds []string
+		for _, deviceId := range strings.Split(utils.GetQueryParam(r, "device_ids"), ",") {
+			if deviceId = strings.TrimSpace(deviceId); deviceId != "" {
+				deviceIds = append(deviceIds, deviceId)
+			}
+		}
+		if len(deviceIds) == 0 {
+			var err error
+			if deviceIds, err = viewableDeviceIds(gormDB, auth.UserId(r)); err != nil {
+				utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+				return
+			}
+		}
+		if len(deviceIds) > streamMaxDevices {
+			utils.Error(w, http.StatusBadRequest, fmt.Sprintf("参数错误: 最多订阅 %d 台设备", streamMaxDevices))
+			return
+		}
+		streamStatus(gormDB, w, r, deviceIds)
+	}
+}
+
+// 用户拥有及被授权查看的全部设备
+func viewableDeviceIds(gormDB *gorm.DB, userId string) ([]string, error) {
+	var owned []string
+	if err := gormDB.Model(&model.Device{}).Where("owner_id = ?", userId).Pluck("id", &owned).Error; err != nil {
+		return nil, err
+	}
+	var shared []string
+	if err := gormDB.Model(&model.SharedDevice{}).Where("viewer_id = ? AND authorization = ?", userId, 1).
+		Pluck("device_id", &shared).Error; err != nil {
+		return nil, err
+	}
+	return append(owned, shared...), nil
+}
+
+// 事件流仍然有效: 会话未被吊销且仍有权查看全部设备
+func streamAllowed(gormDB *gorm.DB, r *http.Request, deviceIds []string) bool {
+	var session model.Session
+	if err := gormDB.Select("revoked_at", "expires_at").Where("id = ?", auth.SessionId(r)).First(&session).Error; err != nil {
+		return false
+	}
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return false
+	}
+	for _, deviceId := range deviceIds {
+		if ok, err := policy.Can(gormDB, auth.UserId(r), policy.View, deviceId); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// 客户端收到的最后一个事件ID, 来自 Last-Event-ID 请求头或 last_event_id 查询参数
+func lastEventId(r *http.Request) uint64 {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = utils.GetQueryParam(r, "last_event_id")
+	}
+	id, _ := strconv.ParseUint(value, 10, 64)
+	return id
+}
+
+// 写入一个 SSE 事件
+func writeEvent(w http.ResponseWriter, id uint64, kind string, data []byte) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, kind, data)
+	return err
+}
+
+// 推送设备状态更新直到客户端断开. 新连接(或无法补发时)先推送各设备的当前状态,
+// 携带 Last-Event-ID 重连时补发断开期间的事件
+func streamStatus(gormDB *gorm.DB, w http.ResponseWriter, r *http.Request, deviceIds []string) {
+	if r.Method != http.MethodGet {
+		utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+		return
+	}
+
+	for _, deviceId := range deviceIds {
+		if !authorizeDevice(w, r, gormDB, policy.View, deviceId) {
+			return
+		}
+	}
+
+	controller := http.NewResponseController(w)
+	sub, missed, resumed, current := live.Default.Subscribe(deviceIds, lastEventId(r))
+	defer live.Default.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+
+	if resumed {
+		for _, event := range missed {
+			if writeEvent(w, event.Id, event.Type, event.Data) != nil {
+				return
+			}
+		}
+	} else {
+		var statuses []model.DeviceStatus
+		if err := gormDB.Where("device_id IN ?", deviceIds).Find(&statuses).Error; err != nil {
+			return
+		}
+		for i := range statuses {
+			data, _ := json.Marshal(map[string]any{
+				"device_id": statuses[i].DeviceId,
+				"status":    statuses[i],
+			})
+			if writeEvent(w, current, liveStatusEvent, data) != nil {
+				return
+			}
+		}
+	}
+	if controller.Flush() != nil {
+		return
+	}
+
+	ticker := time.NewTicker(streamKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				// 处理过慢被断开, 客户端重连后补发
+				return
+			}
+			if writeEvent(w, event.Id, event.Type, event.Data) != nil || controller.Flush() != nil {
+				return
+			}
+		case <-ticker.C:
+			if !streamAllowed(gormDB, r, deviceIds) {
+				fmt.Fprintf(w, "event: %s\ndata: {\"reason\":\"unauthorized\"}\n\n", streamCloseEvent)
+				controller.Flush()
+				return
+			}
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil || controller.Flush() != nil {
+				return
+			}
+		}
+	}
+}
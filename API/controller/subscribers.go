@@ -0,0 +1,21 @@
+package controller
+
+import (
+	"sloth-tracker/api/agents"
+	"sloth-tracker/api/events"
+)
+
+// SubscribeEvents 注册控制器的事件订阅者, 在启动时调用
+func SubscribeEvents(bus *events.Bus) {
+	// 设备被删除后断开其 WebSocket 连接
+	bus.Subscribe("agents", 64, func(event events.Event) {
+		switch e := event.(type) {
+		case events.DeviceDeleted:
+			agents.Default.Disconnect(e.DeviceId, "deleted")
+		case events.UserDeleted:
+			for _, deviceId := range e.DeviceIds {
+				agents.Default.Disconnect(deviceId, "deleted")
+			}
+		}
+	}, events.TypeDeviceDeleted, events.TypeUserDeleted)
+}
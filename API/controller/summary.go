@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"net/http"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/config"
+	"sloth-tracker/api/policy"
+	"sloth-tracker/api/summary"
+	"sloth-tracker/api/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 解析日期参数(YYYY-MM-DD, 默认今天), 返回该日在指定时区内的毫秒范围 [from, to)
+func dayRange(r *http.Request, location *time.Location) (date string, from, to int64, err error) {
+	date = utils.GetQueryParamDefault(r, "date", time.Now().In(location).Format(time.DateOnly))
+	day, err := time.ParseInLocation(time.DateOnly, date, location)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return date, day.UnixMilli(), day.AddDate(0, 0, 1).UnixMilli(), nil
+}
+
+// 获取设备每日摘要 GET
+func GetDailySummary(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		deviceId := utils.GetQueryParam(r, "device_id")
+		if deviceId == "" {
+			utils.Error(w, http.StatusBadRequest, "参数错误: device_id 不能为空")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		// 按用户设置的时区划分日期
+		location := userLocation(gormDB, auth.UserId(r))
+		date, from, to, err := dayRange(r, location)
+		if err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误: date 格式应为 YYYY-MM-DD")
+			return
+		}
+
+		if !authorizeDevice(w, r, gormDB, policy.View, deviceId) {
+			return
+		}
+
+		// 摘要由原始历史记录计算, 早于保留期的日期已无完整数据
+		now := time.Now()
+		if from < rawHistoryStart(now) {
+			utils.Error(w, http.StatusBadRequest, "参数错误: date 早于原始历史记录的保留期")
+			return
+		}
+
+		// 当天尚未结束时只统计到当前时刻
+		to = min(to, now.UnixMilli())
+
+		daily, err := summary.Compute(gormDB, deviceId, from, to, config.Get().HistoryMaxGap)
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message":  "查询成功",
+			"date":     date,
+			"timezone": location.String(),
+			"summary":  daily,
+		})
+	}
+}
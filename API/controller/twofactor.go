@@ -0,0 +1,311 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"net/http"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/config"
+	"sloth-tracker/api/limiter"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/utils"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer        = "SlothTracker"  // 验证器应用中显示的发行方
+	challengeTTL      = 5 * time.Minute // 两步验证登录凭据有效期
+	recoveryCodeCount = 10              // 每次生成的恢复码数量
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// 生成新的恢复码并替换旧恢复码, 返回明文(仅此一次可见)
+func generateRecoveryCodes(tx *gorm.DB, userId string) ([]string, error) {
+	if err := tx.Where("user_id = ?", userId).Delete(&model.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	codes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(buf))
+		code := raw[:4] + "-" + raw[4:]
+		if err := tx.Create(&model.RecoveryCode{
+			Id:        uuid.New().String(),
+			UserId:    userId,
+			CodeHash:  auth.HashSecret(normalizeRecoveryCode(code)),
+			CreatedAt: now,
+		}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// 使用一个恢复码, 成功后该恢复码作废
+func useRecoveryCode(gormDB *gorm.DB, userId, code string) (bool, error) {
+	hash := auth.HashSecret(normalizeRecoveryCode(code))
+	result := gormDB.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userId, hash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// 统一恢复码格式(忽略大小写, 空格与连字符)
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// 校验并消耗 TOTP 验证码, 同一时间步只能使用一次
+func useTOTPCode(gormDB *gorm.DB, user *model.User, code string) bool {
+	step, ok := auth.ValidateTOTP(user.TotpSecret, code, time.Now(), user.TotpLastStep)
+	if !ok {
+		return false
+	}
+	result := gormDB.Model(&model.User{}).
+		Where("id = ? AND totp_last_step < ?", user.Id, step).
+		Update("totp_last_step", step)
+	return result.Error == nil && result.RowsAffected > 0
+}
+
+// 获取两步验证密钥 POST
+func SetupTwoFactor(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		var user model.User
+		if err := gormDB.First(&user, "id = ?", auth.UserId(r)).Error; err != nil {
+			utils.Error(w, http.StatusOK, "用户不存在")
+			return
+		}
+		if user.TotpEnabled {
+			utils.Error(w, http.StatusOK, "两步验证已启用")
+			return
+		}
+
+		// 生成待确认的密钥, 验证通过后才会启用
+		secret, err := auth.NewTOTPSecret()
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "密钥生成失败")
+			return
+		}
+		if err := gormDB.Model(&user).Updates(map[string]any{
+			"totp_secret":    secret,
+			"totp_last_step": 0,
+		}).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "密钥保存失败")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "请使用验证器应用扫描二维码后提交验证码",
+			"secret":  secret,
+			"uri":     auth.TOTPURI(totpIssuer, user.Name, secret),
+		})
+	}
+}
+
+// 启用两步验证 POST
+func EnableTwoFactor(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			Code        string `json:"code"`
+			Password    string `json:"password"`
+			ReauthToken string `json:"reauth_token"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		var user model.User
+		if err := gormDB.First(&user, "id = ?", auth.UserId(r)).Error; err != nil {
+			utils.Error(w, http.StatusOK, "用户不存在")
+			return
+		}
+		if user.TotpEnabled {
+			utils.Error(w, http.StatusOK, "两步验证已启用")
+			return
+		}
+		if user.TotpSecret == "" {
+			utils.Error(w, http.StatusOK, "请先获取两步验证密钥")
+			return
+		}
+
+		// 启用时会生成新的恢复码(可用于找回账户), 需确认身份
+		if err := confirmIdentity(gormDB, &user, req.Password, req.ReauthToken); err != nil {
+			limiter.Failed(r)
+			utils.Error(w, http.StatusOK, err.Error())
+			return
+		}
+		limiter.Succeeded(r)
+
+		// 确认验证器应用已正确配置
+		if !useTOTPCode(gormDB, &user, req.Code) {
+			utils.Error(w, http.StatusOK, "验证码错误")
+			return
+		}
+
+		var codes []string
+		err := gormDB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Update("totp_enabled", true).Error; err != nil {
+				return err
+			}
+			var err error
+			codes, err = generateRecoveryCodes(tx, user.Id)
+			return err
+		})
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "启用两步验证失败")
+			return
+		}
+		recordAudit(gormDB, r, user.Id, auditRecoveryCodesGenerated, "启用两步验证")
+
+		utils.Success(w, map[string]any{
+			"message":        "两步验证已启用, 请妥善保存恢复码",
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// 关闭两步验证 POST
+func DisableTwoFactor(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			Password    string `json:"password"`
+			ReauthToken string `json:"reauth_token"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		var user model.User
+		if err := gormDB.First(&user, "id = ?", auth.UserId(r)).Error; err != nil {
+			utils.Error(w, http.StatusOK, "用户不存在")
+			return
+		}
+
+		// 关闭前需确认身份
+		if err := confirmIdentity(gormDB, &user, req.Password, req.ReauthToken); err != nil {
+			limiter.Failed(r)
+			utils.Error(w, http.StatusOK, err.Error())
+			return
+		}
+		limiter.Succeeded(r)
+
+		// 恢复码同时用于找回密码, 关闭两步验证时保留
+		if err := gormDB.Model(&user).Updates(map[string]any{
+			"totp_enabled":   false,
+			"totp_secret":    "",
+			"totp_last_step": 0,
+		}).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "关闭两步验证失败")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "两步验证已关闭",
+		})
+	}
+}
+
+// 两步验证登录 POST
+func LoginTwoFactor(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			Challenge    string `json:"challenge"`
+			Code         string `json:"code"`          // 验证器应用中的验证码
+			RecoveryCode string `json:"recovery_code"` // 或使用恢复码
+			Device       string `json:"device"`        // 登录设备名称(可选)
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		userId, err := auth.ParseChallenge(config.Get().TokenSecret, req.Challenge)
+		if err != nil {
+			utils.Error(w, http.StatusUnauthorized, "登录凭据无效或已过期, 请重新登录")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		var user model.User
+		if err := gormDB.First(&user, "id = ?", userId).Error; err != nil || !user.TotpEnabled {
+			utils.Error(w, http.StatusUnauthorized, "登录凭据无效或已过期, 请重新登录")
+			return
+		}
+
+		// 校验验证码或恢复码
+		var ok bool
+		if req.RecoveryCode != "" {
+			ok, err = useRecoveryCode(gormDB, user.Id, req.RecoveryCode)
+			if err != nil {
+				utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+				return
+			}
+		} else {
+			ok = useTOTPCode(gormDB, &user, req.Code)
+		}
+		if !ok {
+			limiter.Failed(r)
+			utils.Error(w, http.StatusOK, "验证码错误")
+			return
+		}
+		limiter.Succeeded(r)
+
+		tokens, err := createSession(gormDB, r, user.Id, req.Device)
+		if err != nil {
+			sessionError(w, err)
+			return
+		}
+
+		tokens["message"] = "登录成功"
+		utils.Success(w, tokens)
+	}
+}
@@ -0,0 +1,230 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/config"
+	"sloth-tracker/api/internal/testdb"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/password"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func setupDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return testdb.Open(t, &model.User{}, &model.RecoveryCode{}, &model.Session{}, &model.AuditLog{})
+}
+
+// 计算当前时刻的 TOTP 验证码
+func currentTOTP(t *testing.T, secret string) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("解码密钥失败: %v", err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+// 创建已启用两步验证的用户, 返回用户, 登录凭据与恢复码
+func twoFactorUser(t *testing.T, db *gorm.DB) (*model.User, string, []string) {
+	t.Helper()
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	user := &model.User{Id: uuid.New().String(), Name: "user", TotpSecret: secret, TotpEnabled: true, RegisteredAt: time.Now()}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	codes, err := generateRecoveryCodes(db, user.Id)
+	if err != nil {
+		t.Fatalf("生成恢复码失败: %v", err)
+	}
+	challenge, err := auth.IssueChallenge(config.Get().TokenSecret, user.Id, challengeTTL)
+	if err != nil {
+		t.Fatalf("签发登录凭据失败: %v", err)
+	}
+	return user, challenge, codes
+}
+
+// 以用户身份调用接口, 返回是否成功
+func callAs(t *testing.T, handler http.HandlerFunc, userId string, req any) bool {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	r = r.WithContext(auth.WithUserId(r.Context(), userId))
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	var resp struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	return resp.Success
+}
+
+// 调用两步验证登录, 返回是否登录成功
+func loginTwoFactor(t *testing.T, db *gorm.DB, req map[string]string) bool {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	LoginTwoFactor(db)(w, httptest.NewRequest(http.MethodPost, "/api/user/login/2fa", bytes.NewReader(body)))
+
+	var resp struct {
+		Success bool `json:"success"`
+		Data    struct {
+			AccessToken string `json:"access_token"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	if resp.Success && resp.Data.AccessToken == "" {
+		t.Error("登录成功但未返回访问令牌")
+	}
+	return resp.Success
+}
+
+func TestLoginTwoFactorTOTP(t *testing.T) {
+	db := setupDB(t)
+	user, challenge, _ := twoFactorUser(t, db)
+
+	code := currentTOTP(t, user.TotpSecret)
+	n, _ := strconv.Atoi(code)
+	wrong := fmt.Sprintf("%06d", (n+1)%1000000)
+	if loginTwoFactor(t, db, map[string]string{"challenge": challenge, "code": wrong}) {
+		t.Error("错误的验证码不应登录成功")
+	}
+
+	if !loginTwoFactor(t, db, map[string]string{"challenge": challenge, "code": code}) {
+		t.Fatal("正确的验证码登录失败")
+	}
+	// 同一验证码不能重复使用
+	if loginTwoFactor(t, db, map[string]string{"challenge": challenge, "code": code}) {
+		t.Error("重复使用的验证码不应登录成功")
+	}
+
+	var sessions int64
+	db.Model(&model.Session{}).Where("user_id = ?", user.Id).Count(&sessions)
+	if sessions != 1 {
+		t.Errorf("会话数 = %d, 期望 1", sessions)
+	}
+}
+
+func TestLoginTwoFactorRecoveryCode(t *testing.T) {
+	db := setupDB(t)
+	user, challenge, codes := twoFactorUser(t, db)
+
+	if loginTwoFactor(t, db, map[string]string{"challenge": challenge, "recovery_code": "aaaa-bbbb"}) {
+		t.Error("错误的恢复码不应登录成功")
+	}
+	// 恢复码忽略大小写与连字符
+	if !loginTwoFactor(t, db, map[string]string{"challenge": challenge, "recovery_code": " " + codes[0][:4] + codes[0][5:] + " "}) {
+		t.Fatal("正确的恢复码登录失败")
+	}
+	if loginTwoFactor(t, db, map[string]string{"challenge": challenge, "recovery_code": codes[0]}) {
+		t.Error("已使用的恢复码不应登录成功")
+	}
+	if remaining := remainingRecoveryCodes(db, user.Id); remaining != int64(len(codes)-1) {
+		t.Errorf("剩余恢复码 = %d, 期望 %d", remaining, len(codes)-1)
+	}
+}
+
+func TestLoginTwoFactorInvalidChallenge(t *testing.T) {
+	db := setupDB(t)
+	user, _, _ := twoFactorUser(t, db)
+
+	if loginTwoFactor(t, db, map[string]string{"challenge": "invalid", "code": currentTOTP(t, user.TotpSecret)}) {
+		t.Error("无效的登录凭据不应登录成功")
+	}
+}
+
+func TestEnableTwoFactorRequiresPassword(t *testing.T) {
+	db := setupDB(t)
+	hash, err := password.Default().Hash("Passw0rd!xyz")
+	if err != nil {
+		t.Fatalf("计算密码哈希失败: %v", err)
+	}
+	user := &model.User{Id: uuid.New().String(), Name: "user", Password: hash, RegisteredAt: time.Now()}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+
+	if !callAs(t, SetupTwoFactor(db), user.Id, nil) {
+		t.Fatal("获取两步验证密钥失败")
+	}
+	db.First(user, "id = ?", user.Id)
+
+	// 缺少或错误的密码时不启用, 也不生成恢复码
+	for _, pw := range []string{"", "wrong"} {
+		if callAs(t, EnableTwoFactor(db), user.Id, map[string]string{"code": currentTOTP(t, user.TotpSecret), "password": pw}) {
+			t.Errorf("密码 %q 不应启用两步验证", pw)
+		}
+	}
+	db.First(user, "id = ?", user.Id)
+	if user.TotpEnabled || remainingRecoveryCodes(db, user.Id) != 0 {
+		t.Fatalf("未校验密码时已启用两步验证: %+v", user)
+	}
+
+	if !callAs(t, EnableTwoFactor(db), user.Id, map[string]string{"code": currentTOTP(t, user.TotpSecret), "password": "Passw0rd!xyz"}) {
+		t.Fatal("正确的密码与验证码启用失败")
+	}
+	db.First(user, "id = ?", user.Id)
+	if !user.TotpEnabled || remainingRecoveryCodes(db, user.Id) == 0 {
+		t.Errorf("启用后状态错误: %+v", user)
+	}
+}
+
+func TestPasswordlessUserReauth(t *testing.T) {
+	db := setupDB(t)
+	user := &model.User{Id: uuid.New().String(), Name: "oidc-user", RegisteredAt: time.Now()}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	secret := config.Get().TokenSecret
+
+	// 无密码账户不能通过空密码确认, 其他用途或其他用户的凭据也无效
+	challenge, _ := auth.IssueChallenge(secret, user.Id, challengeTTL)
+	other, _ := auth.IssueReauth(secret, uuid.New().String(), reauthTTL)
+	for _, req := range []map[string]string{
+		{"password": ""},
+		{"reauth_token": challenge},
+		{"reauth_token": other},
+	} {
+		if callAs(t, RegenerateRecoveryCodes(db), user.Id, req) {
+			t.Errorf("%v 不应通过身份确认", req)
+		}
+	}
+
+	token, err := auth.IssueReauth(secret, user.Id, reauthTTL)
+	if err != nil {
+		t.Fatalf("签发重新验证凭据失败: %v", err)
+	}
+	if !callAs(t, RegenerateRecoveryCodes(db), user.Id, map[string]string{"reauth_token": token}) {
+		t.Fatal("重新验证后生成恢复码失败")
+	}
+	if remainingRecoveryCodes(db, user.Id) == 0 {
+		t.Error("未生成恢复码")
+	}
+}
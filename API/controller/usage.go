@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"net/http"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/policy"
+	"sloth-tracker/api/usage"
+	"sloth-tracker/api/utils"
+
+	"gorm.io/gorm"
+)
+
+// 由当前状态生成应用会话所需的上报信息
+func usageReport(status *model.DeviceStatus, timestamp int64) usage.Report {
+	return usage.NewReport(timestamp, status.Foreground, status.Other)
+}
+
+// 获取应用使用统计 GET
+func GetAppUsage(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		deviceId := utils.GetQueryParam(r, "device_id")
+		if deviceId == "" {
+			utils.Error(w, http.StatusBadRequest, "参数错误: device_id 不能为空")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		// 时区(IANA 名称, 如 Asia/Shanghai), 默认使用用户设置的时区
+		location := userLocation(gormDB, auth.UserId(r))
+		if tz := utils.GetQueryParam(r, "tz"); tz != "" {
+			var err error
+			if location, err = loadTimezone(tz); err != nil {
+				utils.Error(w, http.StatusBadRequest, "参数错误: tz 无效")
+				return
+			}
+		}
+
+		date, from, to, err := dayRange(r, location)
+		if err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误: date 格式应为 YYYY-MM-DD")
+			return
+		}
+
+		if !authorizeDevice(w, r, gormDB, policy.View, deviceId) {
+			return
+		}
+
+		apps, timeline, err := usage.Summarize(gormDB, deviceId, from, to)
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+
+		var total int64
+		for _, app := range apps {
+			total += app.Duration
+		}
+
+		utils.Success(w, map[string]any{
+			"message":  "查询成功",
+			"date":     date,
+			"timezone": location.String(),
+			"total":    total,
+			"apps":     apps,
+			"timeline": timeline,
+		})
+	}
+}
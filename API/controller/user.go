@@ -2,13 +2,20 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/commands"
+	"sloth-tracker/api/config"
+	"sloth-tracker/api/events"
+	"sloth-tracker/api/limiter"
+	"sloth-tracker/api/metrics"
 	"sloth-tracker/api/model"
+	"sloth-tracker/api/password"
 	"sloth-tracker/api/utils"
 	"time"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -23,6 +30,7 @@ func RegisterUser(db any) http.HandlerFunc {
 		var req struct {
 			Name     string `json:"name"`
 			Password string `json:"password"`
+			Device   string `json:"device"` // 登录设备名称(可选)
 		}
 
 		// 解析JSON参数
@@ -40,8 +48,14 @@ func RegisterUser(db any) http.HandlerFunc {
 			return
 		}
 
+		// 检查密码强度
+		if err := password.Default().Validate(req.Password); err != nil {
+			utils.Error(w, http.StatusOK, err.Error())
+			return
+		}
+
 		userId := uuid.New().String()
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		hashedPassword, err := password.Default().Hash(req.Password)
 		if err != nil {
 			utils.Error(w, http.StatusInternalServerError, "密码加密失败")
 			return
@@ -50,15 +64,36 @@ func RegisterUser(db any) http.HandlerFunc {
 		user := model.User{
 			Id:           userId,
 			Name:         req.Name,
-			Password:     string(hashedPassword),
+			Password:     hashedPassword,
 			RegisteredAt: time.Now(),
 		}
-		gormDB.Create(&user)
 
-		utils.Success(w, map[string]any{
-			"message": "注册成功",
-			"user_id": userId,
+		// 创建用户并生成恢复码, 用于忘记密码时找回账户
+		var codes []string
+		err = gormDB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			var err error
+			codes, err = generateRecoveryCodes(tx, userId)
+			return err
 		})
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "用户创建失败")
+			return
+		}
+		recordAudit(gormDB, r, userId, auditRecoveryCodesGenerated, "注册")
+
+		// 注册后直接登录
+		tokens, err := createSession(gormDB, r, userId, req.Device)
+		if err != nil {
+			sessionError(w, err)
+			return
+		}
+
+		tokens["message"] = "注册成功, 请妥善保存恢复码"
+		tokens["recovery_codes"] = codes
+		utils.Success(w, tokens)
 	}
 }
 
@@ -73,6 +108,7 @@ func LoginUser(db any) http.HandlerFunc {
 		var req struct {
 			Name     string `json:"name"`
 			Password string `json:"password"`
+			Device   string `json:"device"` // 登录设备名称(可选)
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -85,21 +121,50 @@ func LoginUser(db any) http.HandlerFunc {
 		// 检查用户名是否存在
 		var user model.User
 		if err := gormDB.Where("name = ?", req.Name).First(&user).Error; err != nil {
+			limiter.Failed(r)
 			utils.Error(w, http.StatusOK, "用户名或密码错误")
 			return
 		}
 
-		// 检查密码是否正确
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		// 检查密码是否正确(旧算法生成的哈希会自动升级)
+		if !checkPassword(gormDB, &user, req.Password) {
+			limiter.Failed(r)
 			utils.Error(w, http.StatusOK, "用户名或密码错误")
 			return
 		}
+		limiter.Succeeded(r)
+
+		// 检查账户是否被禁用
+		if user.Disabled {
+			utils.Error(w, http.StatusForbidden, "账户已被禁用")
+			return
+		}
+
+		// 已启用两步验证, 需提交验证码后才能登录
+		if user.TotpEnabled {
+			challenge, err := auth.IssueChallenge(config.Get().TokenSecret, user.Id, challengeTTL)
+			if err != nil {
+				utils.Error(w, http.StatusInternalServerError, "令牌签发失败")
+				return
+			}
+			utils.Success(w, map[string]any{
+				"message":             "请输入两步验证码",
+				"two_factor_required": true,
+				"challenge":           challenge,
+			})
+			return
+		}
+
+		// 创建会话并签发令牌
+		tokens, err := createSession(gormDB, r, user.Id, req.Device)
+		if err != nil {
+			sessionError(w, err)
+			return
+		}
 
 		// 登录成功
-		utils.Success(w, map[string]any{
-			"message": "登录成功",
-			"user_id": user.Id,
-		})
+		tokens["message"] = "登录成功"
+		utils.Success(w, tokens)
 	}
 }
 
@@ -112,7 +177,6 @@ func ResetUsername(db any) http.HandlerFunc {
 		}
 
 		var req struct {
-			Id   string `json:"id"`
 			Name string `json:"name"`
 		}
 
@@ -132,7 +196,7 @@ func ResetUsername(db any) http.HandlerFunc {
 
 		// 更新用户名
 		var user model.User
-		if err := gormDB.First(&user, "id = ?", req.Id).Error; err != nil {
+		if err := gormDB.First(&user, "id = ?", auth.UserId(r)).Error; err != nil {
 			utils.Error(w, http.StatusOK, "用户不存在")
 			return
 		}
@@ -146,6 +210,60 @@ func ResetUsername(db any) http.HandlerFunc {
 	}
 }
 
+// 设置时区 PUT
+func SetTimezone(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			Timezone string `json:"timezone"` // IANA 时区名称, 如 Asia/Shanghai
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		if _, err := loadTimezone(req.Timezone); err != nil {
+			utils.Error(w, http.StatusBadRequest, "时区无效")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		if err := gormDB.Model(&model.User{}).Where("id = ?", auth.UserId(r)).Update("timezone", req.Timezone).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "设置时区失败")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "时区设置成功",
+		})
+	}
+}
+
+// 加载 IANA 时区, 空字符串为 UTC; Local 取决于服务器环境, 不允许使用
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "Local" {
+		return nil, errors.New("时区无效")
+	}
+	return time.LoadLocation(name)
+}
+
+// 获取用户时区, 未设置或无效时为 UTC
+func userLocation(gormDB *gorm.DB, userId string) *time.Location {
+	var user model.User
+	gormDB.Select("timezone").Where("id = ?", userId).Limit(1).Find(&user)
+	location, err := loadTimezone(user.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
 // 重置密码 PUT
 func ResetPassword(db any) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -155,9 +273,9 @@ func ResetPassword(db any) http.HandlerFunc {
 		}
 
 		var req struct {
-			Id          string `json:"id"`
 			OldPassword string `json:"old_password"`
 			NewPassword string `json:"new_password"`
+			ReauthToken string `json:"reauth_token"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -169,36 +287,49 @@ func ResetPassword(db any) http.HandlerFunc {
 
 		// 检查用户是否存在
 		var user model.User
-		if err := gormDB.Where("id = ?", req.Id).First(&user).Error; err != nil {
+		if err := gormDB.Where("id = ?", auth.UserId(r)).First(&user).Error; err != nil {
 			utils.Error(w, http.StatusOK, "用户名或密码错误")
 			return
 		}
 
-		// 检查旧密码是否正确
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.OldPassword)); err != nil {
-			utils.Error(w, http.StatusOK, "旧密码错误")
+		// 检查新密码强度
+		if err := password.Default().Validate(req.NewPassword); err != nil {
+			utils.Error(w, http.StatusOK, err.Error())
 			return
 		}
 
-		// 检查新密码是否和旧密码一致
-		if req.OldPassword == req.NewPassword {
-			utils.Error(w, http.StatusOK, "新密码不能和旧密码相同")
-			return
+		if user.Password == "" {
+			// 通过外部账户创建的无密码账户设置初始密码, 需先重新验证身份
+			if err := confirmIdentity(gormDB, &user, "", req.ReauthToken); err != nil {
+				limiter.Failed(r)
+				utils.Error(w, http.StatusOK, err.Error())
+				return
+			}
+			limiter.Succeeded(r)
+		} else {
+			// 检查旧密码是否正确(忘记密码时通过未登录的 /api/user/recover 找回)
+			if !checkPassword(gormDB, &user, req.OldPassword) {
+				limiter.Failed(r)
+				utils.Error(w, http.StatusOK, "旧密码错误")
+				return
+			}
+			limiter.Succeeded(r)
+
+			// 检查新密码是否和旧密码一致
+			if req.OldPassword == req.NewPassword {
+				utils.Error(w, http.StatusOK, "新密码不能和旧密码相同")
+				return
+			}
 		}
 
-		// 加密新密码
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
-		if err != nil {
-			utils.Error(w, http.StatusInternalServerError, "新密码加密失败")
+		// 更新密码并吊销所有会话, 需重新登录
+		if err := setPassword(gormDB, &user, req.NewPassword); err != nil {
+			utils.Error(w, http.StatusInternalServerError, "密码重置失败")
 			return
 		}
 
-		// 更新密码
-		user.Password = string(hashedPassword)
-		gormDB.Save(&user)
-
 		utils.Success(w, map[string]any{
-			"message": "密码重置成功",
+			"message": "密码重置成功, 请重新登录",
 		})
 	}
 }
@@ -211,13 +342,7 @@ func GetUserInfo(db any) http.HandlerFunc {
 			return
 		}
 
-		// 从查询参数获取user_id
-		userId := utils.GetQueryParam(r, "user_id")
-		if userId == "" {
-			utils.Error(w, http.StatusBadRequest, "参数错误: user_id 不能为空")
-			return
-		}
-
+		userId := auth.UserId(r)
 		gormDB := db.(*gorm.DB)
 
 		var user model.User
@@ -229,9 +354,12 @@ func GetUserInfo(db any) http.HandlerFunc {
 		utils.Success(w, map[string]any{
 			"message": "获取用户信息成功",
 			"user": map[string]any{
-				"id":            user.Id,
-				"name":          user.Name,
-				"registered_at": user.RegisteredAt,
+				"id":                       user.Id,
+				"name":                     user.Name,
+				"totp_enabled":             user.TotpEnabled,
+				"timezone":                 user.Timezone,
+				"registered_at":            user.RegisteredAt,
+				"recovery_codes_remaining": remainingRecoveryCodes(gormDB, user.Id),
 			},
 		})
 	}
@@ -246,8 +374,8 @@ func DeleteUser(db any) http.HandlerFunc {
 		}
 
 		var req struct {
-			Id       string `json:"id"`
-			Password string `json:"password"`
+			Password    string `json:"password"`
+			ReauthToken string `json:"reauth_token"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -258,59 +386,129 @@ func DeleteUser(db any) http.HandlerFunc {
 		gormDB := db.(*gorm.DB)
 
 		// 检查用户是否存在
+		userId := auth.UserId(r)
 		var user model.User
-		if err := gormDB.First(&user, "id = ?", req.Id).Error; err != nil {
+		if err := gormDB.First(&user, "id = ?", userId).Error; err != nil {
 			utils.Error(w, http.StatusOK, "用户不存在")
 			return
 		}
 
-		// 检查密码是否正确
-		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-			utils.Error(w, http.StatusOK, "密码错误")
+		// 确认身份(当前密码或重新验证凭据)
+		if err := confirmIdentity(gormDB, &user, req.Password, req.ReauthToken); err != nil {
+			limiter.Failed(r)
+			utils.Error(w, http.StatusOK, err.Error())
 			return
 		}
+		limiter.Succeeded(r)
 
-		// 启动事务
+		// 删除用户及其关联数据
 		tx := gormDB.Begin()
-
-		// 删除用户
-		if err := tx.Delete(&user).Error; err != nil {
-			tx.Rollback()
-			utils.Error(w, http.StatusInternalServerError, "用户注销失败-删除用户失败")
-			return
-		}
-
-		// 获取用户有关的所有设备ID
-		var deviceIds []string
-		if err := tx.Model(&model.Device{}).
-			Where("owner_id = ?", req.Id).
-			Pluck("id", &deviceIds).Error; err != nil {
+		deviceIds, step, err := deleteUserCascade(tx, userId)
+		if err != nil {
 			tx.Rollback()
-			utils.Error(w, http.StatusInternalServerError, "用户注销失败-获取设备ID失败")
+			utils.Error(w, http.StatusInternalServerError, "用户注销失败-"+step)
 			return
 		}
-
-		// 删除用户所有设备状态
-		if len(deviceIds) > 0 {
-			if err := tx.Where("id IN ?", deviceIds).Delete(&model.DeviceStatus{}).Error; err != nil {
-				tx.Rollback()
-				utils.Error(w, http.StatusInternalServerError, "用户注销失败-删除设备状态失败")
-				return
-			}
-
-			// 删除用户所有设备
-			if err := tx.Where("id IN ?", deviceIds).Delete(&model.Device{}).Error; err != nil {
-				tx.Rollback()
-				utils.Error(w, http.StatusInternalServerError, "用户注销失败-删除设备失败")
-				return
-			}
-		}
-
-		// 提交事务
 		tx.Commit()
 
+		events.Publish(events.UserDeleted{UserId: userId, DeviceIds: deviceIds})
+
 		utils.Success(w, map[string]any{
 			"message": "用户注销成功",
 		})
 	}
 }
+
+// 在事务中删除用户及其会话, 设备, 共享等关联数据, 返回随用户删除的设备ID; 失败时返回出错的步骤
+func deleteUserCascade(tx *gorm.DB, userId string) ([]string, string, error) {
+	// 删除用户
+	if err := tx.Where("id = ?", userId).Delete(&model.User{}).Error; err != nil {
+		return nil, "删除用户失败", err
+	}
+
+	// 删除用户所有恢复码
+	if err := tx.Where("user_id = ?", userId).Delete(&model.RecoveryCode{}).Error; err != nil {
+		return nil, "删除恢复码失败", err
+	}
+
+	// 删除用户所有会话
+	if err := tx.Where("user_id = ?", userId).Delete(&model.Session{}).Error; err != nil {
+		return nil, "删除会话失败", err
+	}
+
+	// 删除绑定的外部账户
+	if err := tx.Where("user_id = ?", userId).Delete(&model.ExternalIdentity{}).Error; err != nil {
+		return nil, "删除外部账户失败", err
+	}
+
+	// 删除用户的 Webhook 与投递记录
+	webhookIds := tx.Model(&model.Webhook{}).Select("id").Where("user_id = ?", userId)
+	if err := tx.Where("webhook_id IN (?)", webhookIds).Delete(&model.WebhookDelivery{}).Error; err != nil {
+		return nil, "删除 Webhook 投递记录失败", err
+	}
+	if err := tx.Where("user_id = ?", userId).Delete(&model.Webhook{}).Error; err != nil {
+		return nil, "删除 Webhook 失败", err
+	}
+
+	// 删除用户申请的共享
+	if err := tx.Where("viewer_id = ?", userId).Delete(&model.SharedDevice{}).Error; err != nil {
+		return nil, "删除共享记录失败", err
+	}
+
+	// 获取用户有关的所有设备ID
+	var deviceIds []string
+	if err := tx.Model(&model.Device{}).
+		Where("owner_id = ?", userId).
+		Pluck("id", &deviceIds).Error; err != nil {
+		return nil, "获取设备ID失败", err
+	}
+	if len(deviceIds) == 0 {
+		return deviceIds, "", nil
+	}
+
+	// 删除用户所有设备的共享记录
+	if err := tx.Where("device_id IN ?", deviceIds).Delete(&model.SharedDevice{}).Error; err != nil {
+		return nil, "删除共享记录失败", err
+	}
+
+	// 删除用户所有设备状态
+	if err := tx.Where("device_id IN ?", deviceIds).Delete(&model.DeviceStatus{}).Error; err != nil {
+		return nil, "删除设备状态失败", err
+	}
+
+	// 删除用户所有设备的历史状态
+	if err := tx.Where("device_id IN ?", deviceIds).Delete(&model.DeviceStatusHistory{}).Error; err != nil {
+		return nil, "删除历史状态失败", err
+	}
+
+	// 删除用户所有设备的聚合数据
+	if err := tx.Where("device_id IN ?", deviceIds).Delete(&model.StatusRollup{}).Error; err != nil {
+		return nil, "删除聚合数据失败", err
+	}
+
+	// 删除用户所有设备的在线状态记录
+	if err := tx.Where("device_id IN ?", deviceIds).Delete(&model.PresenceEvent{}).Error; err != nil {
+		return nil, "删除在线状态记录失败", err
+	}
+
+	// 删除用户所有设备的应用会话
+	if err := tx.Where("device_id IN ?", deviceIds).Delete(&model.AppSession{}).Error; err != nil {
+		return nil, "删除应用会话失败", err
+	}
+
+	// 删除用户所有设备的自定义指标
+	if err := metrics.Delete(tx, deviceIds); err != nil {
+		return nil, "删除自定义指标失败", err
+	}
+
+	// 删除用户所有设备的远程命令
+	if err := commands.Delete(tx, deviceIds); err != nil {
+		return nil, "删除远程命令失败", err
+	}
+
+	// 删除用户所有设备
+	if err := tx.Where("id IN ?", deviceIds).Delete(&model.Device{}).Error; err != nil {
+		return nil, "删除设备失败", err
+	}
+	return deviceIds, "", nil
+}
@@ -0,0 +1,358 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/policy"
+	"sloth-tracker/api/utils"
+	"sloth-tracker/api/webhooks"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 查询当前用户的 Webhook, 不存在时返回 404
+func ownWebhook(w http.ResponseWriter, r *http.Request, gormDB *gorm.DB, id string) (*model.Webhook, bool) {
+	if id == "" {
+		utils.Error(w, http.StatusBadRequest, "参数错误: id 不能为空")
+		return nil, false
+	}
+	var hook model.Webhook
+	if err := gormDB.Where("id = ? AND user_id = ?", id, auth.UserId(r)).Limit(1).Find(&hook).Error; err != nil {
+		utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+		return nil, false
+	}
+	if hook.Id == "" {
+		utils.Error(w, http.StatusNotFound, "Webhook 不存在")
+		return nil, false
+	}
+	return &hook, true
+}
+
+// 创建 Webhook POST
+func CreateWebhook(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			URL              string   `json:"url"`
+			Events           []string `json:"events"`
+			DeviceId         string   `json:"device_id"`
+			BatteryThreshold *int     `json:"battery_threshold"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		if err := webhooks.ValidateURL(req.URL); err != nil {
+			utils.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		kinds, err := webhooks.ValidateEvents(req.Events)
+		if err != nil {
+			utils.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		threshold := webhooks.DefaultBatteryThreshold
+		if req.BatteryThreshold != nil {
+			threshold = *req.BatteryThreshold
+		}
+		if err := webhooks.ValidateThreshold(threshold); err != nil {
+			utils.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+		userId := auth.UserId(r)
+
+		if req.DeviceId != "" && !authorizeDevice(w, r, gormDB, policy.View, req.DeviceId) {
+			return
+		}
+
+		var count int64
+		if err := gormDB.Model(&model.Webhook{}).Where("user_id = ?", userId).Count(&count).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+		if count >= webhooks.MaxPerUser {
+			utils.Error(w, http.StatusBadRequest, webhooks.ErrTooMany.Error())
+			return
+		}
+
+		secret, err := webhooks.NewSecret()
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "生成密钥失败")
+			return
+		}
+
+		hook := model.Webhook{
+			Id:               uuid.New().String(),
+			UserId:           userId,
+			URL:              req.URL,
+			Secret:           secret,
+			Events:           kinds,
+			DeviceId:         req.DeviceId,
+			BatteryThreshold: threshold,
+			Enabled:          true,
+			CreatedAt:        time.Now(),
+		}
+		if err := gormDB.Create(&hook).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "创建 Webhook 失败")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "创建成功, 密钥仅显示一次, 请妥善保存",
+			"webhook": hook,
+			"secret":  secret,
+		})
+	}
+}
+
+// 获取当前用户的 Webhook 列表 GET
+func GetWebhooks(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		var list []model.Webhook
+		if err := gormDB.Where("user_id = ?", auth.UserId(r)).Order("created_at").Find(&list).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "查询数据库出错")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message":  "查询成功",
+			"webhooks": list,
+			"events":   webhooks.Events,
+		})
+	}
+}
+
+// 修改 Webhook PUT
+func UpdateWebhook(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			Id               string    `json:"id"`
+			URL              *string   `json:"url"`
+			Events           *[]string `json:"events"`
+			DeviceId         *string   `json:"device_id"`
+			BatteryThreshold *int      `json:"battery_threshold"`
+			Enabled          *bool     `json:"enabled"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		hook, ok := ownWebhook(w, r, gormDB, req.Id)
+		if !ok {
+			return
+		}
+
+		updates := map[string]any{}
+		if req.URL != nil {
+			if err := webhooks.ValidateURL(*req.URL); err != nil {
+				utils.Error(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			updates["url"] = *req.URL
+		}
+		if req.Events != nil {
+			kinds, err := webhooks.ValidateEvents(*req.Events)
+			if err != nil {
+				utils.Error(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			data, _ := json.Marshal(kinds)
+			updates["events"] = string(data)
+		}
+		if req.DeviceId != nil {
+			if *req.DeviceId != "" && !authorizeDevice(w, r, gormDB, policy.View, *req.DeviceId) {
+				return
+			}
+			updates["device_id"] = *req.DeviceId
+		}
+		if req.BatteryThreshold != nil {
+			if err := webhooks.ValidateThreshold(*req.BatteryThreshold); err != nil {
+				utils.Error(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			updates["battery_threshold"] = *req.BatteryThreshold
+		}
+		if req.Enabled != nil {
+			updates["enabled"] = *req.Enabled
+			// 重新启用时清除自动停用的记录
+			if *req.Enabled {
+				updates["failures"] = 0
+				updates["disabled_reason"] = ""
+			}
+		}
+		if len(updates) == 0 {
+			utils.Error(w, http.StatusBadRequest, "参数错误: 没有需要修改的字段")
+			return
+		}
+
+		if err := gormDB.Model(hook).Updates(updates).Error; err != nil {
+			utils.Error(w, http.StatusInternalServerError, "修改 Webhook 失败")
+			return
+		}
+		gormDB.First(hook, "id = ?", hook.Id)
+
+		utils.Success(w, map[string]any{
+			"message": "修改成功",
+			"webhook": hook,
+		})
+	}
+}
+
+// 删除 Webhook 及其投递记录 DELETE
+func DeleteWebhook(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			Id string `json:"id"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		hook, ok := ownWebhook(w, r, gormDB, req.Id)
+		if !ok {
+			return
+		}
+
+		err := gormDB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("webhook_id = ?", hook.Id).Delete(&model.WebhookDelivery{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(hook).Error
+		})
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "删除 Webhook 失败")
+			return
+		}
+
+		utils.Success(w, map[string]any{
+			"message": "删除成功",
+		})
+	}
+}
+
+// 获取 Webhook 的投递记录 GET
+func GetWebhookDeliveries(db any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		status := utils.GetQueryParam(r, "status")
+		switch status {
+		case "", webhooks.Pending, webhooks.Succeeded, webhooks.Failed:
+		default:
+			utils.Error(w, http.StatusBadRequest, "参数错误: status 无效")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		hook, ok := ownWebhook(w, r, gormDB, utils.GetQueryParam(r, "id"))
+		if !ok {
+			return
+		}
+
+		page, pageSize := utils.GetPagination(r)
+		query := gormDB.Model(&model.WebhookDelivery{}).Where("webhook_id = ?", hook.Id)
+		if status != "" {
+			query = query.Where("status = ?", status)
+		}
+
+		var total int64
+		query.Count(&total)
+
+		var list []model.WebhookDelivery
+		query.Order("created_at DESC").
+			Offset((page - 1) * pageSize).
+			Limit(pageSize).
+			Find(&list)
+
+		utils.Success(w, map[string]any{
+			"message":    "查询成功",
+			"deliveries": list,
+			"total":      total,
+			"page":       page,
+		})
+	}
+}
+
+// 向 Webhook 发送测试事件 POST
+func TestWebhook(db any, dispatcher *webhooks.Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			utils.Error(w, http.StatusMethodNotAllowed, "方法不允许")
+			return
+		}
+
+		var req struct {
+			Id string `json:"id"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			utils.Error(w, http.StatusBadRequest, "参数错误")
+			return
+		}
+
+		gormDB := db.(*gorm.DB)
+
+		hook, ok := ownWebhook(w, r, gormDB, req.Id)
+		if !ok {
+			return
+		}
+		if !hook.Enabled {
+			utils.Error(w, http.StatusConflict, "Webhook 已停用")
+			return
+		}
+
+		data := map[string]any{"webhook_id": hook.Id, "message": "这是一条测试消息"}
+		delivery, err := webhooks.Enqueue(gormDB, hook, uuid.New().String(), webhooks.Test, data, time.Now())
+		if err != nil {
+			utils.Error(w, http.StatusInternalServerError, "创建投递失败")
+			return
+		}
+		dispatcher.Notify()
+
+		utils.Success(w, map[string]any{
+			"message":  "测试事件已加入投递队列",
+			"delivery": delivery,
+		})
+	}
+}
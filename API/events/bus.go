@@ -0,0 +1,145 @@
+package events
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// Subscriber 一个订阅者, 在独立的 goroutine 中按发布顺序处理事件
+type Subscriber struct {
+	name    string
+	types   map[Type]bool // 为空表示接收全部类型
+	events  chan Event
+	handler func(Event)
+	handled atomic.Int64
+	dropped atomic.Int64
+}
+
+// Stats 订阅者的处理情况
+type Stats struct {
+	Name     string `json:"name"`
+	Pending  int    `json:"pending"`  // 缓冲区中等待处理的事件数
+	Capacity int    `json:"capacity"` // 缓冲区大小
+	Handled  int64  `json:"handled"`  // 已处理的事件数
+	Dropped  int64  `json:"dropped"`  // 因缓冲区已满丢弃的事件数
+}
+
+// Bus 进程内的事件总线. 发布不会阻塞, 订阅者处理过慢时丢弃其新事件
+type Bus struct {
+	mu     sync.RWMutex
+	subs   []*Subscriber
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewBus 创建事件总线
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe 注册订阅者, 应在启动时调用. buffer 为缓冲区大小, types 为空时接收全部类型
+func (b *Bus) Subscribe(name string, buffer int, handler func(Event), types ...Type) *Subscriber {
+	sub := &Subscriber{
+		name:    name,
+		events:  make(chan Event, max(buffer, 1)),
+		handler: handler,
+	}
+	if len(types) > 0 {
+		sub.types = make(map[Type]bool, len(types))
+		for _, kind := range types {
+			sub.types[kind] = true
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.events)
+		return sub
+	}
+	b.subs = append(b.subs, sub)
+	b.wg.Add(1)
+	go b.run(sub)
+	return sub
+}
+
+// 依次处理事件, 单个事件处理出错不影响后续事件
+func (b *Bus) run(sub *Subscriber) {
+	defer b.wg.Done()
+	for event := range sub.events {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Printf("⚠️ 事件订阅者 %s 处理 %s 失败: %v", sub.name, event.Type(), err)
+				}
+			}()
+			sub.handler(event)
+		}()
+		sub.handled.Add(1)
+	}
+}
+
+// Publish 发布事件, 不会阻塞调用方
+func (b *Bus) Publish(event Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return
+	}
+	for _, sub := range b.subs {
+		if sub.types != nil && !sub.types[event.Type()] {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			if sub.dropped.Add(1)%100 == 1 {
+				log.Printf("⚠️ 事件订阅者 %s 处理过慢, 已丢弃 %d 个事件", sub.name, sub.dropped.Load())
+			}
+		}
+	}
+}
+
+// Stats 各订阅者的处理情况
+func (b *Bus) Stats() []Stats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	stats := make([]Stats, 0, len(b.subs))
+	for _, sub := range b.subs {
+		stats = append(stats, Stats{
+			Name:     sub.name,
+			Pending:  len(sub.events),
+			Capacity: cap(sub.events),
+			Handled:  sub.handled.Load(),
+			Dropped:  sub.dropped.Load(),
+		})
+	}
+	return stats
+}
+
+// Close 停止接收事件, 等待订阅者处理完缓冲区中的事件
+func (b *Bus) Close() {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		for _, sub := range b.subs {
+			close(sub.events)
+		}
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+// Default 全局事件总线
+var Default = NewBus()
+
+// Publish 向全局事件总线发布事件
+func Publish(event Event) {
+	Default.Publish(event)
+}
+
+// Subscribe 向全局事件总线注册订阅者
+func Subscribe(name string, buffer int, handler func(Event), types ...Type) *Subscriber {
+	return Default.Subscribe(name, buffer, handler, types...)
+}
@@ -0,0 +1,78 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestPublishFiltersTypes(t *testing.T) {
+	bus := NewBus()
+	var all, devices []Event
+	bus.Subscribe("all", 16, func(event Event) { all = append(all, event) })
+	bus.Subscribe("devices", 16, func(event Event) { devices = append(devices, event) }, TypeDeviceRegistered, TypeDeviceDeleted)
+
+	bus.Publish(DeviceRegistered{DeviceId: "a"})
+	bus.Publish(StatusUpdated{DeviceId: "a"})
+	bus.Publish(DeviceDeleted{DeviceId: "a"})
+	bus.Close()
+
+	if len(all) != 3 {
+		t.Errorf("全部事件订阅者收到 %d 个事件, 期望 3", len(all))
+	}
+	if len(devices) != 2 || devices[0].Type() != TypeDeviceRegistered || devices[1].Type() != TypeDeviceDeleted {
+		t.Errorf("设备事件订阅者收到 %+v", devices)
+	}
+}
+
+func TestPublishDoesNotBlock(t *testing.T) {
+	bus := NewBus()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var handled []string
+	bus.Subscribe("slow", 2, func(event Event) {
+		if len(handled) == 0 {
+			close(started)
+			<-release
+		}
+		handled = append(handled, event.(DeviceDeleted).DeviceId)
+	})
+
+	// 第一个事件正在处理, 缓冲区可再容纳两个, 其余丢弃
+	bus.Publish(DeviceDeleted{DeviceId: "1"})
+	<-started
+	for _, id := range []string{"2", "3", "4", "5"} {
+		bus.Publish(DeviceDeleted{DeviceId: id})
+	}
+	close(release)
+	bus.Close()
+
+	if len(handled) != 3 || handled[0] != "1" || handled[1] != "2" || handled[2] != "3" {
+		t.Errorf("处理的事件 = %v", handled)
+	}
+	stats := bus.Stats()
+	if len(stats) != 1 || stats[0].Dropped != 2 || stats[0].Handled != 3 {
+		t.Errorf("Stats = %+v", stats)
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	bus := NewBus()
+	var handled []string
+	bus.Subscribe("panics", 4, func(event Event) {
+		id := event.(UserDeleted).UserId
+		if id == "bad" {
+			panic("boom")
+		}
+		handled = append(handled, id)
+	})
+
+	bus.Publish(UserDeleted{UserId: "bad"})
+	bus.Publish(UserDeleted{UserId: "good"})
+	bus.Close()
+
+	if len(handled) != 1 || handled[0] != "good" {
+		t.Errorf("出错后应继续处理后续事件, 处理的事件 = %v", handled)
+	}
+
+	// 关闭后发布的事件被忽略
+	bus.Publish(UserDeleted{UserId: "late"})
+}
@@ -0,0 +1,92 @@
+package events
+
+import (
+	"sloth-tracker/api/model"
+)
+
+// Type 事件类型
+type Type string
+
+const (
+	TypeStatusUpdated    Type = "status.updated"    // 设备上报的状态已保存
+	TypeDeviceRegistered Type = "device.registered" // 注册了新设备
+	TypeDeviceDeleted    Type = "device.deleted"    // 设备已注销
+	TypeShareRequested   Type = "share.requested"   // 用户申请查看设备
+	TypeShareApproved    Type = "share.approved"    // 设备所有者同意共享
+	TypeShareRevoked     Type = "share.revoked"     // 共享被撤销或删除
+	TypeUserDeleted      Type = "user.deleted"      // 用户已注销或被管理员删除
+	TypePresenceChanged  Type = "device.presence"   // 设备在线状态变化
+)
+
+// Event 领域事件, 在数据库事务提交后发布
+type Event interface {
+	Type() Type
+}
+
+// StatusUpdated 设备上报的状态已保存
+type StatusUpdated struct {
+	DeviceId string              `json:"device_id"`
+	Status   model.DeviceStatus  `json:"status"`             // 合并后的最新状态
+	Previous *model.DeviceStatus `json:"previous,omitempty"` // 本次上报前的状态(首次上报时为空)
+}
+
+// DeviceRegistered 注册了新设备
+type DeviceRegistered struct {
+	DeviceId string `json:"device_id"`
+	OwnerId  string `json:"owner_id"`
+	Name     string `json:"name"`
+	Platform string `json:"platform"`
+}
+
+// DeviceDeleted 设备已注销
+type DeviceDeleted struct {
+	DeviceId string `json:"device_id"`
+	OwnerId  string `json:"owner_id"`
+}
+
+// ShareRequested 用户申请查看设备
+type ShareRequested struct {
+	ShareId  string `json:"share_id"`
+	DeviceId string `json:"device_id"`
+	OwnerId  string `json:"owner_id"`
+	ViewerId string `json:"viewer_id"`
+}
+
+// ShareApproved 设备所有者同意共享
+type ShareApproved struct {
+	ShareId  string `json:"share_id"`
+	DeviceId string `json:"device_id"`
+	OwnerId  string `json:"owner_id"`
+	ViewerId string `json:"viewer_id"`
+}
+
+// ShareRevoked 共享被撤销(由所有者取消授权, 任一方删除或管理员撤销)
+type ShareRevoked struct {
+	ShareId  string `json:"share_id"`
+	DeviceId string `json:"device_id"`
+	OwnerId  string `json:"owner_id"`
+	ViewerId string `json:"viewer_id"`
+	Approved bool   `json:"approved"` // 撤销前是否已授权
+}
+
+// PresenceChanged 设备在线状态变化
+type PresenceChanged struct {
+	DeviceId string `json:"device_id"`
+	From     string `json:"from"` // 变化前状态(online, stale, offline)
+	To       string `json:"to"`   // 变化后状态
+}
+
+// UserDeleted 用户已注销或被管理员删除
+type UserDeleted struct {
+	UserId    string   `json:"user_id"`
+	DeviceIds []string `json:"device_ids"` // 随用户一起删除的设备
+}
+
+func (StatusUpdated) Type() Type    { return TypeStatusUpdated }
+func (DeviceRegistered) Type() Type { return TypeDeviceRegistered }
+func (DeviceDeleted) Type() Type    { return TypeDeviceDeleted }
+func (ShareRequested) Type() Type   { return TypeShareRequested }
+func (ShareApproved) Type() Type    { return TypeShareApproved }
+func (ShareRevoked) Type() Type     { return TypeShareRevoked }
+func (UserDeleted) Type() Type      { return TypeUserDeleted }
+func (PresenceChanged) Type() Type  { return TypePresenceChanged }
@@ -0,0 +1,31 @@
+package testdb
+
+import (
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Open 打开以测试名命名的共享缓存内存数据库并迁移指定的模型, 测试结束时关闭;
+// 同一测试内的多个连接共用一个数据库
+func Open(t testing.TB, models ...any) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	// 最后一个连接关闭后内存数据库随之删除, 重复运行测试(-count)时不会残留上次的数据
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatalf("迁移数据库失败: %v", err)
+	}
+	return db
+}
@@ -0,0 +1,82 @@
+package limiter
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Guard 同时按IP与账户限制密码校验
+type Guard struct {
+	IP      *Limiter
+	Account *Limiter
+}
+
+// Attempt 一次受保护的密码校验
+type Attempt struct {
+	guard    *Guard
+	ip       string
+	account  string
+	released bool
+}
+
+type contextKey struct{}
+
+// Check 检查IP与账户是否被限制, locked 表示账户已被锁定; 未被限制时为本次校验预留名额(见 Limiter.Check)
+func (g *Guard) Check(ip, account string) (retryAfter time.Duration, locked bool) {
+	if retryAfter, _ = g.IP.Check(ip); retryAfter > 0 {
+		return retryAfter, false
+	}
+	if account != "" {
+		if retryAfter, locked = g.Account.Check(account); retryAfter > 0 {
+			g.IP.Release(ip)
+			return retryAfter, locked
+		}
+	}
+	return 0, false
+}
+
+// Begin 将本次校验写入上下文, 供控制器上报结果
+func (g *Guard) Begin(ctx context.Context, ip, account string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &Attempt{guard: g, ip: ip, account: account})
+}
+
+// End 释放未上报结果的校验预留的名额
+func End(ctx context.Context) {
+	if a, ok := ctx.Value(contextKey{}).(*Attempt); ok {
+		a.release()
+	}
+}
+
+// Failed 上报密码校验失败
+func Failed(r *http.Request) {
+	if a, ok := r.Context().Value(contextKey{}).(*Attempt); ok {
+		a.guard.IP.Failure(a.ip)
+		if a.account != "" {
+			a.guard.Account.Failure(a.account)
+		}
+		a.release()
+	}
+}
+
+// Succeeded 上报密码校验成功, 清除账户的失败记录(IP记录保留, 防止借用自有账户重置计数)
+func Succeeded(r *http.Request) {
+	if a, ok := r.Context().Value(contextKey{}).(*Attempt); ok {
+		if a.account != "" {
+			a.guard.Account.Success(a.account)
+		}
+		a.release()
+	}
+}
+
+// 释放预留的名额, 多次调用只释放一次
+func (a *Attempt) release() {
+	if a.released {
+		return
+	}
+	a.released = true
+	a.guard.IP.Release(a.ip)
+	if a.account != "" {
+		a.guard.Account.Release(a.account)
+	}
+}
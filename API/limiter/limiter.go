@@ -0,0 +1,151 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// Options 限流参数
+type Options struct {
+	FreeAttempts     int           // 无延迟的失败次数
+	BaseDelay        time.Duration // 超出后首次延迟, 之后每次失败翻倍
+	MaxDelay         time.Duration // 单次延迟上限
+	LockoutThreshold int           // 连续失败达到该次数后锁定(0 表示不锁定)
+	LockoutDuration  time.Duration // 锁定时长, 同时也是失败记录的遗忘时间
+}
+
+// Limiter 按键记录失败次数, 实现渐进延迟与临时锁定
+type Limiter struct {
+	opts    Options
+	mu      sync.Mutex
+	entries map[string]*entry
+	calls   int
+}
+
+type entry struct {
+	failures     int
+	pending      int // 已通过检查但尚未上报结果的尝试
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// 每记录多少次失败清理一次过期条目
+const sweepEvery = 1000
+
+// New 创建限流器
+func New(opts Options) *Limiter {
+	return &Limiter{
+		opts:    opts,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Check 检查键是否被限制, 返回需要等待的时间及是否处于锁定状态.
+// 未被限制时为本次尝试预留名额, 调用方需在得到结果后调用 Release;
+// 进行中的尝试按失败计算, 防止并发请求在首次失败记录前绕过延迟
+func (l *Limiter) Check(key string) (retryAfter time.Duration, locked bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	e := l.entry(key, now)
+	if now.Before(e.blockedUntil) {
+		return e.blockedUntil.Sub(now), l.opts.LockoutThreshold > 0 && e.failures >= l.opts.LockoutThreshold
+	}
+
+	// 超出无延迟次数后同一时间只允许一次尝试
+	limit := l.opts.FreeAttempts
+	if l.opts.LockoutThreshold > 0 {
+		limit = min(limit, l.opts.LockoutThreshold-1)
+	}
+	if e.pending > 0 && e.failures+e.pending >= limit {
+		return max(l.opts.BaseDelay, time.Second), false
+	}
+	e.pending++
+	return 0, false
+}
+
+// Release 释放 Check 预留的名额
+func (l *Limiter) Release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok || e.pending == 0 {
+		return
+	}
+	e.pending--
+	if e.pending == 0 && e.failures == 0 {
+		delete(l.entries, key)
+	}
+}
+
+// Failure 记录一次失败
+func (l *Limiter) Failure(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.calls++
+	if l.calls%sweepEvery == 0 {
+		l.sweep(now)
+	}
+
+	e := l.entry(key, now)
+	e.failures++
+	e.lastFailure = now
+
+	switch {
+	case l.opts.LockoutThreshold > 0 && e.failures >= l.opts.LockoutThreshold:
+		e.blockedUntil = now.Add(l.opts.LockoutDuration)
+	case e.failures > l.opts.FreeAttempts:
+		e.blockedUntil = now.Add(l.delay(e.failures - l.opts.FreeAttempts))
+	}
+}
+
+// Success 清除键的失败记录
+func (l *Limiter) Success(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return
+	}
+	if e.pending == 0 {
+		delete(l.entries, key)
+		return
+	}
+	// 保留进行中的预留, 由 Release 释放
+	*e = entry{pending: e.pending}
+}
+
+// 获取键的条目, 失败记录超过遗忘时间后清零(保留进行中的预留)
+func (l *Limiter) entry(key string, now time.Time) *entry {
+	e, ok := l.entries[key]
+	if !ok {
+		e = &entry{}
+		l.entries[key] = e
+	} else if e.failures > 0 && now.Sub(e.lastFailure) > l.opts.LockoutDuration {
+		*e = entry{pending: e.pending}
+	}
+	return e
+}
+
+// 计算第n次超额失败后的延迟
+func (l *Limiter) delay(n int) time.Duration {
+	d := l.opts.BaseDelay
+	for i := 1; i < n && d < l.opts.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, l.opts.MaxDelay)
+}
+
+// 清理已过期的条目
+func (l *Limiter) sweep(now time.Time) {
+	for key, e := range l.entries {
+		if e.pending == 0 && now.After(e.blockedUntil) && now.Sub(e.lastFailure) > l.opts.LockoutDuration {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package limiter
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+var testOpts = Options{
+	FreeAttempts:     2,
+	BaseDelay:        time.Minute,
+	MaxDelay:         4 * time.Minute,
+	LockoutThreshold: 8,
+	LockoutDuration:  time.Hour,
+}
+
+// 检查并立即上报失败
+func fail(t *testing.T, l *Limiter, key string) {
+	t.Helper()
+	if retryAfter, _ := l.Check(key); retryAfter > 0 {
+		t.Fatalf("Check 返回等待 %v, 期望允许尝试", retryAfter)
+	}
+	l.Failure(key)
+	l.Release(key)
+}
+
+// 等待时间应略小于 want
+func assertRetry(t *testing.T, l *Limiter, key string, want time.Duration, wantLocked bool) {
+	t.Helper()
+	retryAfter, locked := l.Check(key)
+	if retryAfter <= want-time.Second || retryAfter > want {
+		t.Errorf("等待时间 = %v, 期望约 %v", retryAfter, want)
+	}
+	if locked != wantLocked {
+		t.Errorf("locked = %v, 期望 %v", locked, wantLocked)
+	}
+}
+
+// 解除键的限制, 模拟延迟已过去
+func unblock(l *Limiter, key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[key].blockedUntil = time.Time{}
+}
+
+func TestDelayGrowth(t *testing.T) {
+	l := New(testOpts)
+	for range testOpts.FreeAttempts {
+		fail(t, l, "k")
+	}
+
+	// 每次失败延迟翻倍, 不超过上限
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 4 * time.Minute, 4 * time.Minute} {
+		fail(t, l, "k")
+		assertRetry(t, l, "k", want, false)
+		unblock(l, "k")
+	}
+}
+
+func TestLockout(t *testing.T) {
+	l := New(testOpts)
+	for range testOpts.LockoutThreshold - 1 {
+		fail(t, l, "k")
+		unblock(l, "k")
+	}
+	fail(t, l, "k")
+	assertRetry(t, l, "k", testOpts.LockoutDuration, true)
+
+	// 其他键不受影响
+	if retryAfter, _ := l.Check("other"); retryAfter != 0 {
+		t.Errorf("其他键等待 %v, 期望 0", retryAfter)
+	}
+}
+
+func TestSuccessResets(t *testing.T) {
+	l := New(testOpts)
+	for range testOpts.FreeAttempts + 1 {
+		fail(t, l, "k")
+	}
+	unblock(l, "k")
+
+	if retryAfter, _ := l.Check("k"); retryAfter != 0 {
+		t.Fatalf("等待 %v, 期望允许尝试", retryAfter)
+	}
+	l.Success("k")
+	l.Release("k")
+	if len(l.entries) != 0 {
+		t.Errorf("成功后仍有 %d 条记录", len(l.entries))
+	}
+
+	// 重新获得无延迟的次数
+	for range testOpts.FreeAttempts {
+		fail(t, l, "k")
+	}
+	if retryAfter, _ := l.Check("k"); retryAfter != 0 {
+		t.Errorf("等待 %v, 期望允许尝试", retryAfter)
+	}
+}
+
+func TestConcurrentAttemptsReserved(t *testing.T) {
+	l := New(testOpts)
+
+	// 并发检查时进行中的尝试按失败计算, 只有无延迟的次数能通过
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if retryAfter, _ := l.Check("k"); retryAfter == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != testOpts.FreeAttempts {
+		t.Fatalf("通过检查 %d 次, 期望 %d", allowed, testOpts.FreeAttempts)
+	}
+
+	// 释放后可以继续尝试
+	for range allowed {
+		l.Release("k")
+	}
+	if retryAfter, _ := l.Check("k"); retryAfter != 0 {
+		t.Errorf("释放后等待 %v, 期望允许尝试", retryAfter)
+	}
+	l.Release("k")
+	if len(l.entries) != 0 {
+		t.Errorf("释放后仍有 %d 条记录", len(l.entries))
+	}
+}
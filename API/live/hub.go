@@ -0,0 +1,116 @@
+package live
+
+import (
+	"sync"
+)
+
+const (
+	historySize = 1024 // 保留最近的事件数量, 用于断线重连后补发
+	bufferSize  = 64   // 每个订阅者的缓冲区大小, 缓冲区满时断开该订阅者
+)
+
+// Event 推送给订阅者的事件
+type Event struct {
+	Id       uint64 // 递增的事件ID(服务器重启后从头开始)
+	DeviceId string // 相关设备
+	Type     string // 事件类型
+	Data     []byte // 事件内容(JSON)
+}
+
+// Subscription 一个订阅者, Events 关闭表示订阅已结束(取消订阅或处理过慢被断开)
+type Subscription struct {
+	Events  <-chan Event
+	events  chan Event
+	devices map[string]bool
+}
+
+// Hub 事件分发中心, 按设备向订阅者推送事件
+type Hub struct {
+	mu      sync.Mutex
+	seq     uint64
+	history []Event // 环形缓冲区
+	subs    map[*Subscription]struct{}
+}
+
+// NewHub 创建事件分发中心
+func NewHub() *Hub {
+	return &Hub{
+		history: make([]Event, 0, historySize),
+		subs:    make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish 发布事件, 不会阻塞; 缓冲区已满的订阅者会被断开, 由客户端重连后补发
+func (h *Hub) Publish(deviceId, kind string, data []byte) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	event := Event{Id: h.seq, DeviceId: deviceId, Type: kind, Data: data}
+	if len(h.history) < historySize {
+		h.history = append(h.history, event)
+	} else {
+		h.history[int((event.Id-1)%historySize)] = event
+	}
+
+	for sub := range h.subs {
+		if !sub.devices[deviceId] {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			h.remove(sub)
+		}
+	}
+	return event
+}
+
+// Subscribe 订阅设备的事件. lastId 为客户端收到的最后一个事件ID(0 表示新连接):
+// 若其后的事件仍在缓冲区中, 通过 missed 返回并且 resumed 为 true, 否则客户端应重新获取完整状态.
+// current 为订阅时最新的事件ID
+func (h *Hub) Subscribe(deviceIds []string, lastId uint64) (sub *Subscription, missed []Event, resumed bool, current uint64) {
+	events := make(chan Event, bufferSize)
+	sub = &Subscription{Events: events, events: events, devices: make(map[string]bool, len(deviceIds))}
+	for _, deviceId := range deviceIds {
+		sub.devices[deviceId] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	oldest := h.seq - uint64(len(h.history)) + 1
+	if lastId > 0 && lastId <= h.seq && lastId+1 >= oldest {
+		resumed = true
+		for id := lastId + 1; id <= h.seq; id++ {
+			event := h.history[int((id-1)%historySize)]
+			if sub.devices[event.DeviceId] {
+				missed = append(missed, event)
+			}
+		}
+	}
+	h.subs[sub] = struct{}{}
+	return sub, missed, resumed, h.seq
+}
+
+// Unsubscribe 取消订阅, 可重复调用
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(sub)
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.events)
+	}
+}
+
+// Default 进程内共享的事件分发中心
+var Default = NewHub()
+
+// Publish 向 Default 发布事件
+func Publish(deviceId, kind string, data []byte) Event {
+	return Default.Publish(deviceId, kind, data)
+}
@@ -0,0 +1,93 @@
+package live
+
+import (
+	"testing"
+)
+
+func receive(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case event, ok := <-sub.Events:
+		if !ok {
+			t.Fatal("订阅已关闭")
+		}
+		return event
+	default:
+		t.Fatal("没有收到事件")
+	}
+	return Event{}
+}
+
+func TestPublishFiltersDevices(t *testing.T) {
+	hub := NewHub()
+	sub, _, _, _ := hub.Subscribe([]string{"a"}, 0)
+	defer hub.Unsubscribe(sub)
+
+	hub.Publish("b", "status", nil)
+	published := hub.Publish("a", "status", []byte(`{}`))
+
+	event := receive(t, sub)
+	if event.Id != published.Id || event.DeviceId != "a" || event.Id != 2 {
+		t.Errorf("收到的事件 = %+v", event)
+	}
+	select {
+	case event := <-sub.Events:
+		t.Errorf("不应收到其他设备的事件: %+v", event)
+	default:
+	}
+}
+
+func TestSubscribeResume(t *testing.T) {
+	hub := NewHub()
+	for _, deviceId := range []string{"a", "b", "a", "a"} {
+		hub.Publish(deviceId, "status", nil)
+	}
+
+	_, missed, resumed, current := hub.Subscribe([]string{"a"}, 1)
+	if !resumed || current != 4 {
+		t.Fatalf("resumed = %v, current = %d", resumed, current)
+	}
+	if len(missed) != 2 || missed[0].Id != 3 || missed[1].Id != 4 {
+		t.Errorf("补发的事件 = %+v", missed)
+	}
+
+	// 新连接, 或 ID 不在缓冲区内(如服务器重启后)时无法补发
+	for _, lastId := range []uint64{0, 99} {
+		if _, _, resumed, _ := hub.Subscribe([]string{"a"}, lastId); resumed {
+			t.Errorf("lastId = %d 时不应补发", lastId)
+		}
+	}
+}
+
+func TestSubscribeResumeAfterWrap(t *testing.T) {
+	hub := NewHub()
+	for range historySize + 10 {
+		hub.Publish("a", "status", nil)
+	}
+
+	if _, _, resumed, _ := hub.Subscribe([]string{"a"}, 5); resumed {
+		t.Error("已被覆盖的事件不应补发")
+	}
+	_, missed, resumed, _ := hub.Subscribe([]string{"a"}, historySize+5)
+	if !resumed || len(missed) != 5 || missed[0].Id != historySize+6 {
+		t.Errorf("resumed = %v, missed = %d", resumed, len(missed))
+	}
+}
+
+func TestSlowSubscriberDropped(t *testing.T) {
+	hub := NewHub()
+	sub, _, _, _ := hub.Subscribe([]string{"a"}, 0)
+	for range bufferSize + 1 {
+		hub.Publish("a", "status", nil)
+	}
+
+	count := 0
+	for range sub.Events {
+		count++
+	}
+	if count != bufferSize {
+		t.Errorf("收到 %d 个事件, 期望 %d", count, bufferSize)
+	}
+	// 已断开的订阅可以重复取消
+	hub.Unsubscribe(sub)
+}
@@ -1,15 +1,27 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
+	"os"
 	"runtime"
 	"runtime/debug"
+	"sloth-tracker/api/config"
+	"sloth-tracker/api/controller"
+	"sloth-tracker/api/events"
+	"sloth-tracker/api/presence"
+	"sloth-tracker/api/rollup"
 	"sloth-tracker/api/router"
 	"sloth-tracker/api/storage"
+	"sloth-tracker/api/webhooks"
 )
 
 func main() {
+	createAdmin := flag.String("create-admin", "", "创建管理员账户后退出(用户名已存在时提升为管理员)")
+	adminPassword := flag.String("password", "", "新建管理员的密码(为空时读取环境变量 SLOTH_ADMIN_PASSWORD)")
+	flag.Parse()
+
 	// 限制CPU使用(1核)
 	CPUCore := 1
 	runtime.GOMAXPROCS(CPUCore)
@@ -18,8 +30,60 @@ func main() {
 	debug.SetMemoryLimit(MemoryLimit)
 	// 初始化数据库
 	db := storage.InitDB()
+
+	// 创建初始管理员
+	if *createAdmin != "" {
+		password := *adminPassword
+		if password == "" {
+			password = os.Getenv("SLOTH_ADMIN_PASSWORD")
+		}
+		created, err := storage.CreateAdmin(db, *createAdmin, password)
+		if err != nil {
+			log.Fatal("❌ 创建管理员失败: ", err)
+		}
+		if created {
+			log.Printf("👑 已创建管理员: %s", *createAdmin)
+		} else {
+			log.Printf("👑 已将 %s 提升为管理员", *createAdmin)
+		}
+		return
+	}
+
+	// 启动历史数据聚合任务
+	cfg := config.Get()
+	rollup.Start(db, rollup.Options{
+		RawRetention:    cfg.HistoryRawRetention,
+		MinuteRetention: cfg.HistoryMinuteRetention,
+		HourRetention:   cfg.HistoryHourRetention,
+		DayRetention:    cfg.HistoryDayRetention,
+		Interval:        cfg.HistoryCompactInterval,
+		MaxGap:          cfg.HistoryMaxGap,
+	})
+
+	// 启动设备在线状态检测
+	presence.Start(db, presence.Options{
+		HeartbeatTimeout: cfg.HeartbeatTimeout,
+		OfflineTimeout:   cfg.OfflineTimeout,
+		CheckInterval:    cfg.PresenceCheckInterval,
+		Retention:        cfg.PresenceRetention,
+	})
+
+	// 注册事件订阅者
+	controller.SubscribeEvents(events.Default)
+
+	// 启动 Webhook 投递
+	dispatcher := webhooks.NewDispatcher(db, webhooks.Options{
+		MaxAttempts:  cfg.WebhookMaxAttempts,
+		BaseDelay:    cfg.WebhookRetryDelay,
+		Timeout:      cfg.WebhookTimeout,
+		DisableAfter: cfg.WebhookDisableAfter,
+		AllowPrivate: cfg.WebhookAllowPrivate,
+		Retention:    cfg.WebhookRetention,
+	})
+	dispatcher.Start(events.Default)
+
 	// 获取路由处理器
-	handler := router.SetupRouter(db)
+	handler := router.SetupRouter(db, dispatcher)
 	Port := "8080"
 	log.Printf("🚀 服务器启动在 http://localhost:%s", Port)
 	log.Printf("💾 内存限制: %dMB", MemoryLimit/(1024*1024))
@@ -0,0 +1,298 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sloth-tracker/api/model"
+	"sort"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 指标类型
+const (
+	Gauge   = "gauge"   // 瞬时数值(如 CPU 负载)
+	Counter = "counter" // 累计数值(如开机以来的网络流量)
+	Enum    = "enum"    // 有限取值的状态(如 performance, balanced)
+	String  = "string"  // 任意文本
+)
+
+const (
+	MaxPerDevice = 100 // 每台设备最多注册的指标数量
+	maxNameLen   = 64
+	maxUnitLen   = 16
+	maxTextLen   = 256
+)
+
+var (
+	ErrInvalid      = errors.New("指标格式不正确")
+	ErrTypeMismatch = errors.New("指标类型与已注册的类型不一致")
+	ErrTooMany      = fmt.Errorf("每台设备最多注册 %d 个指标", MaxPerDevice)
+)
+
+// 指标名称: 小写字母开头, 可包含小写字母, 数字, 下划线与点
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_.]*$`)
+
+// ValidType 是否为支持的指标类型
+func ValidType(kind string) bool {
+	switch kind {
+	case Gauge, Counter, Enum, String:
+		return true
+	}
+	return false
+}
+
+// Numeric 该类型的指标是否为数值
+func Numeric(kind string) bool {
+	return kind == Gauge || kind == Counter
+}
+
+// ValidateName 校验指标名称
+func ValidateName(name string) error {
+	if len(name) == 0 || len(name) > maxNameLen || !namePattern.MatchString(name) {
+		return fmt.Errorf("%w: 名称 %q 无效", ErrInvalid, name)
+	}
+	return nil
+}
+
+// ValidateUnit 校验单位
+func ValidateUnit(unit string) error {
+	if len(unit) > maxUnitLen {
+		return fmt.Errorf("%w: 单位过长", ErrInvalid)
+	}
+	return nil
+}
+
+// Report 一次上报中的指标值
+type Report struct {
+	Name   string
+	Type   string // 可省略, 省略时使用已注册的类型或按值推断
+	Unit   string // 可省略
+	Number *float64
+	Text   *string
+}
+
+// Parse 解析上报中的 metrics 对象. 值可以直接给出, 也可以带上类型与单位:
+//
+//	{"cpu.load": 0.42, "disk.free": {"value": 120, "type": "gauge", "unit": "GB"}}
+func Parse(raw json.RawMessage) ([]Report, error) {
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+
+	var items map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, fmt.Errorf("%w: metrics 应为对象", ErrInvalid)
+	}
+	if len(items) > MaxPerDevice {
+		return nil, ErrTooMany
+	}
+
+	reports := make([]Report, 0, len(items))
+	for name, item := range items {
+		if err := ValidateName(name); err != nil {
+			return nil, err
+		}
+		report := Report{Name: name}
+
+		var detail struct {
+			Value json.RawMessage `json:"value"`
+			Type  string          `json:"type"`
+			Unit  string          `json:"unit"`
+		}
+		value := item
+		if bytes.HasPrefix(bytes.TrimSpace(item), []byte("{")) {
+			if err := json.Unmarshal(item, &detail); err != nil {
+				return nil, fmt.Errorf("%w: %s 格式不正确", ErrInvalid, name)
+			}
+			if detail.Type != "" && !ValidType(detail.Type) {
+				return nil, fmt.Errorf("%w: %s 的类型 %q 不支持", ErrInvalid, name, detail.Type)
+			}
+			if err := ValidateUnit(detail.Unit); err != nil {
+				return nil, err
+			}
+			value, report.Type, report.Unit = detail.Value, detail.Type, detail.Unit
+		}
+
+		var number float64
+		var text string
+		switch {
+		case len(value) == 0 || bytes.Equal(bytes.TrimSpace(value), []byte("null")):
+			return nil, fmt.Errorf("%w: %s 缺少值", ErrInvalid, name)
+		case json.Unmarshal(value, &number) == nil:
+			report.Number = &number
+		case json.Unmarshal(value, &text) == nil:
+			if len(text) > maxTextLen {
+				return nil, fmt.Errorf("%w: %s 的值过长", ErrInvalid, name)
+			}
+			report.Text = &text
+		default:
+			return nil, fmt.Errorf("%w: %s 的值应为数值或字符串", ErrInvalid, name)
+		}
+		if report.Type != "" && Numeric(report.Type) != (report.Number != nil) {
+			return nil, fmt.Errorf("%w: %s 的值与类型 %s 不符", ErrInvalid, name, report.Type)
+		}
+		reports = append(reports, report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Name < reports[j].Name })
+	return reports, nil
+}
+
+// Record 记录一次上报的指标: 未注册的指标自动注册(类型按值推断为 gauge 或 string),
+// 写入历史, 并在上报时间不早于当前最新值时更新最新值
+func Record(db *gorm.DB, deviceId string, reports []Report, timestamp int64) error {
+	if len(reports) == 0 {
+		return nil
+	}
+
+	var definitions []model.MetricDefinition
+	if err := db.Where("device_id = ?", deviceId).Find(&definitions).Error; err != nil {
+		return err
+	}
+	registered := make(map[string]*model.MetricDefinition, len(definitions))
+	for i := range definitions {
+		registered[definitions[i].Name] = &definitions[i]
+	}
+
+	for _, report := range reports {
+		definition, ok := registered[report.Name]
+		if !ok {
+			if len(registered) >= MaxPerDevice {
+				return ErrTooMany
+			}
+			definition = &model.MetricDefinition{
+				Id:       uuid.New().String(),
+				DeviceId: deviceId,
+				Name:     report.Name,
+				Type:     report.Type,
+				Unit:     report.Unit,
+			}
+			if definition.Type == "" {
+				definition.Type = String
+				if report.Number != nil {
+					definition.Type = Gauge
+				}
+			}
+			if err := db.Create(definition).Error; err != nil {
+				return err
+			}
+			registered[report.Name] = definition
+		}
+
+		if (report.Type != "" && report.Type != definition.Type) || Numeric(definition.Type) != (report.Number != nil) {
+			return fmt.Errorf("%w: %s 已注册为 %s", ErrTypeMismatch, report.Name, definition.Type)
+		}
+		if report.Unit != "" && report.Unit != definition.Unit {
+			if err := db.Model(definition).Update("unit", report.Unit).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := db.Create(&model.MetricSample{
+			Id:        uuid.New().String(),
+			DeviceId:  deviceId,
+			Name:      report.Name,
+			Number:    report.Number,
+			Text:      report.Text,
+			Timestamp: timestamp,
+		}).Error; err != nil {
+			return err
+		}
+
+		var latest model.MetricValue
+		err := db.Where("device_id = ? AND name = ?", deviceId, report.Name).First(&latest).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			err = db.Create(&model.MetricValue{
+				Id:        uuid.New().String(),
+				DeviceId:  deviceId,
+				Name:      report.Name,
+				Number:    report.Number,
+				Text:      report.Text,
+				Timestamp: timestamp,
+			}).Error
+		case err == nil && timestamp >= latest.Timestamp:
+			err = db.Model(&latest).Updates(map[string]any{
+				"number":    report.Number,
+				"text":      report.Text,
+				"timestamp": timestamp,
+			}).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Metric 指标定义及其最新值
+type Metric struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Unit        string `json:"unit"`
+	Description string `json:"description"`
+	Value       any    `json:"value"`     // 最新值, 尚未上报时为 null
+	Timestamp   *int64 `json:"timestamp"` // 最新值的上报时间(毫秒)
+}
+
+// Latest 返回设备注册的所有指标及最新值, 按名称排序
+func Latest(db *gorm.DB, deviceId string) ([]Metric, error) {
+	var definitions []model.MetricDefinition
+	if err := db.Where("device_id = ?", deviceId).Order("name ASC").Find(&definitions).Error; err != nil {
+		return nil, err
+	}
+	var values []model.MetricValue
+	if err := db.Where("device_id = ?", deviceId).Find(&values).Error; err != nil {
+		return nil, err
+	}
+	latest := make(map[string]model.MetricValue, len(values))
+	for _, value := range values {
+		latest[value.Name] = value
+	}
+
+	result := make([]Metric, 0, len(definitions))
+	for _, definition := range definitions {
+		metric := Metric{
+			Name:        definition.Name,
+			Type:        definition.Type,
+			Unit:        definition.Unit,
+			Description: definition.Description,
+		}
+		if value, ok := latest[definition.Name]; ok {
+			metric.Value = Value(value.Number, value.Text)
+			metric.Timestamp = &value.Timestamp
+		}
+		result = append(result, metric)
+	}
+	return result, nil
+}
+
+// Value 将存储的数值或文本转换为响应中的值
+func Value(number *float64, text *string) any {
+	if number != nil {
+		return *number
+	}
+	if text != nil {
+		return *text
+	}
+	return nil
+}
+
+// Delete 删除指定设备的指标定义, 最新值与历史; names 为空时删除全部
+func Delete(db *gorm.DB, deviceIds []string, names ...string) error {
+	for _, table := range []any{&model.MetricSample{}, &model.MetricValue{}, &model.MetricDefinition{}} {
+		query := db.Where("device_id IN ?", deviceIds)
+		if len(names) > 0 {
+			query = query.Where("name IN ?", names)
+		}
+		if err := query.Delete(table).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
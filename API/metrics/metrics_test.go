@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"sloth-tracker/api/internal/testdb"
+	"sloth-tracker/api/model"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func setupDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return testdb.Open(t, &model.MetricDefinition{}, &model.MetricValue{}, &model.MetricSample{})
+}
+
+func parse(t *testing.T, raw string) []Report {
+	t.Helper()
+	reports, err := Parse(json.RawMessage(raw))
+	if err != nil {
+		t.Fatalf("解析 %s 失败: %v", raw, err)
+	}
+	return reports
+}
+
+func TestParse(t *testing.T) {
+	reports := parse(t, `{"cpu.load": 0.5, "power.profile": {"value": "balanced", "type": "enum"}, "disk.free": {"value": 120, "unit": "GB"}}`)
+	if len(reports) != 3 {
+		t.Fatalf("指标数 = %d, 期望 3", len(reports))
+	}
+	// 按名称排序
+	if reports[0].Name != "cpu.load" || reports[0].Number == nil || *reports[0].Number != 0.5 {
+		t.Errorf("cpu.load = %+v", reports[0])
+	}
+	if reports[1].Name != "disk.free" || reports[1].Unit != "GB" || *reports[1].Number != 120 {
+		t.Errorf("disk.free = %+v", reports[1])
+	}
+	if reports[2].Type != Enum || reports[2].Text == nil || *reports[2].Text != "balanced" {
+		t.Errorf("power.profile = %+v", reports[2])
+	}
+
+	if reports := parse(t, `null`); len(reports) != 0 {
+		t.Errorf("null 应解析为空: %+v", reports)
+	}
+
+	for _, raw := range []string{
+		`[1, 2]`,
+		`{"CPU": 1}`,
+		`{"cpu.load": null}`,
+		`{"cpu.load": true}`,
+		`{"cpu.load": {"value": 1, "type": "histogram"}}`,
+		`{"cpu.load": {"value": "high", "type": "gauge"}}`,
+	} {
+		if _, err := Parse(json.RawMessage(raw)); !errors.Is(err, ErrInvalid) {
+			t.Errorf("解析 %s 应返回 ErrInvalid, 实际为 %v", raw, err)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	db := setupDB(t)
+	if err := Record(db, "device", parse(t, `{"cpu.load": 0.5, "power.profile": {"value": "balanced", "type": "enum"}}`), 1000); err != nil {
+		t.Fatalf("记录指标失败: %v", err)
+	}
+	// 补传的旧数据只写入历史, 不覆盖最新值
+	if err := Record(db, "device", parse(t, `{"cpu.load": 0.9}`), 500); err != nil {
+		t.Fatalf("记录指标失败: %v", err)
+	}
+	if err := Record(db, "device", parse(t, `{"cpu.load": {"value": 0.7, "unit": "%"}}`), 2000); err != nil {
+		t.Fatalf("记录指标失败: %v", err)
+	}
+
+	latest, err := Latest(db, "device")
+	if err != nil {
+		t.Fatalf("查询最新值失败: %v", err)
+	}
+	if len(latest) != 2 {
+		t.Fatalf("指标数 = %d, 期望 2", len(latest))
+	}
+	if latest[0].Name != "cpu.load" || latest[0].Type != Gauge || latest[0].Unit != "%" || latest[0].Value != 0.7 || *latest[0].Timestamp != 2000 {
+		t.Errorf("cpu.load = %+v", latest[0])
+	}
+	if latest[1].Type != Enum || latest[1].Value != "balanced" {
+		t.Errorf("power.profile = %+v", latest[1])
+	}
+
+	var samples int64
+	db.Model(&model.MetricSample{}).Where("name = ?", "cpu.load").Count(&samples)
+	if samples != 3 {
+		t.Errorf("历史记录数 = %d, 期望 3", samples)
+	}
+
+	// 类型与注册的不一致
+	for _, raw := range []string{`{"cpu.load": "high"}`, `{"cpu.load": {"value": 1, "type": "counter"}}`} {
+		if err := Record(db, "device", parse(t, raw), 3000); !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("记录 %s 应返回 ErrTypeMismatch, 实际为 %v", raw, err)
+		}
+	}
+
+	if err := Delete(db, []string{"device"}, "cpu.load"); err != nil {
+		t.Fatalf("删除指标失败: %v", err)
+	}
+	if latest, _ := Latest(db, "device"); len(latest) != 1 || latest[0].Name != "power.profile" {
+		t.Errorf("删除后的指标 = %+v", latest)
+	}
+}
+
+func TestRecordLimit(t *testing.T) {
+	db := setupDB(t)
+	for i := range MaxPerDevice {
+		db.Create(&model.MetricDefinition{Id: string(rune(0x4e00 + i)), DeviceId: "device", Name: "m" + string(rune('a'+i%26)) + string(rune('a'+i/26)), Type: Gauge})
+	}
+	if err := Record(db, "device", parse(t, `{"extra": 1}`), 1000); !errors.Is(err, ErrTooMany) {
+		t.Errorf("超过上限应返回 ErrTooMany, 实际为 %v", err)
+	}
+}
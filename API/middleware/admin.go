@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/utils"
+
+	"gorm.io/gorm"
+)
+
+// Admin 管理员鉴权中间件, 需在 Auth 之后使用
+func Admin(db any) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gormDB := db.(*gorm.DB)
+
+			var user model.User
+			if err := gormDB.Select("id", "is_admin", "disabled").First(&user, "id = ?", auth.UserId(r)).Error; err != nil {
+				utils.Error(w, http.StatusUnauthorized, "用户不存在")
+				return
+			}
+			if !user.IsAdmin || user.Disabled {
+				utils.Error(w, http.StatusForbidden, "需要管理员权限")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
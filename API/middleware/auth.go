@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/config"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/utils"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 会话最近使用时间的刷新间隔, 避免每个请求都写库
+const sessionTouchInterval = time.Minute
+
+// Auth 鉴权中间件, 校验 Authorization: Bearer 令牌及其会话并将用户写入上下文
+func Auth(db any) func(http.Handler) http.Handler {
+	return authenticate(db, false)
+}
+
+// StreamAuth 事件流的鉴权中间件, 浏览器的 EventSource 无法设置请求头,
+// 除 Authorization 请求头外还允许通过 access_token 查询参数传递令牌; 仅用于事件流接口
+func StreamAuth(db any) func(http.Handler) http.Handler {
+	return authenticate(db, true)
+}
+
+// 校验访问令牌及其会话, queryToken 为 true 时接受 access_token 查询参数
+func authenticate(db any, queryToken bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			token, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok && queryToken {
+				token, ok = r.URL.Query().Get("access_token"), true
+			}
+			if !ok || token == "" {
+				utils.Error(w, http.StatusUnauthorized, "未登录")
+				return
+			}
+
+			claims, err := auth.ParseToken(config.Get().TokenSecret, token)
+			if err != nil {
+				if err == auth.ErrExpiredToken {
+					utils.Error(w, http.StatusUnauthorized, "登录已过期")
+				} else {
+					utils.Error(w, http.StatusUnauthorized, "令牌无效")
+				}
+				return
+			}
+
+			gormDB := db.(*gorm.DB)
+
+			// 检查会话是否有效
+			var session model.Session
+			if err := gormDB.Where("id = ? AND user_id = ?", claims.SessionId, claims.Subject).First(&session).Error; err != nil {
+				utils.Error(w, http.StatusUnauthorized, "会话不存在")
+				return
+			}
+			now := time.Now()
+			if session.RevokedAt != nil || now.After(session.ExpiresAt) {
+				utils.Error(w, http.StatusUnauthorized, "会话已失效")
+				return
+			}
+
+			// 更新会话最近使用时间
+			if now.Sub(session.LastUsedAt) > sessionTouchInterval {
+				gormDB.Model(&session).Updates(map[string]any{
+					"last_used_at": now,
+					"ip":           utils.ClientIP(r),
+				})
+			}
+
+			ctx := auth.WithUserId(r.Context(), claims.Subject)
+			ctx = auth.WithSessionId(ctx, claims.SessionId)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
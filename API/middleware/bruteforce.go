@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/config"
+	"sloth-tracker/api/limiter"
+	"sloth-tracker/api/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 读取请求体用于提取用户名的上限
+const maxPeekBody = 1 << 20
+
+// BruteForce 密码校验限流中间件, 按IP与账户(已登录用户ID或请求体中的用户名)限制失败次数
+func BruteForce(guard *limiter.Guard) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := utils.ClientIP(r)
+			account := accountKey(r)
+
+			if retryAfter, locked := guard.Check(ip, account); retryAfter > 0 {
+				if locked {
+					tooManyAttempts(w, retryAfter, "尝试次数过多, 账户已临时锁定")
+				} else {
+					tooManyAttempts(w, retryAfter, "尝试过于频繁, 请稍后再试")
+				}
+				return
+			}
+
+			ctx := guard.Begin(r.Context(), ip, account)
+			defer limiter.End(ctx)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// 获取限流的账户键(已登录用户, 两步验证凭据或用户名), 读取请求体后会将其还原
+func accountKey(r *http.Request) string {
+	if userId := auth.UserId(r); userId != "" {
+		return "user:" + userId
+	}
+	if r.Body == nil {
+		return ""
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPeekBody))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var req struct {
+		Name      string `json:"name"`
+		Challenge string `json:"challenge"` // 两步验证登录凭据
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	if req.Challenge != "" {
+		if userId, err := auth.ParseChallenge(config.Get().TokenSecret, req.Challenge); err == nil {
+			return "user:" + userId
+		}
+	}
+	if req.Name == "" {
+		return ""
+	}
+	return "name:" + strings.ToLower(req.Name)
+}
+
+// 返回429及Retry-After
+func tooManyAttempts(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	utils.JSONResponse(w, http.StatusTooManyRequests, map[string]any{
+		"success":     false,
+		"error":       message,
+		"retry_after": seconds,
+	})
+}
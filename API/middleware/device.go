@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"sloth-tracker/api/auth"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/presence"
+	"sloth-tracker/api/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeviceAuth 设备鉴权中间件, 校验 device_id 与 X-Device-Secret 上报凭证
+func DeviceAuth(db any) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deviceId := utils.GetQueryParam(r, "device_id")
+			secret := r.Header.Get("X-Device-Secret")
+			if deviceId == "" || secret == "" {
+				utils.Error(w, http.StatusUnauthorized, "缺少设备凭证")
+				return
+			}
+
+			gormDB := db.(*gorm.DB)
+
+			var device model.Device
+			if err := gormDB.Where("id = ?", deviceId).First(&device).Error; err != nil {
+				utils.Error(w, http.StatusUnauthorized, "设备凭证无效")
+				return
+			}
+
+			if !auth.VerifySecret(secret, device.SecretHash) {
+				utils.Error(w, http.StatusUnauthorized, "设备凭证无效")
+				return
+			}
+
+			// 记录最近上报时间
+			if err := presence.Touch(gormDB, &device, time.Now()); err != nil {
+				log.Printf("更新设备在线状态失败: %s: %v", device.Id, err)
+			}
+
+			ctx := auth.WithDeviceId(r.Context(), device.Id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
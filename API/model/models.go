@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -8,9 +9,53 @@ type User struct {
 	Id           string    `gorm:"primaryKey;column:id" json:"id"` // 用户ID
 	Name         string    `json:"name"`                           // 用户名
 	Password     string    `json:"password"`                       // 密码
+	TotpSecret   string    `json:"-"`                              // TOTP 密钥(启用前为待确认密钥)
+	TotpEnabled  bool      `json:"totp_enabled"`                   // 是否启用两步验证
+	TotpLastStep int64     `json:"-"`                              // 最近一次使用的 TOTP 时间步(防重放)
+	IsAdmin      bool      `json:"is_admin"`                       // 是否为管理员
+	Disabled     bool      `json:"disabled"`                       // 是否已被管理员禁用
+	Timezone     string    `json:"timezone"`                       // 时区(IANA 名称, 为空表示 UTC), 用于按天统计
 	RegisteredAt time.Time `json:"registered_at"`                  // 注册时间
 }
 
+type ExternalIdentity struct {
+	Id        string    `gorm:"primaryKey;column:id" json:"id"`                // 唯一标识
+	UserId    string    `gorm:"index" json:"user_id"`                          // 绑定的用户ID
+	Issuer    string    `gorm:"uniqueIndex:idx_issuer_subject" json:"issuer"`  // 身份提供方
+	Subject   string    `gorm:"uniqueIndex:idx_issuer_subject" json:"subject"` // 身份提供方中的用户标识(sub)
+	Email     string    `json:"email"`                                         // 邮箱(来自身份提供方, 仅供展示)
+	CreatedAt time.Time `json:"created_at"`                                    // 绑定时间
+}
+
+type RecoveryCode struct {
+	Id        string     `gorm:"primaryKey;column:id" json:"id"` // 唯一标识
+	UserId    string     `gorm:"index" json:"user_id"`           // 所属用户ID
+	CodeHash  string     `json:"-"`                              // 恢复码哈希
+	UsedAt    *time.Time `json:"used_at,omitempty"`              // 使用时间(为空表示未使用)
+	CreatedAt time.Time  `json:"created_at"`                     // 创建时间
+}
+
+type AuditLog struct {
+	Id        string    `gorm:"primaryKey;column:id" json:"id"` // 唯一标识
+	UserId    string    `gorm:"index" json:"user_id"`           // 相关用户ID
+	Action    string    `json:"action"`                         // 操作类型
+	Ip        string    `json:"ip"`                             // 请求来源IP
+	Detail    string    `json:"detail"`                         // 补充说明
+	CreatedAt time.Time `json:"created_at"`                     // 发生时间
+}
+
+type Session struct {
+	Id          string     `gorm:"primaryKey;column:id" json:"id"` // 会话ID
+	UserId      string     `gorm:"index" json:"user_id"`           // 所属用户ID
+	RefreshHash string     `json:"-"`                              // 刷新令牌哈希
+	Device      string     `json:"device"`                         // 登录设备(客户端名称或User-Agent)
+	Ip          string     `json:"ip"`                             // 最近使用的IP
+	CreatedAt   time.Time  `json:"created_at"`                     // 创建时间
+	LastUsedAt  time.Time  `json:"last_used_at"`                   // 最近使用时间
+	ExpiresAt   time.Time  `json:"expires_at"`                     // 过期时间
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`           // 吊销时间(为空表示有效)
+}
+
 type SharedDevice struct {
 	Id            string    `gorm:"primaryKey;column:id" json:"id"` // 唯一标识
 	DeviceId      string    `json:"device_id"`                      // 被访问的设备
@@ -20,12 +65,106 @@ type SharedDevice struct {
 }
 
 type Device struct {
-	Id           string    `gorm:"primaryKey;column:id" json:"id"` // 设备ID
-	OwnerId      string    `json:"owner_id"`                       // 所属用户ID
-	Name         string    `json:"name"`                           // 设备名称
-	Platform     string    `json:"platform"`                       // 设备平台(如: Android, iOS)
-	Description  string    `json:"description"`                    // 设备描述
-	RegisteredAt time.Time `json:"registered_at"`                  // 注册时间
+	Id              string     `gorm:"primaryKey;column:id" json:"id"` // 设备ID
+	OwnerId         string     `json:"owner_id"`                       // 所属用户ID
+	Name            string     `json:"name"`                           // 设备名称
+	Platform        string     `json:"platform"`                       // 设备平台(如: Android, iOS)
+	Description     string     `json:"description"`                    // 设备描述
+	SecretHash      string     `json:"-"`                              // 上报凭证哈希(为空表示已吊销)
+	SecretUpdatedAt time.Time  `json:"secret_updated_at"`              // 上报凭证更新时间
+	LastSeenAt      *time.Time `json:"last_seen_at"`                   // 最近一次通过凭证访问的时间(为空表示从未上报)
+	Presence        string     `json:"-"`                              // 最近一次记录的在线状态, 用于检测状态变化
+	RegisteredAt    time.Time  `json:"registered_at"`                  // 注册时间
+}
+
+type PresenceEvent struct {
+	Id        string    `gorm:"primaryKey;column:id" json:"id"`                    // 唯一标识
+	DeviceId  string    `gorm:"index:idx_presence_device" json:"device_id"`        // 设备ID
+	From      string    `json:"from"`                                              // 变化前状态
+	To        string    `json:"to"`                                                // 变化后状态
+	CreatedAt time.Time `gorm:"index:idx_presence_device;index" json:"created_at"` // 变化时间
+}
+
+type AppSession struct {
+	Id        string `gorm:"primaryKey;column:id" json:"id"`                 // 唯一标识
+	DeviceId  string `gorm:"index:idx_app_session_device" json:"device_id"`  // 设备ID
+	AppName   string `json:"app_name"`                                       // 前台应用
+	AppTitle  string `json:"app_title"`                                      // 窗口标题
+	StartedAt int64  `gorm:"index:idx_app_session_device" json:"started_at"` // 开始时间(毫秒)
+	EndedAt   int64  `json:"ended_at"`                                       // 最近一次上报时间(毫秒)
+	Closed    bool   `json:"closed"`                                         // 是否已结束(切换应用, 熄屏或中断后不再延续)
+}
+
+type MetricDefinition struct {
+	Id          string    `gorm:"primaryKey;column:id" json:"id"`               // 唯一标识
+	DeviceId    string    `gorm:"uniqueIndex:idx_metric_name" json:"device_id"` // 设备ID
+	Name        string    `gorm:"uniqueIndex:idx_metric_name" json:"name"`      // 指标名称(如 cpu.load)
+	Type        string    `json:"type"`                                         // 指标类型(gauge, counter, enum, string)
+	Unit        string    `json:"unit"`                                         // 单位(可选, 如 %, GB)
+	Description string    `json:"description"`                                  // 描述(可选)
+	CreatedAt   time.Time `json:"created_at"`                                   // 注册时间
+}
+
+type MetricValue struct {
+	Id        string   `gorm:"primaryKey;column:id" json:"-"`            // 唯一标识
+	DeviceId  string   `gorm:"uniqueIndex:idx_metric_value" json:"-"`    // 设备ID
+	Name      string   `gorm:"uniqueIndex:idx_metric_value" json:"name"` // 指标名称
+	Number    *float64 `json:"number,omitempty"`                         // 数值(gauge, counter)
+	Text      *string  `json:"text,omitempty"`                           // 文本值(enum, string)
+	Timestamp int64    `json:"timestamp"`                                // 上报时间戳(毫秒)
+}
+
+type MetricSample struct {
+	Id        string   `gorm:"primaryKey;column:id" json:"-"`                  // 唯一标识
+	DeviceId  string   `gorm:"index:idx_metric_sample" json:"-"`               // 设备ID
+	Name      string   `gorm:"index:idx_metric_sample" json:"-"`               // 指标名称
+	Number    *float64 `json:"number,omitempty"`                               // 数值(gauge, counter)
+	Text      *string  `json:"text,omitempty"`                                 // 文本值(enum, string)
+	Timestamp int64    `gorm:"index:idx_metric_sample;index" json:"timestamp"` // 上报时间戳(毫秒)
+}
+
+type DeviceCommand struct {
+	Id          string          `gorm:"primaryKey;column:id" json:"id"`             // 唯一标识
+	DeviceId    string          `gorm:"index:idx_command_device" json:"device_id"`  // 设备ID
+	IssuerId    string          `json:"issuer_id"`                                  // 下发命令的用户ID
+	Type        string          `json:"type"`                                       // 命令类型(report_now, set_interval, pause, message)
+	Payload     json.RawMessage `gorm:"type:text" json:"payload"`                   // 命令参数(JSON)
+	Status      string          `gorm:"index:idx_command_device" json:"status"`     // 状态(pending, delivered, acked, failed)
+	Result      string          `json:"result"`                                     // 设备返回的结果或失败原因
+	CreatedAt   time.Time       `gorm:"index:idx_command_device" json:"created_at"` // 创建时间
+	DeliveredAt *time.Time      `json:"delivered_at"`                               // 最近一次下发时间
+	AckedAt     *time.Time      `json:"acked_at"`                                   // 设备确认时间
+	ExpiresAt   time.Time       `json:"expires_at"`                                 // 过期时间, 过期前未确认视为失败
+}
+
+type Webhook struct {
+	Id               string    `gorm:"primaryKey;column:id" json:"id"` // 唯一标识
+	UserId           string    `gorm:"index" json:"user_id"`           // 所属用户ID
+	URL              string    `json:"url"`                            // 接收地址
+	Secret           string    `json:"-"`                              // 签名密钥
+	Events           []string  `gorm:"serializer:json" json:"events"`  // 订阅的事件类型
+	DeviceId         string    `json:"device_id"`                      // 仅接收该设备的事件(为空表示全部设备)
+	BatteryThreshold int       `json:"battery_threshold"`              // battery.low 事件的电量阈值(%)
+	Enabled          bool      `json:"enabled"`                        // 是否启用
+	Failures         int       `json:"failures"`                       // 连续投递失败次数
+	DisabledReason   string    `json:"disabled_reason"`                // 被自动停用的原因
+	CreatedAt        time.Time `json:"created_at"`                     // 创建时间
+}
+
+type WebhookDelivery struct {
+	Id            string          `gorm:"primaryKey;column:id" json:"id"`               // 唯一标识
+	WebhookId     string          `gorm:"index:idx_webhook_delivery" json:"webhook_id"` // Webhook ID
+	EventId       string          `json:"event_id"`                                     // 事件ID(同一事件投递给多个 Webhook 时相同)
+	Event         string          `json:"event"`                                        // 事件类型
+	Payload       json.RawMessage `gorm:"type:text" json:"payload"`                     // 请求体
+	Status        string          `gorm:"index:idx_webhook_due" json:"status"`          // 状态(pending, succeeded, failed)
+	Attempts      int             `json:"attempts"`                                     // 已尝试次数
+	NextAttemptAt time.Time       `gorm:"index:idx_webhook_due" json:"next_attempt_at"` // 下次尝试时间
+	StatusCode    int             `json:"status_code"`                                  // 最近一次响应状态码
+	Error         string          `json:"error"`                                        // 最近一次失败原因
+	DurationMs    int64           `json:"duration_ms"`                                  // 最近一次请求耗时(毫秒)
+	CreatedAt     time.Time       `gorm:"index:idx_webhook_delivery" json:"created_at"` // 创建时间
+	CompletedAt   *time.Time      `json:"completed_at"`                                 // 投递成功或放弃的时间
 }
 
 type DeviceStatus struct {
@@ -38,6 +177,27 @@ type DeviceStatus struct {
 	Other      OtherStatus      `gorm:"embedded;embeddedPrefix:other_" json:"other"`           // 其他状态
 }
 
+type DeviceStatusHistory struct {
+	Id         string           `gorm:"primaryKey;column:id" json:"id"`                                                  // 唯一标识
+	DeviceId   string           `gorm:"index:idx_history_device_time;uniqueIndex:idx_history_snapshot" json:"device_id"` // 设备ID
+	SnapshotId *string          `gorm:"uniqueIndex:idx_history_snapshot" json:"snapshot_id,omitempty"`                   // 客户端快照ID(批量上报时用于去重)
+	Timestamp  int64            `gorm:"index:idx_history_device_time;index" json:"timestamp"`                            // 上报时间戳(毫秒)
+	Battery    BatteryStatus    `gorm:"embedded;embeddedPrefix:battery_" json:"battery"`                                 // 电池状态
+	Network    NetworkStatus    `gorm:"embedded;embeddedPrefix:network_" json:"network"`                                 // 网络状态
+	Foreground ForegroundStatus `gorm:"embedded;embeddedPrefix:foreground_" json:"foreground"`                           // 前台应用状态
+	Other      OtherStatus      `gorm:"embedded;embeddedPrefix:other_" json:"other"`                                     // 其他状态
+}
+
+type StatusRollup struct {
+	Id          string    `gorm:"primaryKey;column:id" json:"id"`                    // 唯一标识
+	DeviceId    string    `gorm:"uniqueIndex:idx_rollup_bucket" json:"device_id"`    // 设备ID
+	Resolution  string    `gorm:"uniqueIndex:idx_rollup_bucket" json:"resolution"`   // 聚合粒度(minute, hour, day)
+	BucketStart int64     `gorm:"uniqueIndex:idx_rollup_bucket" json:"bucket_start"` // 时间桶起点(毫秒, UTC 对齐)
+	Samples     int64     `json:"samples"`                                           // 时间桶内的原始上报次数
+	Data        string    `json:"-"`                                                 // 聚合结果(JSON)
+	UpdatedAt   time.Time `json:"updated_at"`                                        // 最近计算时间
+}
+
 type BatteryStatus struct {
 	Charging    int     `json:"charging"`    // 是否充电中(1: 充电中, 2: 未充电, 3: 已充满)
 	Level       int     `json:"level"`       // 电池电量百分比(0~100)
@@ -48,7 +208,7 @@ type BatteryStatus struct {
 type NetworkStatus struct {
 	WifiConnected     int     `json:"wifi_connected"`      // 是否连接 WiFi(1: 连接, 2: 未连接)
 	WifiSSId          string  `json:"wifi_ssid"`           // 当前连接的 WiFi 名称
-	MobileDataActive  int     `json:"mobile_data_active"`  // 是否启用流量(1: 启用, 2: 未启用)
+	MobileDataActive  int     `json:"mobile_data_active"`  // 是否启用流量(1: 启用, 2: 未启用, 3: 未知)
 	MobileSignalDbm   int     `json:"mobile_signal_dbm"`   // 移动网络信号强度(单位 dBm)
 	NetworkType       string  `json:"network_type"`        // 当前网络类型(如: WiFi, 4G, 5G, Ethernet)
 	TrafficUsedMB     float64 `json:"traffic_used_mb"`     // 当日流量使用量(单位 MB)
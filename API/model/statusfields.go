@@ -0,0 +1,97 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// StatusFieldKind 状态字段类型, 决定历史数据的聚合方式
+type StatusFieldKind int
+
+const (
+	NumericField StatusFieldKind = iota // 数值, 聚合为最小/最大/平均值
+	StateField                          // 枚举状态, 聚合为各状态的持续时间
+	TextField                           // 文本, 不参与聚合
+)
+
+// StatusRule 状态字段的取值约束
+type StatusRule struct {
+	Ranged   bool    // 是否限制数值范围
+	Min, Max float64 // 数值范围(闭区间)
+	Values   []int   // 允许的枚举值, 0 表示未上报, 总是允许
+	MaxLen   int     // 文本最大长度(字节), 0 表示不限制
+}
+
+func between(min, max float64) StatusRule {
+	return StatusRule{Ranged: true, Min: min, Max: max}
+}
+
+func oneOf(values ...int) StatusRule {
+	return StatusRule{Values: values}
+}
+
+func maxLen(n int) StatusRule {
+	return StatusRule{MaxLen: n}
+}
+
+// StatusField 状态字段: 接口中的字段路径(分组.字段), 数据库列名与取值约束
+type StatusField struct {
+	Path   string
+	Column string
+	Kind   StatusFieldKind
+	Rule   StatusRule
+}
+
+// StatusFields DeviceStatus 中可查询的全部字段
+var StatusFields = []StatusField{
+	{"battery.charging", "battery_charging", StateField, oneOf(1, 2, 3)},
+	{"battery.level", "battery_level", NumericField, between(0, 100)},
+	{"battery.temperature", "battery_temperature", NumericField, between(-50, 150)},
+	{"battery.capacity", "battery_capacity", NumericField, between(0, 1_000_000)},
+	{"network.wifi_connected", "network_wifi_connected", StateField, oneOf(1, 2)},
+	{"network.wifi_ssid", "network_wifi_ss_id", TextField, maxLen(64)},
+	{"network.mobile_data_active", "network_mobile_data_active", StateField, oneOf(1, 2, 3)},
+	{"network.mobile_signal_dbm", "network_mobile_signal_dbm", NumericField, between(-200, 0)},
+	{"network.network_type", "network_network_type", StateField, maxLen(32)},
+	{"network.traffic_used_mb", "network_traffic_used_mb", NumericField, between(0, 1e9)},
+	{"network.upload_speed_kbps", "network_upload_speed_kbps", NumericField, between(0, 1e9)},
+	{"network.download_speed_kbps", "network_download_speed_kbps", NumericField, between(0, 1e9)},
+	{"foreground.app_name", "foreground_app_name", TextField, maxLen(256)},
+	{"foreground.app_title", "foreground_app_title", TextField, maxLen(512)},
+	{"foreground.speaker_playing", "foreground_speaker_playing", StateField, oneOf(1, 2, 3)},
+	{"other.screen_on", "other_screen_on", StateField, oneOf(1, 2)},
+	{"other.is_charging_via_usb", "other_is_charging_via_usb", StateField, oneOf(1, 2)},
+	{"other.is_charging_via_ac", "other_is_charging_via_ac", StateField, oneOf(1, 2)},
+	{"other.is_low_power_mode", "other_is_low_power_mode", StateField, oneOf(1, 2)},
+}
+
+// Check 校验字段值, 不合法时返回原因, 合法时返回空字符串
+func (f StatusField) Check(value any) string {
+	var number float64
+	switch v := value.(type) {
+	case int:
+		if len(f.Rule.Values) > 0 && v != 0 && !slices.Contains(f.Rule.Values, v) {
+			values := make([]string, len(f.Rule.Values))
+			for i, allowed := range f.Rule.Values {
+				values[i] = fmt.Sprint(allowed)
+			}
+			return "取值应为 " + strings.Join(values, ", ") + " 之一"
+		}
+		number = float64(v)
+	case float64:
+		number = v
+	case string:
+		if f.Rule.MaxLen > 0 && len(v) > f.Rule.MaxLen {
+			return fmt.Sprintf("长度不能超过 %d 字节", f.Rule.MaxLen)
+		}
+		return ""
+	default:
+		return ""
+	}
+	if f.Rule.Ranged && (number < f.Rule.Min || number > f.Rule.Max) {
+		return "应在 " + strconv.FormatFloat(f.Rule.Min, 'f', -1, 64) + " 到 " + strconv.FormatFloat(f.Rule.Max, 'f', -1, 64) + " 之间"
+	}
+	return ""
+}
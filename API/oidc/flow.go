@@ -0,0 +1,115 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// PendingTTL 授权请求的有效期
+const PendingTTL = 10 * time.Minute
+
+var (
+	ErrUnknownState    = errors.New("登录请求不存在或已过期")
+	ErrBindingMismatch = errors.New("登录请求不是由当前浏览器发起")
+	ErrTooManyPending  = errors.New("等待回调的登录请求过多")
+)
+
+// Pending 等待回调的登录请求
+type Pending struct {
+	request      *AuthRequest
+	LinkUserId   string // 不为空时表示为已登录用户绑定外部账户
+	ReauthUserId string // 不为空时表示已登录用户重新验证身份
+	ReturnTo     string // 登录完成后跳转的客户端地址(可为空)
+	binding      [32]byte
+	expiresAt    time.Time
+}
+
+// Begin 开始一次登录, 返回身份提供方的授权地址及浏览器绑定值;
+// 绑定值应保存在发起登录的浏览器中(如 Cookie), 回调时一并提交, 防止授权地址被转交给他人完成;
+// 等待回调的请求达到上限时返回 ErrTooManyPending
+func (p *Provider) Begin(ctx context.Context, linkUserId, returnTo string) (authURL, binding string, err error) {
+	return p.begin(ctx, &Pending{LinkUserId: linkUserId, ReturnTo: returnTo})
+}
+
+// BeginReauth 开始一次重新验证, 已登录用户通过已绑定的外部账户确认身份
+func (p *Provider) BeginReauth(ctx context.Context, userId, returnTo string) (authURL, binding string, err error) {
+	return p.begin(ctx, &Pending{ReauthUserId: userId, ReturnTo: returnTo})
+}
+
+// 生成授权请求并保存 pending
+func (p *Provider) begin(ctx context.Context, pending *Pending) (authURL, binding string, err error) {
+	req, err := NewAuthRequest()
+	if err != nil {
+		return "", "", err
+	}
+	authURL, err = p.AuthCodeURL(ctx, req)
+	if err != nil {
+		return "", "", err
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	binding = base64.RawURLEncoding.EncodeToString(buf)
+
+	now := time.Now()
+	p.mu.Lock()
+	if p.pending == nil {
+		p.pending = make(map[string]*Pending)
+	}
+	for state, entry := range p.pending {
+		if now.After(entry.expiresAt) {
+			delete(p.pending, state)
+		}
+	}
+	// 未完成的请求会一直保留到过期, 数量达到上限时拒绝新的请求, 防止被大量发起登录耗尽内存
+	if len(p.pending) >= p.opts.MaxPending {
+		p.mu.Unlock()
+		return "", "", ErrTooManyPending
+	}
+	pending.request = req
+	pending.binding = sha256.Sum256([]byte(binding))
+	pending.expiresAt = now.Add(PendingTTL)
+	p.pending[req.State] = pending
+	p.mu.Unlock()
+
+	return authURL, binding, nil
+}
+
+// Complete 处理回调, state 只能使用一次, binding 须与 Begin 返回的一致;
+// 返回 ID Token 声明及对应的登录请求
+func (p *Provider) Complete(ctx context.Context, state, binding, code string) (map[string]any, *Pending, error) {
+	p.mu.Lock()
+	pending, ok := p.pending[state]
+	delete(p.pending, state)
+	p.mu.Unlock()
+	if !ok || time.Now().After(pending.expiresAt) {
+		return nil, nil, ErrUnknownState
+	}
+	sum := sha256.Sum256([]byte(binding))
+	if subtle.ConstantTimeCompare(sum[:], pending.binding[:]) != 1 {
+		return nil, nil, ErrBindingMismatch
+	}
+
+	claims, err := p.Exchange(ctx, code, pending.request)
+	if err != nil {
+		return nil, pending, err
+	}
+	return claims, pending, nil
+}
+
+// Issuer 发行方地址
+func (p *Provider) Issuer() string {
+	return p.opts.Issuer
+}
+
+// ClaimString 读取字符串声明
+func ClaimString(claims map[string]any, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
@@ -0,0 +1,218 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// 允许的时钟偏差
+const clockSkew = time.Minute
+
+var ErrInvalidIdToken = errors.New("ID Token 无效")
+
+// 校验 ID Token 的签名与声明
+func (p *Provider) verifyIdToken(ctx context.Context, idToken, nonce string) (map[string]any, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIdToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidIdToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIdToken
+	}
+
+	key, err := p.getKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidIdToken
+	}
+	if err := p.validateClaims(claims, nonce); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// 校验 iss, aud, exp, nonce
+func (p *Provider) validateClaims(claims map[string]any, nonce string) error {
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != p.opts.Issuer {
+		return fmt.Errorf("%w: iss 不匹配", ErrInvalidIdToken)
+	}
+
+	audOk := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audOk = aud == p.opts.ClientId
+	case []any:
+		for _, a := range aud {
+			if a == p.opts.ClientId {
+				audOk = true
+			}
+		}
+	}
+	if !audOk {
+		return fmt.Errorf("%w: aud 不匹配", ErrInvalidIdToken)
+	}
+
+	exp, _ := claims["exp"].(float64)
+	if time.Now().Add(-clockSkew).Unix() >= int64(exp) {
+		return fmt.Errorf("%w: 已过期", ErrInvalidIdToken)
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return fmt.Errorf("%w: nonce 不匹配", ErrInvalidIdToken)
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return fmt.Errorf("%w: 缺少 sub", ErrInvalidIdToken)
+	}
+	return nil
+}
+
+// 按 kid 获取公钥, 未找到时重新拉取 JWKS(身份提供方可能已轮换密钥)
+func (p *Provider) getKey(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, d.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("获取 JWKS 失败: %w", err)
+	}
+
+	keys := make(map[string]any)
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if publicKey, err := k.publicKey(); err == nil {
+			keys[k.Kid] = publicKey
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: 未知的 kid %q", ErrInvalidIdToken, kid)
+	}
+	return key, nil
+}
+
+// 校验签名, 支持 RS256 与 ES256
+func verifySignature(alg string, key any, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) != nil {
+			return fmt.Errorf("%w: 签名错误", ErrInvalidIdToken)
+		}
+	case "ES256":
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return fmt.Errorf("%w: 签名错误", ErrInvalidIdToken)
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(publicKey, digest[:], r, s) {
+			return fmt.Errorf("%w: 签名错误", ErrInvalidIdToken)
+		}
+	default:
+		return fmt.Errorf("%w: 不支持的签名算法 %q", ErrInvalidIdToken, alg)
+	}
+	return nil
+}
+
+// JSON Web Key
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// 转换为公钥
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("不支持的曲线 %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return nil, fmt.Errorf("不支持的密钥类型 %q", k.Kty)
+}
+
+// 解码 JWT 片段
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,205 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options 身份提供方配置
+type Options struct {
+	Issuer       string       // 发行方地址, 用于发现配置及校验 iss
+	ClientId     string       // 客户端ID
+	ClientSecret string       // 客户端密钥(公共客户端可为空, 仅依赖 PKCE)
+	RedirectURL  string       // 回调地址, 需与身份提供方登记的一致
+	Scopes       []string     // 申请的权限范围
+	HTTPClient   *http.Client // 请求身份提供方使用的客户端(为空时使用默认客户端)
+	MaxPending   int          // 最多同时等待回调的登录请求数(为 0 时使用默认值)
+}
+
+// 默认最多同时等待回调的登录请求数
+const defaultMaxPending = 10000
+
+// Provider OpenID Connect 身份提供方
+type Provider struct {
+	opts Options
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]any      // kid -> 公钥
+	pending   map[string]*Pending // state -> 等待回调的登录请求
+}
+
+// 发现文档中用到的字段
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewProvider 创建身份提供方, 发现文档在首次使用时加载
+func NewProvider(opts Options) *Provider {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = defaultMaxPending
+	}
+	if len(opts.Scopes) == 0 {
+		opts.Scopes = []string{"openid", "profile", "email"}
+	}
+	opts.Issuer = strings.TrimSuffix(opts.Issuer, "/")
+	return &Provider{opts: opts}
+}
+
+// AuthRequest 一次授权请求的参数, 需在回调时取回
+type AuthRequest struct {
+	State    string
+	Nonce    string
+	Verifier string // PKCE code_verifier
+}
+
+// NewAuthRequest 生成随机的 state, nonce 与 PKCE verifier
+func NewAuthRequest() (*AuthRequest, error) {
+	values := make([]string, 3)
+	for i := range values {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(buf)
+	}
+	return &AuthRequest{State: values[0], Nonce: values[1], Verifier: values[2]}, nil
+}
+
+// AuthCodeURL 生成跳转到身份提供方的授权地址(授权码模式 + PKCE S256)
+func (p *Provider) AuthCodeURL(ctx context.Context, req *AuthRequest) (string, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(req.Verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.opts.ClientId)
+	params.Set("redirect_uri", p.opts.RedirectURL)
+	params.Set("scope", strings.Join(p.opts.Scopes, " "))
+	params.Set("state", req.State)
+	params.Set("nonce", req.Nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange 使用授权码换取并校验 ID Token, 返回其中的声明
+func (p *Provider) Exchange(ctx context.Context, code string, req *AuthRequest) (map[string]any, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.opts.RedirectURL)
+	form.Set("client_id", p.opts.ClientId)
+	form.Set("code_verifier", req.Verifier)
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+	if p.opts.ClientSecret != "" {
+		httpReq.SetBasicAuth(url.QueryEscape(p.opts.ClientId), url.QueryEscape(p.opts.ClientSecret))
+	}
+
+	resp, err := p.opts.HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("请求令牌端点失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("读取令牌响应失败: %w", err)
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("解析令牌响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("令牌端点返回错误: %s %s", token.Error, token.ErrorDescription)
+	}
+	if token.IdToken == "" {
+		return nil, errors.New("令牌响应缺少 id_token")
+	}
+
+	return p.verifyIdToken(ctx, token.IdToken, req.Nonce)
+}
+
+// 获取发现文档(带缓存)
+func (p *Provider) getDiscovery(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	d := p.discovery
+	p.mu.Unlock()
+	if d != nil {
+		return d, nil
+	}
+
+	d = &discovery{}
+	if err := p.getJSON(ctx, p.opts.Issuer+"/.well-known/openid-configuration", d); err != nil {
+		return nil, fmt.Errorf("获取发现文档失败: %w", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.opts.Issuer {
+		return nil, fmt.Errorf("发现文档中的 issuer 不匹配: %s", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("发现文档缺少必要的端点")
+	}
+
+	p.mu.Lock()
+	p.discovery = d
+	p.mu.Unlock()
+	return d, nil
+}
+
+// 发送 GET 请求并解析 JSON
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.opts.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("状态码 %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
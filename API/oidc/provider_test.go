@@ -0,0 +1,251 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 本地模拟身份提供方
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu       sync.Mutex
+	codes    map[string]url.Values // 授权码 -> 授权请求参数
+	audience string                // 签发的 aud(为空时使用请求中的 client_id)
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	idp := &mockIdP{key: key, codes: make(map[string]url.Values)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		idp.mu.Lock()
+		authReq, ok := idp.codes[r.PostForm.Get("code")]
+		delete(idp.codes, r.PostForm.Get("code"))
+		idp.mu.Unlock()
+
+		// 校验 PKCE
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != authReq.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		aud := idp.audience
+		if aud == "" {
+			aud = authReq.Get("client_id")
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"id_token": idp.sign(t, map[string]any{
+				"iss":                idp.server.URL,
+				"aud":                aud,
+				"sub":                "user-123",
+				"exp":                time.Now().Add(time.Hour).Unix(),
+				"iat":                time.Now().Unix(),
+				"nonce":              authReq.Get("nonce"),
+				"preferred_username": "sloth",
+			}),
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// 模拟用户在身份提供方完成授权, 返回授权码与 state
+func (idp *mockIdP) authorize(t *testing.T, authURL string) (code, state string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("授权地址无效: %v", err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("未使用 PKCE S256: %s", authURL)
+	}
+	idp.mu.Lock()
+	idp.codes["code-1"] = query
+	idp.mu.Unlock()
+	return "code-1", query.Get("state")
+}
+
+// 签发 RS256 ID Token
+func (idp *mockIdP) sign(t *testing.T, claims map[string]any) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"test"}`))
+	payload, _ := json.Marshal(claims)
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("签名失败: %v", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newTestProvider(idp *mockIdP) *Provider {
+	return NewProvider(Options{
+		Issuer:      idp.server.URL,
+		ClientId:    "sloth-tracker",
+		RedirectURL: "http://localhost:8080/api/oidc/callback",
+		HTTPClient:  idp.server.Client(),
+	})
+}
+
+func TestLoginFlow(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := newTestProvider(idp)
+	ctx := context.Background()
+
+	authURL, binding, err := provider.Begin(ctx, "", "")
+	if err != nil {
+		t.Fatalf("Begin 失败: %v", err)
+	}
+	code, state := idp.authorize(t, authURL)
+
+	claims, pending, err := provider.Complete(ctx, state, binding, code)
+	if err != nil {
+		t.Fatalf("Complete 失败: %v", err)
+	}
+	if ClaimString(claims, "sub") != "user-123" || ClaimString(claims, "preferred_username") != "sloth" {
+		t.Errorf("声明不正确: %v", claims)
+	}
+	if pending.LinkUserId != "" {
+		t.Errorf("LinkUserId = %q, want 空", pending.LinkUserId)
+	}
+
+	// state 只能使用一次
+	if _, _, err := provider.Complete(ctx, state, binding, code); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("重复回调应返回 ErrUnknownState, got %v", err)
+	}
+}
+
+func TestRejectsWrongAudience(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.audience = "other-client"
+	provider := newTestProvider(idp)
+	ctx := context.Background()
+
+	authURL, binding, err := provider.Begin(ctx, "", "")
+	if err != nil {
+		t.Fatalf("Begin 失败: %v", err)
+	}
+	code, state := idp.authorize(t, authURL)
+
+	if _, _, err := provider.Complete(ctx, state, binding, code); !errors.Is(err, ErrInvalidIdToken) {
+		t.Errorf("aud 不匹配时应返回 ErrInvalidIdToken, got %v", err)
+	}
+}
+
+func TestUnknownState(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := newTestProvider(idp)
+
+	if _, _, err := provider.Complete(context.Background(), "missing", "", "code"); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("未知 state 应返回 ErrUnknownState, got %v", err)
+	}
+}
+
+func TestRejectsOtherBrowser(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := newTestProvider(idp)
+	ctx := context.Background()
+
+	authURL, binding, err := provider.Begin(ctx, "user-1", "")
+	if err != nil {
+		t.Fatalf("Begin 失败: %v", err)
+	}
+	code, state := idp.authorize(t, authURL)
+
+	// 授权地址被转交给他人, 回调时没有发起方的绑定值
+	if _, _, err := provider.Complete(ctx, state, binding+"x", code); !errors.Is(err, ErrBindingMismatch) {
+		t.Errorf("绑定值不一致时应返回 ErrBindingMismatch, got %v", err)
+	}
+	// 校验失败后 state 作废
+	if _, _, err := provider.Complete(ctx, state, binding, code); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("校验失败后 state 应作废, got %v", err)
+	}
+}
+
+func TestPendingLimit(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := NewProvider(Options{
+		Issuer:      idp.server.URL,
+		ClientId:    "sloth-tracker",
+		RedirectURL: "http://localhost:8080/api/oidc/callback",
+		HTTPClient:  idp.server.Client(),
+		MaxPending:  3,
+	})
+	ctx := context.Background()
+
+	var bindings, states []string
+	for range 3 {
+		authURL, binding, err := provider.Begin(ctx, "", "")
+		if err != nil {
+			t.Fatalf("Begin 失败: %v", err)
+		}
+		u, _ := url.Parse(authURL)
+		bindings = append(bindings, binding)
+		states = append(states, u.Query().Get("state"))
+	}
+
+	// 达到上限后拒绝新的请求
+	if _, _, err := provider.Begin(ctx, "", ""); !errors.Is(err, ErrTooManyPending) {
+		t.Fatalf("达到上限时应返回 ErrTooManyPending, got %v", err)
+	}
+
+	// 回调(无论成功与否)后释放名额
+	provider.Complete(ctx, states[0], bindings[0]+"x", "code")
+	if _, _, err := provider.Begin(ctx, "", ""); err != nil {
+		t.Errorf("释放名额后 Begin 失败: %v", err)
+	}
+
+	// 过期的请求不占用名额
+	provider.mu.Lock()
+	for _, pending := range provider.pending {
+		pending.expiresAt = time.Now().Add(-time.Second)
+	}
+	provider.mu.Unlock()
+	if _, _, err := provider.Begin(ctx, "", ""); err != nil {
+		t.Errorf("请求过期后 Begin 失败: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const argon2Prefix = "$argon2id$"
+
+var errInvalidArgon2Hash = errors.New("argon2id 哈希格式错误")
+
+// Argon2id argon2id 哈希, 编码格式: $argon2id$v=19$m=<KiB>,t=<迭代>,p=<并行>$<盐>$<哈希>
+type Argon2id struct {
+	Memory  uint32 // 内存开销(KiB)
+	Time    uint32 // 迭代次数
+	Threads uint8  // 并行度
+	SaltLen int    // 盐长度(字节)
+	KeyLen  uint32 // 哈希长度(字节)
+}
+
+// DefaultArgon2id 默认参数(参考 OWASP 建议: 19MiB, 2 次迭代, 1 并行)
+var DefaultArgon2id = Argon2id{
+	Memory:  19 * 1024,
+	Time:    2,
+	Threads: 1,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2Prefix, argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a Argon2id) Verify(encoded, password string) (bool, error) {
+	p, err := decodeArgon2(encoded)
+	if err != nil {
+		return false, err
+	}
+	// 使用哈希中记录的参数计算, 参数调整后旧哈希仍可校验
+	key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (a Argon2id) Identify(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2Prefix)
+}
+
+func (a Argon2id) NeedsRehash(encoded string) bool {
+	p, err := decodeArgon2(encoded)
+	if err != nil {
+		return true
+	}
+	return p.memory != a.Memory || p.time != a.Time || p.threads != a.Threads ||
+		len(p.salt) != a.SaltLen || len(p.key) != int(a.KeyLen)
+}
+
+// 解析编码后的 argon2id 哈希
+func decodeArgon2(encoded string) (*argon2Params, error) {
+	parts := strings.Split(encoded, "$")
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", 盐, 哈希
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, errInvalidArgon2Hash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, errInvalidArgon2Hash
+	}
+
+	p := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, errInvalidArgon2Hash
+	}
+	if p.memory == 0 || p.time == 0 || p.threads == 0 {
+		return nil, errInvalidArgon2Hash
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errInvalidArgon2Hash
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(p.key) == 0 {
+		return nil, errInvalidArgon2Hash
+	}
+	return p, nil
+}
@@ -0,0 +1,9 @@
+package password
+
+// Hasher 密码哈希算法, 哈希结果需自带算法标识与参数
+type Hasher interface {
+	Hash(password string) (string, error)          // 生成哈希
+	Verify(encoded, password string) (bool, error) // 校验密码
+	Identify(encoded string) bool                  // 判断哈希是否由该算法生成
+	NeedsRehash(encoded string) bool               // 哈希参数是否与当前配置不一致
+}
@@ -0,0 +1,130 @@
+package password
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sloth-tracker/api/config"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var ErrBreached = errors.New("该密码过于常见或已泄露, 请更换")
+
+// Options 密码策略
+type Options struct {
+	Hasher    Hasher   // 新密码使用的哈希算法
+	Legacy    []Hasher // 仍可校验的其他算法(校验成功后升级为 Hasher)
+	MinLength int      // 最小长度(按字符计)
+	Denylist  []string // 禁用密码文件路径, 每行一个密码
+}
+
+// Manager 负责密码哈希, 校验与强度检查
+type Manager struct {
+	hasher    Hasher
+	hashers   []Hasher
+	minLength int
+	denylist  map[string]struct{}
+}
+
+// New 创建密码管理器, 会读取全部禁用密码文件
+func New(opts Options) (*Manager, error) {
+	m := &Manager{
+		hasher:    opts.Hasher,
+		hashers:   append([]Hasher{opts.Hasher}, opts.Legacy...),
+		minLength: opts.MinLength,
+		denylist:  make(map[string]struct{}),
+	}
+	for _, path := range opts.Denylist {
+		if err := m.loadDenylist(path); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// 读取禁用密码文件(忽略空行, 比较时不区分大小写)
+func (m *Manager) loadDenylist(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			m.denylist[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
+
+// Hash 使用当前算法生成密码哈希
+func (m *Manager) Hash(password string) (string, error) {
+	return m.hasher.Hash(password)
+}
+
+// Verify 校验密码, 并返回是否应使用当前算法重新生成哈希
+func (m *Manager) Verify(encoded, password string) (ok bool, rehash bool) {
+	for _, h := range m.hashers {
+		if !h.Identify(encoded) {
+			continue
+		}
+		ok, err := h.Verify(encoded, password)
+		if err != nil || !ok {
+			return false, false
+		}
+		return true, h != m.hasher || h.NeedsRehash(encoded)
+	}
+	return false, false
+}
+
+// Validate 检查新密码是否符合策略
+func (m *Manager) Validate(password string) error {
+	if utf8.RuneCountInString(password) < max(m.minLength, 1) {
+		return fmt.Errorf("密码长度不能少于 %d 位", max(m.minLength, 1))
+	}
+	if _, ok := m.denylist[strings.ToLower(password)]; ok {
+		return ErrBreached
+	}
+	return nil
+}
+
+var (
+	instance *Manager
+	once     sync.Once
+)
+
+// Default 获取按服务器配置创建的全局密码管理器
+func Default() *Manager {
+	once.Do(func() {
+		cfg := config.Get()
+		bcryptHasher := Bcrypt{Cost: cfg.BcryptCost}
+		argon2Hasher := DefaultArgon2id
+		argon2Hasher.Memory = uint32(cfg.Argon2Memory)
+		argon2Hasher.Time = uint32(cfg.Argon2Time)
+		argon2Hasher.Threads = uint8(cfg.Argon2Threads)
+
+		opts := Options{
+			Hasher:    argon2Hasher,
+			Legacy:    []Hasher{bcryptHasher},
+			MinLength: cfg.PasswordMinLength,
+			Denylist:  cfg.PasswordDenylist,
+		}
+		if cfg.PasswordHash == "bcrypt" {
+			opts.Hasher, opts.Legacy = bcryptHasher, []Hasher{argon2Hasher}
+		} else if cfg.PasswordHash != "argon2id" {
+			log.Printf("⚠️ 未知的密码哈希算法 %s, 使用 argon2id", cfg.PasswordHash)
+		}
+
+		var err error
+		if instance, err = New(opts); err != nil {
+			log.Fatal("❌ 读取禁用密码文件失败: ", err)
+		}
+	})
+	return instance
+}
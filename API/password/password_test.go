@@ -0,0 +1,125 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 测试使用较低的参数, 避免拖慢测试
+var (
+	testArgon2 = Argon2id{Memory: 64, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+	testBcrypt = Bcrypt{Cost: 4}
+)
+
+func TestHashers(t *testing.T) {
+	for _, h := range []Hasher{testArgon2, testBcrypt} {
+		encoded, err := h.Hash("correct horse")
+		if err != nil {
+			t.Fatalf("%T 生成哈希失败: %v", h, err)
+		}
+		if !h.Identify(encoded) {
+			t.Errorf("%T 无法识别自身哈希 %q", h, encoded)
+		}
+		if ok, err := h.Verify(encoded, "correct horse"); !ok || err != nil {
+			t.Errorf("%T 正确密码校验失败: %v %v", h, ok, err)
+		}
+		if ok, _ := h.Verify(encoded, "wrong horse"); ok {
+			t.Errorf("%T 错误密码校验通过", h)
+		}
+		if h.NeedsRehash(encoded) {
+			t.Errorf("%T 相同参数不应需要重新哈希", h)
+		}
+	}
+}
+
+func TestArgon2Encoding(t *testing.T) {
+	encoded, err := testArgon2.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("编码格式错误: %s", encoded)
+	}
+
+	// 参数调整后旧哈希仍可校验, 但需要重新哈希
+	stronger := testArgon2
+	stronger.Time = 2
+	if ok, _ := stronger.Verify(encoded, "secret"); !ok {
+		t.Error("参数调整后无法校验旧哈希")
+	}
+	if !stronger.NeedsRehash(encoded) {
+		t.Error("参数调整后应需要重新哈希")
+	}
+
+	for _, bad := range []string{"$argon2id$", "$argon2id$v=19$m=0,t=1,p=1$AAAA$AAAA", "$argon2id$v=18$m=64,t=1,p=1$AAAA$AAAA"} {
+		if _, err := testArgon2.Verify(bad, "secret"); err == nil {
+			t.Errorf("格式错误的哈希 %q 应返回错误", bad)
+		}
+	}
+}
+
+func TestManagerRehash(t *testing.T) {
+	m, err := New(Options{Hasher: testArgon2, Legacy: []Hasher{testBcrypt}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy, _ := testBcrypt.Hash("secret")
+	current, _ := m.Hash("secret")
+
+	tests := []struct {
+		name       string
+		encoded    string
+		password   string
+		wantOk     bool
+		wantRehash bool
+	}{
+		{"旧算法", legacy, "secret", true, true},
+		{"当前算法", current, "secret", true, false},
+		{"密码错误", legacy, "wrong", false, false},
+		{"空哈希", "", "", false, false},
+		{"未知格式", "plaintext", "plaintext", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash := m.Verify(tt.encoded, tt.password)
+			if ok != tt.wantOk || rehash != tt.wantRehash {
+				t.Errorf("Verify = %v, %v, want %v, %v", ok, rehash, tt.wantOk, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denylist.txt")
+	if err := os.WriteFile(path, []byte("Password123\n\n  qwertyuiop  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(Options{Hasher: testArgon2, MinLength: 8, Denylist: []string{path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		wantErr  bool
+	}{
+		{"", true},
+		{"short", true},
+		{"八个汉字也可以的", false},
+		{"password123", true},
+		{"QWERTYUIOP", true},
+		{"long enough", false},
+	}
+	for _, tt := range tests {
+		if err := m.Validate(tt.password); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%q) = %v, wantErr %v", tt.password, err, tt.wantErr)
+		}
+	}
+
+	if _, err := New(Options{Hasher: testArgon2, Denylist: []string{filepath.Join(t.TempDir(), "missing")}}); err == nil {
+		t.Error("禁用密码文件不存在时应返回错误")
+	}
+}
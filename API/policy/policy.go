@@ -0,0 +1,126 @@
+package policy
+
+import (
+	"errors"
+	"sloth-tracker/api/model"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDeviceNotFound = errors.New("设备不存在")
+	ErrShareNotFound  = errors.New("授权记录不存在")
+)
+
+// Action 对设备的操作
+type Action int
+
+const (
+	View    Action = iota // 查看设备信息与状态
+	Report                // 为设备签发上报凭证
+	Manage                // 修改, 注销设备及管理其凭证
+	Approve               // 审批设备的共享申请
+)
+
+// Role 用户相对设备的身份
+type Role int
+
+const (
+	Stranger      Role = iota // 无关用户
+	PendingViewer             // 已申请共享, 待授权
+	Viewer                    // 已授权的共享用户
+	Owner                     // 设备所有者
+)
+
+// 各身份允许的操作
+var permissions = map[Role]map[Action]bool{
+	Owner:         {View: true, Report: true, Manage: true, Approve: true},
+	Viewer:        {View: true},
+	PendingViewer: {},
+	Stranger:      {},
+}
+
+// Allowed 判断身份是否允许执行操作
+func Allowed(role Role, action Action) bool {
+	return permissions[role][action]
+}
+
+// RoleOf 获取用户相对设备的身份
+func RoleOf(db *gorm.DB, userId, deviceId string) (Role, error) {
+	var device model.Device
+	if err := db.Where("id = ?", deviceId).First(&device).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Stranger, ErrDeviceNotFound
+		}
+		return Stranger, err
+	}
+	if userId == "" {
+		return Stranger, nil
+	}
+	if device.OwnerId == userId {
+		return Owner, nil
+	}
+
+	var shared model.SharedDevice
+	if err := db.Where("device_id = ? AND viewer_id = ?", deviceId, userId).First(&shared).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Stranger, nil
+		}
+		return Stranger, err
+	}
+	if shared.Authorization == 1 {
+		return Viewer, nil
+	}
+	return PendingViewer, nil
+}
+
+// Can 判断用户能否对设备执行操作
+func Can(db *gorm.DB, userId string, action Action, deviceId string) (bool, error) {
+	role, err := RoleOf(db, userId, deviceId)
+	if err != nil {
+		return false, err
+	}
+	return Allowed(role, action), nil
+}
+
+// CanApproveShare 判断用户能否审批共享申请, 返回对应授权记录
+func CanApproveShare(db *gorm.DB, userId, shareId string) (*model.SharedDevice, bool, error) {
+	shared, err := findShare(db, shareId)
+	if err != nil {
+		return nil, false, err
+	}
+	ok, err := Can(db, userId, Approve, shared.DeviceId)
+	if errors.Is(err, ErrDeviceNotFound) {
+		// 设备已注销, 遗留的授权记录无人可审批
+		return shared, false, nil
+	}
+	return shared, ok, err
+}
+
+// CanDeleteShare 判断用户能否删除共享记录(设备所有者或申请人本人), 返回对应授权记录
+func CanDeleteShare(db *gorm.DB, userId, shareId string) (*model.SharedDevice, bool, error) {
+	shared, err := findShare(db, shareId)
+	if err != nil {
+		return nil, false, err
+	}
+	if userId != "" && shared.ViewerId == userId {
+		return shared, true, nil
+	}
+	ok, err := Can(db, userId, Manage, shared.DeviceId)
+	if errors.Is(err, ErrDeviceNotFound) {
+		return shared, false, nil
+	}
+	return shared, ok, err
+}
+
+// 查询授权记录
+func findShare(db *gorm.DB, shareId string) (*model.SharedDevice, error) {
+	var shared model.SharedDevice
+	if err := db.Where("id = ?", shareId).First(&shared).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShareNotFound
+		}
+		return nil, err
+	}
+	return &shared, nil
+}
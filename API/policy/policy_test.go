@@ -0,0 +1,142 @@
+package policy
+
+import (
+	"errors"
+	"sloth-tracker/api/internal/testdb"
+	"sloth-tracker/api/model"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 初始化测试数据库: 一台设备, 所有者, 已授权用户, 待授权用户, 无关用户
+func setupDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := testdb.Open(t, &model.User{}, &model.SharedDevice{}, &model.Device{})
+
+	now := time.Now()
+	db.Create(&model.Device{Id: "device", OwnerId: "owner", Name: "laptop", RegisteredAt: now})
+	db.Create(&model.SharedDevice{Id: "share-approved", DeviceId: "device", ViewerId: "viewer", Authorization: 1, CreatedAt: now})
+	db.Create(&model.SharedDevice{Id: "share-pending", DeviceId: "device", ViewerId: "pending", Authorization: 2, CreatedAt: now})
+	return db
+}
+
+func TestRoleOf(t *testing.T) {
+	db := setupDB(t)
+
+	tests := []struct {
+		name   string
+		userId string
+		want   Role
+	}{
+		{"所有者", "owner", Owner},
+		{"已授权用户", "viewer", Viewer},
+		{"待授权用户", "pending", PendingViewer},
+		{"无关用户", "stranger", Stranger},
+		{"未登录", "", Stranger},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RoleOf(db, tt.userId, "device")
+			if err != nil {
+				t.Fatalf("RoleOf 返回错误: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("RoleOf(%q) = %v, want %v", tt.userId, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := RoleOf(db, "owner", "missing"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("设备不存在时应返回 ErrDeviceNotFound, got %v", err)
+	}
+}
+
+func TestCan(t *testing.T) {
+	db := setupDB(t)
+
+	tests := []struct {
+		name   string
+		userId string
+		action Action
+		want   bool
+	}{
+		{"所有者查看", "owner", View, true},
+		{"所有者上报", "owner", Report, true},
+		{"所有者管理", "owner", Manage, true},
+		{"所有者审批", "owner", Approve, true},
+		{"已授权用户查看", "viewer", View, true},
+		{"已授权用户上报", "viewer", Report, false},
+		{"已授权用户管理", "viewer", Manage, false},
+		{"已授权用户审批", "viewer", Approve, false},
+		{"待授权用户查看", "pending", View, false},
+		{"待授权用户上报", "pending", Report, false},
+		{"待授权用户管理", "pending", Manage, false},
+		{"待授权用户审批", "pending", Approve, false},
+		{"无关用户查看", "stranger", View, false},
+		{"无关用户上报", "stranger", Report, false},
+		{"无关用户管理", "stranger", Manage, false},
+		{"无关用户审批", "stranger", Approve, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Can(db, tt.userId, tt.action, "device")
+			if err != nil {
+				t.Fatalf("Can 返回错误: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Can(%q, %v) = %v, want %v", tt.userId, tt.action, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShareActions(t *testing.T) {
+	db := setupDB(t)
+
+	tests := []struct {
+		name        string
+		userId      string
+		shareId     string
+		wantApprove bool
+		wantDelete  bool
+	}{
+		{"所有者处理待授权申请", "owner", "share-pending", true, true},
+		{"所有者处理已授权记录", "owner", "share-approved", true, true},
+		{"已授权用户处理自己的记录", "viewer", "share-approved", false, true},
+		{"已授权用户处理他人的申请", "viewer", "share-pending", false, false},
+		{"待授权用户处理自己的申请", "pending", "share-pending", false, true},
+		{"待授权用户处理他人的记录", "pending", "share-approved", false, false},
+		{"无关用户", "stranger", "share-pending", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shared, ok, err := CanApproveShare(db, tt.userId, tt.shareId)
+			if err != nil {
+				t.Fatalf("CanApproveShare 返回错误: %v", err)
+			}
+			if shared.Id != tt.shareId {
+				t.Errorf("CanApproveShare 返回记录 %q, want %q", shared.Id, tt.shareId)
+			}
+			if ok != tt.wantApprove {
+				t.Errorf("CanApproveShare(%q, %q) = %v, want %v", tt.userId, tt.shareId, ok, tt.wantApprove)
+			}
+
+			_, ok, err = CanDeleteShare(db, tt.userId, tt.shareId)
+			if err != nil {
+				t.Fatalf("CanDeleteShare 返回错误: %v", err)
+			}
+			if ok != tt.wantDelete {
+				t.Errorf("CanDeleteShare(%q, %q) = %v, want %v", tt.userId, tt.shareId, ok, tt.wantDelete)
+			}
+		})
+	}
+
+	if _, _, err := CanApproveShare(db, "owner", "missing"); !errors.Is(err, ErrShareNotFound) {
+		t.Errorf("记录不存在时应返回 ErrShareNotFound, got %v", err)
+	}
+}
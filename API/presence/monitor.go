@@ -0,0 +1,111 @@
+package presence
+
+import (
+	"log"
+	"sloth-tracker/api/events"
+	"sloth-tracker/api/model"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Touch 记录设备上报, 状态由其他状态变为在线时记录状态变化
+func Touch(db *gorm.DB, device *model.Device, now time.Time) error {
+	updates := map[string]any{"last_seen_at": now}
+	previous := stored(device)
+	if previous != Online {
+		updates["presence"] = string(Online)
+	}
+	if err := db.Model(device).Updates(updates).Error; err != nil {
+		return err
+	}
+	if previous != Online {
+		return record(db, device.Id, previous, Online, now)
+	}
+	return nil
+}
+
+// Check 重新计算所有设备的在线状态并记录变化
+func Check(db *gorm.DB, opts Options, now time.Time) error {
+	var devices []model.Device
+	if err := db.Select("id", "last_seen_at", "presence").Find(&devices).Error; err != nil {
+		return err
+	}
+	for _, device := range devices {
+		state, _ := Of(device.LastSeenAt, now, opts)
+		previous := stored(&device)
+		if state == previous {
+			continue
+		}
+		// 仅在状态与上报时间均未被并发修改时更新, 避免覆盖刚上报的在线状态
+		query := db.Model(&model.Device{}).Where("id = ? AND presence = ?", device.Id, device.Presence)
+		if device.LastSeenAt == nil {
+			query = query.Where("last_seen_at IS NULL")
+		} else {
+			query = query.Where("last_seen_at = ?", *device.LastSeenAt)
+		}
+		result := query.Update("presence", string(state))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		if err := record(db, device.Id, previous, state, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start 在后台定期检测在线状态变化
+func Start(db *gorm.DB, opts Options) {
+	if opts.CheckInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(opts.CheckInterval)
+		defer ticker.Stop()
+		lastPrune := time.Time{}
+		for now := range ticker.C {
+			if err := Check(db, opts, now); err != nil {
+				log.Printf("⚠️ 在线状态检测失败: %v", err)
+			}
+			if opts.Retention > 0 && now.Sub(lastPrune) >= time.Hour {
+				lastPrune = now
+				if err := Prune(db, now.Add(-opts.Retention)); err != nil {
+					log.Printf("⚠️ 清理在线状态记录失败: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Prune 删除早于 before 的状态变化记录
+func Prune(db *gorm.DB, before time.Time) error {
+	return db.Where("created_at < ?", before).Delete(&model.PresenceEvent{}).Error
+}
+
+// 设备上次记录的状态, 从未记录时视为离线
+func stored(device *model.Device) State {
+	if device.Presence == "" {
+		return Offline
+	}
+	return State(device.Presence)
+}
+
+// 记录状态变化
+func record(db *gorm.DB, deviceId string, from, to State, now time.Time) error {
+	if err := db.Create(&model.PresenceEvent{
+		Id:        uuid.New().String(),
+		DeviceId:  deviceId,
+		From:      string(from),
+		To:        string(to),
+		CreatedAt: now,
+	}).Error; err != nil {
+		return err
+	}
+	events.Publish(events.PresenceChanged{DeviceId: deviceId, From: string(from), To: string(to)})
+	return nil
+}
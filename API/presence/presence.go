@@ -0,0 +1,38 @@
+package presence
+
+import (
+	"time"
+)
+
+// State 设备在线状态
+type State string
+
+const (
+	Online  State = "online"  // 在心跳超时时间内有上报
+	Stale   State = "stale"   // 超过心跳超时时间, 但未到离线时间
+	Offline State = "offline" // 超过离线时间或从未上报
+)
+
+// Options 在线状态判定参数
+type Options struct {
+	HeartbeatTimeout time.Duration // 超过该时间未上报视为 stale
+	OfflineTimeout   time.Duration // 超过该时间未上报视为 offline
+	CheckInterval    time.Duration // 后台检测状态变化的间隔(0 表示不启动)
+	Retention        time.Duration // 状态变化记录的保留时长(0 表示永久保留)
+}
+
+// Of 根据最近上报时间计算在线状态与距今时长, 从未上报时 age 为 -1
+func Of(lastSeen *time.Time, now time.Time, opts Options) (State, time.Duration) {
+	if lastSeen == nil || lastSeen.IsZero() {
+		return Offline, -1
+	}
+	age := max(now.Sub(*lastSeen), 0)
+	switch {
+	case age <= opts.HeartbeatTimeout:
+		return Online, age
+	case age <= opts.OfflineTimeout:
+		return Stale, age
+	default:
+		return Offline, age
+	}
+}
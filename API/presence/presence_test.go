@@ -0,0 +1,130 @@
+package presence
+
+import (
+	"sloth-tracker/api/internal/testdb"
+	"sloth-tracker/api/model"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var opts = Options{HeartbeatTimeout: time.Minute, OfflineTimeout: 10 * time.Minute}
+
+func TestOf(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		seen := now.Add(-d)
+		return &seen
+	}
+
+	tests := []struct {
+		name     string
+		lastSeen *time.Time
+		want     State
+	}{
+		{"从未上报", nil, Offline},
+		{"刚刚上报", at(0), Online},
+		{"心跳超时边界", at(time.Minute), Online},
+		{"超过心跳超时", at(time.Minute + time.Second), Stale},
+		{"离线边界", at(10 * time.Minute), Stale},
+		{"超过离线时间", at(time.Hour), Offline},
+		{"客户端时间超前", at(-time.Minute), Online},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := Of(tt.lastSeen, now, opts); got != tt.want {
+				t.Errorf("Of() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	db := testdb.Open(t, &model.Device{}, &model.PresenceEvent{})
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	device := model.Device{Id: "device", RegisteredAt: now}
+	db.Create(&device)
+
+	// 从未上报的设备不产生状态变化
+	if err := Check(db, opts, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Touch(db, &device, now); err != nil {
+		t.Fatal(err)
+	}
+	db.First(&device, "id = ?", "device")
+	// 持续在线时再次上报不重复记录
+	if err := Touch(db, &device, now.Add(30*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []time.Duration{time.Minute, 5 * time.Minute, 6 * time.Minute, time.Hour} {
+		if err := Check(db, opts, now.Add(d)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var events []model.PresenceEvent
+	db.Order("created_at ASC").Find(&events)
+	want := [][2]string{{"offline", "online"}, {"online", "stale"}, {"stale", "offline"}}
+	if len(events) != len(want) {
+		t.Fatalf("状态变化记录数 = %d, want %d: %+v", len(events), len(want), events)
+	}
+	for i, event := range events {
+		if event.From != want[i][0] || event.To != want[i][1] {
+			t.Errorf("第 %d 条状态变化 = %s -> %s, want %s -> %s", i+1, event.From, event.To, want[i][0], want[i][1])
+		}
+	}
+}
+
+func TestCheckSkipsConcurrentTouch(t *testing.T) {
+	db := testdb.Open(t, &model.Device{}, &model.PresenceEvent{})
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	device := model.Device{Id: "device", RegisteredAt: now}
+	db.Create(&device)
+	if err := Touch(db, &device, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟 Check 读取设备列表后设备再次上报
+	touched := false
+	db.Callback().Query().After("gorm:query").Register("test:touch", func(tx *gorm.DB) {
+		if !touched && tx.Statement.Table == "devices" {
+			touched = true
+			tx.Session(&gorm.Session{NewDB: true}).Model(&model.Device{}).
+				Where("id = ?", device.Id).
+				Update("last_seen_at", now.Add(5*time.Minute))
+		}
+	})
+	defer db.Callback().Query().Remove("test:touch")
+
+	if err := Check(db, opts, now.Add(5*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	db.First(&device, "id = ?", device.Id)
+	if device.Presence != string(Online) {
+		t.Errorf("并发上报后状态 = %s, 期望 online", device.Presence)
+	}
+
+	// 未被并发修改时正常更新
+	if err := Check(db, opts, now.Add(7*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	db.First(&device, "id = ?", device.Id)
+	if device.Presence != string(Stale) {
+		t.Errorf("状态 = %s, 期望 stale", device.Presence)
+	}
+
+	// 清理早于保留时长的状态变化记录
+	if err := Prune(db, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	var remaining []model.PresenceEvent
+	db.Find(&remaining)
+	if len(remaining) != 1 || remaining[0].To != string(Stale) {
+		t.Errorf("清理后剩余 %+v, 期望只剩 online -> stale", remaining)
+	}
+}
@@ -0,0 +1,108 @@
+package rollup
+
+import (
+	"fmt"
+	"sloth-tracker/api/model"
+)
+
+// NumericStats 数值字段的统计
+type NumericStats struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+	Count int64   `json:"count"` // 参与统计的样本数, 用于合并时加权
+}
+
+// Aggregate 一个时间桶内的聚合结果
+type Aggregate struct {
+	Numeric map[string]*NumericStats    `json:"numeric"` // 字段路径 -> 统计值
+	States  map[string]map[string]int64 `json:"states"`  // 字段路径 -> 状态值 -> 持续时间(毫秒)
+}
+
+func newAggregate() *Aggregate {
+	return &Aggregate{
+		Numeric: make(map[string]*NumericStats),
+		States:  make(map[string]map[string]int64),
+	}
+}
+
+// 记录一个数值样本
+func (a *Aggregate) addNumeric(path string, value float64) {
+	a.mergeNumeric(path, NumericStats{Min: value, Max: value, Avg: value, Count: 1})
+}
+
+// 合并数值统计(按样本数加权平均)
+func (a *Aggregate) mergeNumeric(path string, other NumericStats) {
+	if other.Count == 0 {
+		return
+	}
+	stats, ok := a.Numeric[path]
+	if !ok {
+		copied := other
+		a.Numeric[path] = &copied
+		return
+	}
+	total := stats.Count + other.Count
+	stats.Avg = (stats.Avg*float64(stats.Count) + other.Avg*float64(other.Count)) / float64(total)
+	stats.Min = min(stats.Min, other.Min)
+	stats.Max = max(stats.Max, other.Max)
+	stats.Count = total
+}
+
+// 累加状态持续时间
+func (a *Aggregate) addState(path, state string, ms int64) {
+	if ms <= 0 {
+		return
+	}
+	states, ok := a.States[path]
+	if !ok {
+		states = make(map[string]int64)
+		a.States[path] = states
+	}
+	states[state] += ms
+}
+
+// 合并另一个聚合结果
+func (a *Aggregate) merge(other *Aggregate) {
+	for path, stats := range other.Numeric {
+		a.mergeNumeric(path, *stats)
+	}
+	for path, states := range other.States {
+		for state, ms := range states {
+			a.addState(path, state, ms)
+		}
+	}
+}
+
+// 将数据库中的值转换为数值
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// 将数据库中的值转换为状态名
+func toState(value any) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// 参与聚合的字段(排除文本字段)
+func aggregatedFields() []model.StatusField {
+	var fields []model.StatusField
+	for _, field := range model.StatusFields {
+		if field.Kind != model.TextField {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
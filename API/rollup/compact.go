@@ -0,0 +1,405 @@
+package rollup
+
+import (
+	"encoding/json"
+	"log"
+	"sloth-tracker/api/model"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Resolution 聚合粒度
+type Resolution struct {
+	Name   string
+	Size   time.Duration
+	window int // 每次最多处理的时间桶数量, 避免一次读取过多数据
+}
+
+var (
+	Minute = Resolution{"minute", time.Minute, 24 * 60}
+	Hour   = Resolution{"hour", time.Hour, 31 * 24}
+	Day    = Resolution{"day", 24 * time.Hour, 366}
+
+	// 由细到粗排列, 分钟由原始数据计算, 其余由上一级聚合合并
+	Resolutions = []Resolution{Minute, Hour, Day}
+)
+
+// ParseResolution 按名称查找聚合粒度
+func ParseResolution(name string) (Resolution, bool) {
+	for _, res := range Resolutions {
+		if res.Name == name {
+			return res, true
+		}
+	}
+	return Resolution{}, false
+}
+
+// Options 保留策略与压缩参数, 保留时长为 0 表示永久保留
+type Options struct {
+	RawRetention    time.Duration // 原始数据保留时长
+	MinuteRetention time.Duration // 分钟聚合保留时长
+	HourRetention   time.Duration // 小时聚合保留时长
+	DayRetention    time.Duration // 天聚合保留时长
+	Interval        time.Duration // 压缩任务执行间隔(0 表示不启动)
+	MaxGap          time.Duration // 单次上报最多代表的时长, 超出部分不计入状态持续时间
+}
+
+// Start 在后台定期执行压缩
+func Start(db *gorm.DB, opts Options) {
+	if opts.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(opts.Interval)
+		defer ticker.Stop()
+		for {
+			if err := Compact(db, opts, time.Now()); err != nil {
+				log.Printf("⚠️ 历史数据压缩失败: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Compact 为所有设备补齐已结束时间桶的聚合数据, 然后清理超出保留时长的数据
+func Compact(db *gorm.DB, opts Options, now time.Time) error {
+	var deviceIds []string
+	if err := db.Model(&model.Device{}).Pluck("id", &deviceIds).Error; err != nil {
+		return err
+	}
+
+	for _, deviceId := range deviceIds {
+		for i, res := range Resolutions {
+			var source *Resolution
+			if i > 0 {
+				source = &Resolutions[i-1]
+			}
+			if err := compactLevel(db, deviceId, res, source, now, opts.MaxGap); err != nil {
+				return err
+			}
+		}
+	}
+
+	return prune(db, opts, now)
+}
+
+// Refresh 重新计算 [from, to] 内已生成的聚合, 用于补传的历史数据(尚未聚合的部分由后台任务处理)
+func Refresh(db *gorm.DB, deviceId string, from, to int64, now time.Time, maxGap time.Duration) error {
+	gap := maxGap.Milliseconds()
+	for i, res := range Resolutions {
+		size := res.Size.Milliseconds()
+
+		var last []int64
+		if err := db.Model(&model.StatusRollup{}).
+			Where("device_id = ? AND resolution = ?", deviceId, res.Name).
+			Order("bucket_start DESC").Limit(1).
+			Pluck("bucket_start", &last).Error; err != nil {
+			return err
+		}
+		if len(last) == 0 {
+			continue
+		}
+
+		// 状态持续时间可能延续到 to 之后的时间桶; 不越过已聚合的最后一个时间桶
+		start := floor(from, size)
+		end := min(floor(to+gap, size)+size, floor(now.UnixMilli(), size), last[0]+size)
+		if start >= end {
+			continue
+		}
+
+		var buckets map[int64]*bucket
+		var err error
+		if i == 0 {
+			buckets, err = fromRaw(db, deviceId, start, end, size, gap)
+		} else {
+			buckets, err = fromRollups(db, deviceId, Resolutions[i-1], start, end, size)
+		}
+		if err != nil {
+			return err
+		}
+		if len(buckets) > 0 {
+			if err := save(db, deviceId, res, buckets); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 向下取整到时间桶起点
+func floor(ms, size int64) int64 {
+	return ms - ms%size
+}
+
+type bucket struct {
+	samples int64
+	agg     *Aggregate
+}
+
+// 补齐一个粒度的聚合数据, source 为空时从原始数据计算
+func compactLevel(db *gorm.DB, deviceId string, res Resolution, source *Resolution, now time.Time, maxGap time.Duration) error {
+	size := res.Size.Milliseconds()
+	gap := maxGap.Milliseconds()
+	boundary := floor(now.UnixMilli(), size) // 之前的时间桶均已结束
+
+	for {
+		start, ok, err := nextStart(db, deviceId, res, source, gap)
+		if err != nil {
+			return err
+		}
+		if !ok || start >= boundary {
+			return nil
+		}
+		end := min(boundary, start+int64(res.window)*size)
+
+		var buckets map[int64]*bucket
+		if source == nil {
+			buckets, err = fromRaw(db, deviceId, start, end, size, gap)
+		} else {
+			buckets, err = fromRollups(db, deviceId, *source, start, end, size)
+		}
+		if err != nil {
+			return err
+		}
+		if len(buckets) == 0 {
+			return nil
+		}
+		if err := save(db, deviceId, res, buckets); err != nil {
+			return err
+		}
+	}
+}
+
+// 计算下一个待聚合的时间桶起点, 没有新数据时 ok 为 false
+func nextStart(db *gorm.DB, deviceId string, res Resolution, source *Resolution, gap int64) (int64, bool, error) {
+	size := res.Size.Milliseconds()
+
+	// 已聚合到的最后一个时间桶
+	var last []int64
+	if err := db.Model(&model.StatusRollup{}).
+		Where("device_id = ? AND resolution = ?", deviceId, res.Name).
+		Order("bucket_start DESC").Limit(1).
+		Pluck("bucket_start", &last).Error; err != nil {
+		return 0, false, err
+	}
+	from := int64(0)
+	if len(last) > 0 {
+		from = last[0] + size
+	}
+
+	var first []int64
+	if source == nil {
+		// 上一次上报的状态可能延续到 from 之后, 此时从 from 开始
+		if len(last) > 0 && gap > 0 {
+			var count int64
+			if err := db.Model(&model.DeviceStatusHistory{}).
+				Where("device_id = ? AND timestamp > ? AND timestamp < ?", deviceId, from-gap, from).
+				Count(&count).Error; err != nil {
+				return 0, false, err
+			}
+			if count > 0 {
+				return from, true, nil
+			}
+		}
+		if err := db.Model(&model.DeviceStatusHistory{}).
+			Where("device_id = ? AND timestamp >= ?", deviceId, from).
+			Order("timestamp ASC").Limit(1).
+			Pluck("timestamp", &first).Error; err != nil {
+			return 0, false, err
+		}
+	} else {
+		if err := db.Model(&model.StatusRollup{}).
+			Where("device_id = ? AND resolution = ? AND bucket_start >= ?", deviceId, source.Name, from).
+			Order("bucket_start ASC").Limit(1).
+			Pluck("bucket_start", &first).Error; err != nil {
+			return 0, false, err
+		}
+	}
+	if len(first) == 0 {
+		return 0, false, nil
+	}
+	return floor(first[0], size), true, nil
+}
+
+// 由原始数据计算 [start, end) 内的分钟聚合
+func fromRaw(db *gorm.DB, deviceId string, start, end, size, gap int64) (map[int64]*bucket, error) {
+	fields := aggregatedFields()
+	columns := []string{"timestamp"}
+	for _, field := range fields {
+		columns = append(columns, field.Column)
+	}
+
+	var rows []map[string]any
+	if err := db.Model(&model.DeviceStatusHistory{}).Select(columns).
+		Where("device_id = ? AND timestamp >= ? AND timestamp < ?", deviceId, start, end).
+		Order("timestamp ASC").
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	// 窗口前最后一次上报, 其状态可能延续到窗口内
+	var prev []map[string]any
+	if err := db.Model(&model.DeviceStatusHistory{}).Select(columns).
+		Where("device_id = ? AND timestamp > ? AND timestamp < ?", deviceId, start-gap, start).
+		Order("timestamp DESC").Limit(1).
+		Find(&prev).Error; err != nil {
+		return nil, err
+	}
+	rows = append(prev, rows...)
+
+	// 窗口后第一次上报, 用于确定最后一个状态的结束时间
+	var next []int64
+	if err := db.Model(&model.DeviceStatusHistory{}).
+		Where("device_id = ? AND timestamp >= ?", deviceId, end).
+		Order("timestamp ASC").Limit(1).
+		Pluck("timestamp", &next).Error; err != nil {
+		return nil, err
+	}
+
+	buckets := make(map[int64]*bucket)
+	get := func(key int64) *bucket {
+		b, ok := buckets[key]
+		if !ok {
+			b = &bucket{agg: newAggregate()}
+			buckets[key] = b
+		}
+		return b
+	}
+
+	for i, row := range rows {
+		ts, _ := row["timestamp"].(int64)
+
+		// 状态持续到下一次上报, 但不超过 gap 与窗口结束时间
+		stop := end
+		if i+1 < len(rows) {
+			stop = rows[i+1]["timestamp"].(int64)
+		} else if len(next) > 0 {
+			stop = next[0]
+		}
+		stop = min(stop, ts+gap, end)
+
+		if ts >= start {
+			b := get(floor(ts, size))
+			b.samples++
+			for _, field := range fields {
+				if field.Kind != model.NumericField {
+					continue
+				}
+				if value, ok := toFloat(row[field.Column]); ok {
+					b.agg.addNumeric(field.Path, value)
+				}
+			}
+		}
+
+		// 按时间桶切分状态持续时间
+		for from := max(ts, start); from < stop; {
+			key := floor(from, size)
+			to := min(stop, key+size)
+			b := get(key)
+			for _, field := range fields {
+				if field.Kind == model.StateField {
+					b.agg.addState(field.Path, toState(row[field.Column]), to-from)
+				}
+			}
+			from = to
+		}
+	}
+	return buckets, nil
+}
+
+// 由更细粒度的聚合合并 [start, end) 内的聚合
+func fromRollups(db *gorm.DB, deviceId string, source Resolution, start, end, size int64) (map[int64]*bucket, error) {
+	var rows []model.StatusRollup
+	if err := db.Where("device_id = ? AND resolution = ? AND bucket_start >= ? AND bucket_start < ?",
+		deviceId, source.Name, start, end).
+		Order("bucket_start ASC").
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	buckets := make(map[int64]*bucket)
+	for _, row := range rows {
+		agg, err := Decode(row.Data)
+		if err != nil {
+			return nil, err
+		}
+		key := floor(row.BucketStart, size)
+		b, ok := buckets[key]
+		if !ok {
+			b = &bucket{agg: newAggregate()}
+			buckets[key] = b
+		}
+		b.samples += row.Samples
+		b.agg.merge(agg)
+	}
+	return buckets, nil
+}
+
+// 写入聚合结果, 已存在的时间桶会被覆盖
+func save(db *gorm.DB, deviceId string, res Resolution, buckets map[int64]*bucket) error {
+	now := time.Now()
+	rows := make([]model.StatusRollup, 0, len(buckets))
+	for start, b := range buckets {
+		data, err := json.Marshal(b.agg)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, model.StatusRollup{
+			Id:          uuid.New().String(),
+			DeviceId:    deviceId,
+			Resolution:  res.Name,
+			BucketStart: start,
+			Samples:     b.samples,
+			Data:        string(data),
+			UpdatedAt:   now,
+		})
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "device_id"}, {Name: "resolution"}, {Name: "bucket_start"}},
+		DoUpdates: clause.AssignmentColumns([]string{"samples", "data", "updated_at"}),
+	}).CreateInBatches(rows, 200).Error
+}
+
+// Decode 解析存储的聚合结果
+func Decode(data string) (*Aggregate, error) {
+	agg := newAggregate()
+	if err := json.Unmarshal([]byte(data), agg); err != nil {
+		return nil, err
+	}
+	return agg, nil
+}
+
+// 清理超出保留时长的数据, 尚未合并到上一级的数据不会被清理;
+// 原始数据按 timestamp 单独建立的索引删除, 不必扫描全表
+func prune(db *gorm.DB, opts Options, now time.Time) error {
+	ms := now.UnixMilli()
+
+	if opts.RawRetention > 0 {
+		cutoff := min(ms-opts.RawRetention.Milliseconds(), floor(ms, Minute.Size.Milliseconds())-opts.MaxGap.Milliseconds())
+		if err := db.Where("timestamp < ?", cutoff).Delete(&model.DeviceStatusHistory{}).Error; err != nil {
+			return err
+		}
+		if err := db.Where("timestamp < ?", cutoff).Delete(&model.MetricSample{}).Error; err != nil {
+			return err
+		}
+	}
+
+	retentions := []time.Duration{opts.MinuteRetention, opts.HourRetention, opts.DayRetention}
+	for i, res := range Resolutions {
+		if retentions[i] <= 0 {
+			continue
+		}
+		cutoff := ms - retentions[i].Milliseconds()
+		if i+1 < len(Resolutions) {
+			cutoff = min(cutoff, floor(ms, Resolutions[i+1].Size.Milliseconds()))
+		}
+		if err := db.Where("resolution = ? AND bucket_start < ?", res.Name, cutoff).
+			Delete(&model.StatusRollup{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package rollup
+
+import (
+	"math"
+	"sloth-tracker/api/internal/testdb"
+	"sloth-tracker/api/model"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 测试基准时间(UTC 整点)
+var base = time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+func setupDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := testdb.Open(t, &model.Device{}, &model.DeviceStatusHistory{}, &model.StatusRollup{}, &model.MetricSample{})
+	db.Create(&model.Device{Id: "device", OwnerId: "owner", RegisteredAt: base})
+	return db
+}
+
+// 写入一条原始上报
+func report(t *testing.T, db *gorm.DB, at time.Duration, level, screenOn int) {
+	t.Helper()
+	row := model.DeviceStatusHistory{
+		Id:        at.String(),
+		DeviceId:  "device",
+		Timestamp: base.Add(at).UnixMilli(),
+		Battery:   model.BatteryStatus{Level: level},
+		Other:     model.OtherStatus{ScreenOn: screenOn},
+	}
+	if err := db.Create(&row).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 读取一个时间桶的聚合结果
+func load(t *testing.T, db *gorm.DB, res Resolution, at time.Duration) (*model.StatusRollup, *Aggregate) {
+	t.Helper()
+	var row model.StatusRollup
+	if err := db.Where("resolution = ? AND bucket_start = ?", res.Name, base.Add(at).UnixMilli()).First(&row).Error; err != nil {
+		t.Fatalf("%s 时间桶 %s 不存在: %v", res.Name, at, err)
+	}
+	agg, err := Decode(row.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &row, agg
+}
+
+func TestCompact(t *testing.T) {
+	db := setupDB(t)
+	opts := Options{MaxGap: time.Minute}
+
+	// 10:00:00 屏幕点亮, 10:00:30 熄灭, 10:01:30 点亮, 之后停止上报
+	report(t, db, 0, 80, 1)
+	report(t, db, 30*time.Second, 70, 2)
+	report(t, db, 90*time.Second, 60, 1)
+
+	if err := Compact(db, opts, base.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	row, agg := load(t, db, Minute, 0)
+	if row.Samples != 2 {
+		t.Errorf("10:00 样本数 = %d, want 2", row.Samples)
+	}
+	if got := *agg.Numeric["battery.level"]; got.Min != 70 || got.Max != 80 || got.Avg != 75 || got.Count != 2 {
+		t.Errorf("10:00 电量统计 = %+v", got)
+	}
+	if got := agg.States["other.screen_on"]; got["1"] != 30_000 || got["2"] != 30_000 {
+		t.Errorf("10:00 屏幕状态 = %v", got)
+	}
+
+	// 10:00:30 的熄灭状态延续到 10:01:30, 最后一次上报最多代表 MaxGap
+	_, agg = load(t, db, Minute, time.Minute)
+	if got := agg.States["other.screen_on"]; got["2"] != 30_000 || got["1"] != 30_000 {
+		t.Errorf("10:01 屏幕状态 = %v", got)
+	}
+	_, agg = load(t, db, Minute, 2*time.Minute)
+	if got := agg.States["other.screen_on"]; got["1"] != 30_000 {
+		t.Errorf("10:02 屏幕状态 = %v", got)
+	}
+
+	// 小时聚合由分钟聚合合并
+	row, agg = load(t, db, Hour, 0)
+	if row.Samples != 3 {
+		t.Errorf("10 点样本数 = %d, want 3", row.Samples)
+	}
+	if got := *agg.Numeric["battery.level"]; got.Min != 60 || got.Max != 80 || math.Abs(got.Avg-70) > 1e-9 {
+		t.Errorf("10 点电量统计 = %+v", got)
+	}
+	if got := agg.States["other.screen_on"]; got["1"] != 90_000 || got["2"] != 60_000 {
+		t.Errorf("10 点屏幕状态 = %v", got)
+	}
+
+	// 当天尚未结束, 不生成天聚合
+	var days int64
+	db.Model(&model.StatusRollup{}).Where("resolution = ?", Day.Name).Count(&days)
+	if days != 0 {
+		t.Errorf("天聚合数量 = %d, want 0", days)
+	}
+}
+
+func TestCompactIncremental(t *testing.T) {
+	db := setupDB(t)
+	opts := Options{MaxGap: time.Minute}
+
+	report(t, db, 0, 50, 1)
+	if err := Compact(db, opts, base.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 之后的上报只会生成新的时间桶, 已结束的时间桶不重复计算
+	report(t, db, 10*time.Minute, 40, 1)
+	if err := Compact(db, opts, base.Add(11*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	var minutes int64
+	db.Model(&model.StatusRollup{}).Where("resolution = ?", Minute.Name).Count(&minutes)
+	if minutes != 2 {
+		t.Errorf("分钟聚合数量 = %d, want 2", minutes)
+	}
+	row, _ := load(t, db, Minute, 10*time.Minute)
+	if row.Samples != 1 {
+		t.Errorf("10:10 样本数 = %d, want 1", row.Samples)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	db := setupDB(t)
+	opts := Options{
+		RawRetention:    24 * time.Hour,
+		MinuteRetention: 48 * time.Hour,
+		MaxGap:          time.Minute,
+	}
+
+	report(t, db, 0, 50, 1)
+	report(t, db, 72*time.Hour, 40, 1)
+	if err := Compact(db, opts, base.Add(73*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	var raw, minutes, hours int64
+	db.Model(&model.DeviceStatusHistory{}).Count(&raw)
+	db.Model(&model.StatusRollup{}).Where("resolution = ?", Minute.Name).Count(&minutes)
+	db.Model(&model.StatusRollup{}).Where("resolution = ?", Hour.Name).Count(&hours)
+	if raw != 1 {
+		t.Errorf("原始数据数量 = %d, want 1", raw)
+	}
+	if minutes != 1 {
+		t.Errorf("分钟聚合数量 = %d, want 1", minutes)
+	}
+	if hours != 2 {
+		t.Errorf("小时聚合数量 = %d, want 2", hours)
+	}
+}
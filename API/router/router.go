@@ -1,44 +1,117 @@
 package router
 
 import (
+	"log"
 	"net/http"
+	"sloth-tracker/api/config"
 	"sloth-tracker/api/controller"
+	"sloth-tracker/api/limiter"
 	"sloth-tracker/api/middleware"
+	"sloth-tracker/api/oidc"
+	"sloth-tracker/api/webhooks"
 	"strings"
 )
 
-func SetupRouter(db any) http.Handler {
+func SetupRouter(db any, dispatcher *webhooks.Dispatcher) http.Handler {
 	mux := http.NewServeMux()
+	authed := middleware.Auth(db)
+	streamAuthed := middleware.StreamAuth(db)
+	guarded := middleware.BruteForce(newPasswordGuard())
+	admin := middleware.Admin(db)
 
 	// 基础路由
 	mux.HandleFunc("GET /api/ping", controller.Ping(db))
 
 	// 用户相关路由
 	mux.HandleFunc("POST /api/user/register", controller.RegisterUser(db))
-	mux.HandleFunc("POST /api/user/login", controller.LoginUser(db))
-	mux.HandleFunc("PUT /api/user/reset_name", controller.ResetUsername(db))
-	mux.HandleFunc("PUT /api/user/reset_password", controller.ResetPassword(db))
-	mux.HandleFunc("GET /api/user/info", controller.GetUserInfo(db))
-	mux.HandleFunc("DELETE /api/user/delete", controller.DeleteUser(db))
+	mux.Handle("POST /api/user/login", guarded(controller.LoginUser(db)))
+	mux.Handle("POST /api/user/login/2fa", guarded(controller.LoginTwoFactor(db)))
+	mux.HandleFunc("POST /api/user/refresh", controller.RefreshSession(db))
+	mux.Handle("POST /api/user/logout", authed(controller.Logout(db)))
+	mux.Handle("POST /api/user/logout_all", authed(controller.LogoutAll(db)))
+	mux.Handle("POST /api/user/logout_others", authed(controller.LogoutOthers(db)))
+	mux.Handle("GET /api/user/sessions", authed(controller.GetSessions(db)))
+	mux.Handle("DELETE /api/user/session", authed(controller.RevokeSession(db)))
+	mux.Handle("POST /api/user/2fa/setup", authed(controller.SetupTwoFactor(db)))
+	mux.Handle("POST /api/user/2fa/enable", authed(guarded(controller.EnableTwoFactor(db))))
+	mux.Handle("POST /api/user/2fa/disable", authed(guarded(controller.DisableTwoFactor(db))))
+	mux.Handle("PUT /api/user/reset_name", authed(controller.ResetUsername(db)))
+	mux.Handle("PUT /api/user/timezone", authed(controller.SetTimezone(db)))
+	mux.Handle("PUT /api/user/reset_password", authed(guarded(controller.ResetPassword(db))))
+	mux.Handle("POST /api/user/recover", guarded(controller.RecoverAccount(db)))
+	mux.Handle("POST /api/user/recovery_codes", authed(guarded(controller.RegenerateRecoveryCodes(db))))
+	mux.Handle("GET /api/user/info", authed(controller.GetUserInfo(db)))
+	mux.Handle("DELETE /api/user/delete", authed(guarded(controller.DeleteUser(db))))
+
+	// OIDC 登录路由(配置了身份提供方时启用)
+	if provider := newOIDCProvider(); provider != nil {
+		mux.HandleFunc("GET /api/oidc/login", controller.OIDCLogin(db, provider))
+		mux.HandleFunc("GET /api/oidc/callback", controller.OIDCCallback(db, provider))
+		mux.Handle("POST /api/oidc/link", authed(guarded(controller.OIDCLink(db, provider))))
+		mux.Handle("POST /api/oidc/reauth", authed(controller.OIDCReauth(db, provider)))
+	}
 
 	// 共享相关路由
-	mux.HandleFunc("POST /api/share/apply", controller.ApplyShare(db))
-	mux.HandleFunc("GET /api/share/applications", controller.GetUserApplications(db))
-	mux.HandleFunc("GET /api/share/authorizations", controller.GetSharedAuthorizations(db))
-	mux.HandleFunc("PUT /api/share/authorize", controller.AuthorizeDevice(db))
-	mux.HandleFunc("DELETE /api/share/delete", controller.DeleteShare(db))
+	mux.Handle("POST /api/share/apply", authed(controller.ApplyShare(db)))
+	mux.Handle("GET /api/share/applications", authed(controller.GetUserApplications(db)))
+	mux.Handle("GET /api/share/authorizations", authed(controller.GetSharedAuthorizations(db)))
+	mux.Handle("PUT /api/share/authorize", authed(controller.AuthorizeDevice(db)))
+	mux.Handle("DELETE /api/share/delete", authed(controller.DeleteShare(db)))
 
 	// 设备相关路由
-	mux.HandleFunc("POST /api/device/register", controller.RegisterDevice(db))
-	mux.HandleFunc("PUT /api/device/update", controller.UpdateDeviceInfo(db))
-	mux.HandleFunc("GET /api/devices/list", controller.GetDeviceList(db))
-	mux.HandleFunc("GET /api/devices/shared", controller.GetSharedDeviceList(db))
-	mux.HandleFunc("GET /api/device/info", controller.GetDeviceInfo(db))
-	mux.HandleFunc("DELETE /api/device/delete", controller.DeleteDevice(db))
+	mux.Handle("POST /api/device/register", authed(controller.RegisterDevice(db)))
+	mux.Handle("PUT /api/device/update", authed(controller.UpdateDeviceInfo(db)))
+	mux.Handle("GET /api/devices/list", authed(controller.GetDeviceList(db)))
+	mux.Handle("GET /api/devices/shared", authed(controller.GetSharedDeviceList(db)))
+	mux.Handle("GET /api/device/info", authed(controller.GetDeviceInfo(db)))
+	mux.Handle("DELETE /api/device/delete", authed(controller.DeleteDevice(db)))
+	mux.Handle("PUT /api/device/rotate_secret", authed(controller.RotateDeviceSecret(db)))
+	mux.Handle("PUT /api/device/revoke_secret", authed(controller.RevokeDeviceSecret(db)))
+	mux.Handle("GET /api/device/presence_events", authed(controller.GetPresenceEvents(db)))
+	mux.Handle("GET /api/device/connection", authed(controller.GetAgentConnection(db)))
+	mux.Handle("POST /api/device/message", authed(controller.SendAgentMessage(db)))
+	mux.Handle("GET /api/agent/ws", middleware.DeviceAuth(db)(controller.AgentConnect(db)))
 
 	// 状态相关路由
-	mux.HandleFunc("PUT /api/status/update", controller.UpdateStatus(db))
-	mux.HandleFunc("GET /api/status", controller.GetStatus(db))
+	mux.Handle("PUT /api/status/update", middleware.DeviceAuth(db)(controller.UpdateStatus(db)))
+	mux.Handle("PATCH /api/status/update", middleware.DeviceAuth(db)(controller.UpdateStatus(db)))
+	mux.Handle("POST /api/status/batch", middleware.DeviceAuth(db)(controller.UpdateStatusBatch(db)))
+	mux.Handle("GET /api/status", authed(controller.GetStatus(db)))
+	mux.Handle("GET /api/status/history", authed(controller.GetStatusHistory(db)))
+	mux.Handle("GET /api/status/stream", streamAuthed(controller.StreamStatus(db)))
+	mux.Handle("GET /api/status/stream/multi", streamAuthed(controller.StreamStatuses(db)))
+
+	// 远程命令路由
+	mux.Handle("POST /api/command/send", authed(controller.SendCommand(db)))
+	mux.Handle("GET /api/command/list", authed(controller.GetCommands(db)))
+	mux.Handle("POST /api/command/ack", middleware.DeviceAuth(db)(controller.AckCommand(db)))
+
+	// Webhook 路由
+	mux.Handle("POST /api/webhook/create", authed(controller.CreateWebhook(db)))
+	mux.Handle("GET /api/webhook/list", authed(controller.GetWebhooks(db)))
+	mux.Handle("PUT /api/webhook/update", authed(controller.UpdateWebhook(db)))
+	mux.Handle("DELETE /api/webhook/delete", authed(controller.DeleteWebhook(db)))
+	mux.Handle("GET /api/webhook/deliveries", authed(controller.GetWebhookDeliveries(db)))
+	mux.Handle("POST /api/webhook/test", authed(controller.TestWebhook(db, dispatcher)))
+
+	// 自定义指标路由
+	mux.Handle("GET /api/metrics", authed(controller.GetMetrics(db)))
+	mux.Handle("PUT /api/metrics/define", authed(controller.DefineMetric(db)))
+	mux.Handle("DELETE /api/metrics/delete", authed(controller.DeleteMetric(db)))
+	mux.Handle("GET /api/metrics/history", authed(controller.GetMetricHistory(db)))
+
+	// 使用统计路由
+	mux.Handle("GET /api/usage/apps", authed(controller.GetAppUsage(db)))
+	mux.Handle("GET /api/summary/daily", authed(controller.GetDailySummary(db)))
+	mux.Handle("GET /api/battery/analysis", authed(controller.GetBatteryAnalysis(db)))
+
+	// 管理相关路由
+	mux.Handle("GET /api/admin/users", authed(admin(controller.AdminListUsers(db))))
+	mux.Handle("PUT /api/admin/user/disable", authed(admin(controller.AdminDisableUser(db))))
+	mux.Handle("DELETE /api/admin/user/delete", authed(admin(controller.AdminDeleteUser(db))))
+	mux.Handle("GET /api/admin/devices", authed(admin(controller.AdminListDevices(db))))
+	mux.Handle("GET /api/admin/stats", authed(admin(controller.AdminStats(db))))
+	mux.Handle("DELETE /api/admin/share/revoke", authed(admin(controller.AdminRevokeShare(db))))
 
 	// 添加中间件
 	handler := middleware.CORS(mux)
@@ -47,6 +120,41 @@ func SetupRouter(db any) http.Handler {
 	return handler
 }
 
+// 创建密码校验限流器
+func newPasswordGuard() *limiter.Guard {
+	cfg := config.Get()
+	opts := limiter.Options{
+		FreeAttempts:     cfg.LoginFreeAttempts,
+		BaseDelay:        cfg.LoginBaseDelay,
+		MaxDelay:         cfg.LoginMaxDelay,
+		LockoutThreshold: cfg.LockoutThreshold,
+		LockoutDuration:  cfg.LockoutDuration,
+	}
+	ipOpts := opts
+	ipOpts.FreeAttempts = cfg.IPLockoutThreshold / 2
+	ipOpts.LockoutThreshold = cfg.IPLockoutThreshold
+	return &limiter.Guard{
+		IP:      limiter.New(ipOpts),
+		Account: limiter.New(opts),
+	}
+}
+
+// 创建 OIDC 身份提供方, 未配置时返回nil
+func newOIDCProvider() *oidc.Provider {
+	cfg := config.Get()
+	if cfg.OIDCIssuer == "" {
+		return nil
+	}
+	log.Printf("🔑 已启用 OIDC 登录: %s", cfg.OIDCIssuer)
+	return oidc.NewProvider(oidc.Options{
+		Issuer:       cfg.OIDCIssuer,
+		ClientId:     cfg.OIDCClientId,
+		ClientSecret: cfg.OIDCClientSecret,
+		RedirectURL:  cfg.OIDCRedirectURL,
+		Scopes:       cfg.OIDCScopes,
+	})
+}
+
 // 路径参数提取辅助函数
 func GetPathParam(r *http.Request, param string) string {
 	path := r.URL.Path
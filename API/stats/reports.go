@@ -0,0 +1,48 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// 按秒统计最近一分钟的状态上报次数
+var reports = &counter{}
+
+type counter struct {
+	mu      sync.Mutex
+	buckets [60]int64
+	seconds [60]int64 // 每个桶对应的秒级时间戳
+}
+
+// RecordReport 记录一次状态上报
+func RecordReport() {
+	reports.add(time.Now().Unix(), 1)
+}
+
+// ReportsPerMinute 最近一分钟的状态上报次数
+func ReportsPerMinute() int64 {
+	return reports.sum(time.Now().Unix())
+}
+
+func (c *counter) add(now, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := now % int64(len(c.buckets))
+	if c.seconds[i] != now {
+		c.seconds[i] = now
+		c.buckets[i] = 0
+	}
+	c.buckets[i] += n
+}
+
+func (c *counter) sum(now int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var total int64
+	for i, second := range c.seconds {
+		if now-second < int64(len(c.buckets)) {
+			total += c.buckets[i]
+		}
+	}
+	return total
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"errors"
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/password"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CreateAdmin 创建管理员账户; 用户名已存在时将其提升为管理员, 返回是否新建了账户
+func CreateAdmin(db *gorm.DB, name, plainPassword string) (bool, error) {
+	if name == "" {
+		return false, errors.New("用户名不能为空")
+	}
+
+	var users []model.User
+	if err := db.Where("name = ?", name).Limit(1).Find(&users).Error; err != nil {
+		return false, err
+	}
+	if len(users) > 0 {
+		return false, db.Model(&users[0]).Updates(map[string]any{
+			"is_admin": true,
+			"disabled": false,
+		}).Error
+	}
+
+	if plainPassword == "" {
+		return false, errors.New("创建账户需要提供密码")
+	}
+	if err := password.Default().Validate(plainPassword); err != nil {
+		return false, err
+	}
+	hashedPassword, err := password.Default().Hash(plainPassword)
+	if err != nil {
+		return false, err
+	}
+	return true, db.Create(&model.User{
+		Id:           uuid.New().String(),
+		Name:         name,
+		Password:     hashedPassword,
+		IsAdmin:      true,
+		RegisteredAt: time.Now(),
+	}).Error
+}
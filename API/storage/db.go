@@ -8,10 +8,10 @@ import (
 )
 
 func InitDB() *gorm.DB {
-	db, err := gorm.Open(sqlite.Open("sloth.db?_pragma=foreign_keys(1)"), &gorm.Config{})
+	db, err := gorm.Open(sqlite.Open("sloth.db?_pragma=foreign_keys(1)&_busy_timeout=5000"), &gorm.Config{})
 	if err != nil {
 		log.Fatal("数据库初始化失败:", err)
 	}
-	db.AutoMigrate(&model.User{}, &model.SharedDevice{}, &model.Device{}, &model.DeviceStatus{})
+	db.AutoMigrate(&model.User{}, &model.ExternalIdentity{}, &model.RecoveryCode{}, &model.AuditLog{}, &model.Session{}, &model.SharedDevice{}, &model.Device{}, &model.PresenceEvent{}, &model.DeviceStatus{}, &model.DeviceStatusHistory{}, &model.StatusRollup{}, &model.AppSession{}, &model.MetricDefinition{}, &model.MetricValue{}, &model.MetricSample{}, &model.DeviceCommand{}, &model.Webhook{}, &model.WebhookDelivery{})
 	return db
 }
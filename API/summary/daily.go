@@ -0,0 +1,159 @@
+package summary
+
+import (
+	"sloth-tracker/api/model"
+	"sloth-tracker/api/usage"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 摘要中返回的前台应用数量
+const topApps = 5
+
+// Range 数值字段的最小值与最大值
+type Range struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+func (r *Range) add(value float64) *Range {
+	if r == nil {
+		return &Range{Min: value, Max: value}
+	}
+	r.Min = min(r.Min, value)
+	r.Max = max(r.Max, value)
+	return r
+}
+
+// Network 连接过的 WiFi 网络及连接时长
+type Network struct {
+	SSId     string `json:"ssid"`
+	Duration int64  `json:"duration"` // 连接时长(毫秒)
+}
+
+// Daily 设备一天的状态摘要, 时长单位均为毫秒
+type Daily struct {
+	Samples            int64            `json:"samples"`             // 当天的上报次数
+	ScreenOn           int64            `json:"screen_on"`           // 亮屏时长
+	OnBattery          int64            `json:"on_battery"`          // 使用电池供电的时长
+	OnPower            int64            `json:"on_power"`            // 接通电源(充电中或已充满)的时长
+	BatteryLevel       *Range           `json:"battery_level"`       // 电量范围(无数据时为 null)
+	BatteryTemperature *Range           `json:"battery_temperature"` // 电池温度范围(无数据时为 null)
+	ChargeSessions     int              `json:"charge_sessions"`     // 充电次数(接通电源的次数, 包括跨越当天开始时刻的一次)
+	Wifi               []Network        `json:"wifi"`                // 连接过的 WiFi 网络, 按时长降序
+	UploadMB           float64          `json:"upload_mb"`           // 按上传速度估算的上传量(MB)
+	DownloadMB         float64          `json:"download_mb"`         // 按下载速度估算的下载量(MB)
+	TopApps            []usage.AppTotal `json:"top_apps"`            // 使用时长最多的前台应用
+}
+
+// 是否接通电源; 未上报电池状态时返回 ok=false
+func plugged(battery model.BatteryStatus) (on bool, ok bool) {
+	switch battery.Charging {
+	case 1, 3:
+		return true, true
+	case 2:
+		return false, true
+	}
+	return false, false
+}
+
+// Compute 由 [from, to) 内的历史状态计算摘要. 每次上报的状态持续到下一次上报,
+// 但不超过 gap; 当天开始前的最后一次上报会延续到当天
+func Compute(db *gorm.DB, deviceId string, from, to int64, gap time.Duration) (*Daily, error) {
+	var rows []model.DeviceStatusHistory
+	if err := db.Where("device_id = ? AND timestamp >= ? AND timestamp < ?", deviceId, from, to).
+		Order("timestamp ASC").
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	// 当天开始前的最后一次上报
+	var prev []model.DeviceStatusHistory
+	if err := db.Where("device_id = ? AND timestamp > ? AND timestamp < ?", deviceId, from-gap.Milliseconds(), from).
+		Order("timestamp DESC").Limit(1).
+		Find(&prev).Error; err != nil {
+		return nil, err
+	}
+	rows = append(prev, rows...)
+
+	// 当天结束后的第一次上报, 用于确定最后一个状态的结束时间
+	var next []int64
+	if err := db.Model(&model.DeviceStatusHistory{}).
+		Where("device_id = ? AND timestamp >= ?", deviceId, to).
+		Order("timestamp ASC").Limit(1).
+		Pluck("timestamp", &next).Error; err != nil {
+		return nil, err
+	}
+
+	daily := &Daily{Wifi: []Network{}}
+	wifi := make(map[string]int64)
+	wasPlugged := false
+	for i, row := range rows {
+		stop := to
+		if i+1 < len(rows) {
+			stop = rows[i+1].Timestamp
+		} else if len(next) > 0 {
+			stop = next[0]
+		}
+		stop = min(stop, row.Timestamp+gap.Milliseconds(), to)
+		duration := max(stop-max(row.Timestamp, from), 0)
+
+		// 当天开始前的上报只在延续到当天时计入
+		if row.Timestamp < from {
+			if duration == 0 {
+				continue
+			}
+		} else {
+			daily.Samples++
+		}
+
+		if on, ok := plugged(row.Battery); ok {
+			if row.Timestamp >= from {
+				daily.BatteryLevel = daily.BatteryLevel.add(float64(row.Battery.Level))
+				if row.Battery.Temperature != 0 {
+					daily.BatteryTemperature = daily.BatteryTemperature.add(row.Battery.Temperature)
+				}
+			}
+			if on {
+				daily.OnPower += duration
+			} else {
+				daily.OnBattery += duration
+			}
+			if on && !wasPlugged {
+				daily.ChargeSessions++
+			}
+			wasPlugged = on
+		}
+
+		if row.Other.ScreenOn == 1 {
+			daily.ScreenOn += duration
+		}
+		if row.Network.WifiConnected == 1 && row.Network.WifiSSId != "" {
+			wifi[row.Network.WifiSSId] += duration
+		}
+
+		// Kbps * 毫秒 -> MB
+		seconds := float64(duration) / 1000
+		daily.UploadMB += float64(row.Network.UploadSpeedKbps) * seconds / 8 / 1024
+		daily.DownloadMB += float64(row.Network.DownloadSpeedKbps) * seconds / 8 / 1024
+	}
+
+	for ssid, duration := range wifi {
+		daily.Wifi = append(daily.Wifi, Network{SSId: ssid, Duration: duration})
+	}
+	sort.Slice(daily.Wifi, func(i, j int) bool {
+		if daily.Wifi[i].Duration != daily.Wifi[j].Duration {
+			return daily.Wifi[i].Duration > daily.Wifi[j].Duration
+		}
+		return daily.Wifi[i].SSId < daily.Wifi[j].SSId
+	})
+
+	apps, _, err := usage.Summarize(db, deviceId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	daily.TopApps = apps[:min(len(apps), topApps)]
+	return daily, nil
+}
@@ -0,0 +1,113 @@
+package summary
+
+import (
+	"math"
+	"sloth-tracker/api/internal/testdb"
+	"sloth-tracker/api/model"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 测试基准时间(当天开始时刻)
+var base = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const gap = 5 * time.Minute
+
+func setupDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return testdb.Open(t, &model.DeviceStatusHistory{}, &model.AppSession{})
+}
+
+// 写入一条历史状态
+func report(t *testing.T, db *gorm.DB, at time.Duration, status model.DeviceStatusHistory) {
+	t.Helper()
+	status.Id = at.String()
+	status.DeviceId = "device"
+	status.Timestamp = base.Add(at).UnixMilli()
+	if err := db.Create(&status).Error; err != nil {
+		t.Fatalf("写入历史失败: %v", err)
+	}
+}
+
+func compute(t *testing.T, db *gorm.DB) *Daily {
+	t.Helper()
+	daily, err := Compute(db, "device", base.UnixMilli(), base.AddDate(0, 0, 1).UnixMilli(), gap)
+	if err != nil {
+		t.Fatalf("计算摘要失败: %v", err)
+	}
+	return daily
+}
+
+func minutes(d time.Duration) int64 {
+	return d.Milliseconds()
+}
+
+func TestComputeDurations(t *testing.T) {
+	db := setupDB(t)
+	home := model.NetworkStatus{WifiConnected: 1, WifiSSId: "home", DownloadSpeedKbps: 8 * 1024}
+	// 前一天最后一次上报延续到当天 2 分钟
+	report(t, db, -time.Minute, model.DeviceStatusHistory{
+		Battery: model.BatteryStatus{Charging: 1, Level: 40},
+		Other:   model.OtherStatus{ScreenOn: 1},
+		Network: home,
+	})
+	report(t, db, 2*time.Minute, model.DeviceStatusHistory{
+		Battery: model.BatteryStatus{Charging: 2, Level: 50, Temperature: 30},
+		Other:   model.OtherStatus{ScreenOn: 1},
+		Network: home,
+	})
+	report(t, db, 4*time.Minute, model.DeviceStatusHistory{
+		Battery: model.BatteryStatus{Charging: 2, Level: 45, Temperature: 35},
+		Other:   model.OtherStatus{ScreenOn: 2},
+		Network: model.NetworkStatus{WifiConnected: 1, WifiSSId: "office"},
+	})
+	// 超过间隔阈值后重新上报, 中间的空白不计入任何状态
+	report(t, db, 30*time.Minute, model.DeviceStatusHistory{
+		Battery: model.BatteryStatus{Charging: 1, Level: 44},
+		Other:   model.OtherStatus{ScreenOn: 1},
+	})
+
+	daily := compute(t, db)
+	if daily.Samples != 3 {
+		t.Errorf("上报次数 = %d, 期望 3", daily.Samples)
+	}
+	// 亮屏: 0..4 与 30..35
+	if daily.ScreenOn != minutes(9*time.Minute) {
+		t.Errorf("亮屏时长 = %d", daily.ScreenOn)
+	}
+	// 电池供电: 2..4 与 4..9
+	if daily.OnBattery != minutes(7*time.Minute) {
+		t.Errorf("电池供电时长 = %d", daily.OnBattery)
+	}
+	// 接通电源: 0..2 与 30..35
+	if daily.OnPower != minutes(7*time.Minute) {
+		t.Errorf("接通电源时长 = %d", daily.OnPower)
+	}
+	if daily.ChargeSessions != 2 {
+		t.Errorf("充电次数 = %d, 期望 2", daily.ChargeSessions)
+	}
+	if daily.BatteryLevel == nil || daily.BatteryLevel.Min != 44 || daily.BatteryLevel.Max != 50 {
+		t.Errorf("电量范围 = %+v", daily.BatteryLevel)
+	}
+	if daily.BatteryTemperature == nil || daily.BatteryTemperature.Min != 30 || daily.BatteryTemperature.Max != 35 {
+		t.Errorf("温度范围 = %+v", daily.BatteryTemperature)
+	}
+	if len(daily.Wifi) != 2 || daily.Wifi[0].SSId != "office" || daily.Wifi[0].Duration != minutes(5*time.Minute) ||
+		daily.Wifi[1].SSId != "home" || daily.Wifi[1].Duration != minutes(4*time.Minute) {
+		t.Errorf("WiFi = %+v", daily.Wifi)
+	}
+	// 8 Mbps 持续 4 分钟 = 240 MB
+	if math.Abs(daily.DownloadMB-240) > 1e-9 {
+		t.Errorf("下载量 = %v, 期望 240", daily.DownloadMB)
+	}
+}
+
+func TestComputeEmptyDay(t *testing.T) {
+	db := setupDB(t)
+	daily := compute(t, db)
+	if daily.Samples != 0 || daily.BatteryLevel != nil || len(daily.Wifi) != 0 || len(daily.TopApps) != 0 {
+		t.Errorf("空摘要 = %+v", daily)
+	}
+}
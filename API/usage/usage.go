@@ -0,0 +1,167 @@
+package usage
+
+import (
+	"errors"
+	"sloth-tracker/api/model"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Report 一次上报中与前台应用有关的字段
+type Report struct {
+	Timestamp int64  // 上报时间(毫秒)
+	AppName   string // 前台应用
+	AppTitle  string // 窗口标题
+	ScreenOff bool   // 屏幕是否熄灭
+}
+
+// NewReport 由状态中的前台应用与屏幕状态生成上报信息
+func NewReport(timestamp int64, foreground model.ForegroundStatus, other model.OtherStatus) Report {
+	return Report{
+		Timestamp: timestamp,
+		AppName:   foreground.AppName,
+		AppTitle:  foreground.AppTitle,
+		ScreenOff: other.ScreenOn == 2,
+	}
+}
+
+// Track 将一次上报合并到应用会话: 应用与标题不变且间隔不超过 gap 时延续当前会话,
+// 否则结束当前会话并开始新会话; 熄屏或没有前台应用时只结束当前会话.
+// 早于当前会话的上报会被忽略, 补传的旧数据通过 Rebuild 合并
+func Track(db *gorm.DB, deviceId string, report Report, gap time.Duration) error {
+	var last model.AppSession
+	err := db.Where("device_id = ?", deviceId).Order("started_at DESC").First(&last).Error
+	hasLast := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if hasLast && report.Timestamp < last.EndedAt {
+		return nil
+	}
+
+	active := report.AppName != "" && !report.ScreenOff
+	if hasLast && !last.Closed {
+		withinGap := report.Timestamp-last.EndedAt <= gap.Milliseconds()
+		if withinGap && active && last.AppName == report.AppName && last.AppTitle == report.AppTitle {
+			return db.Model(&last).Update("ended_at", report.Timestamp).Error
+		}
+
+		// 结束当前会话; 未中断时会话持续到本次上报
+		updates := map[string]any{"closed": true}
+		if withinGap {
+			updates["ended_at"] = report.Timestamp
+		}
+		if err := db.Model(&last).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+
+	if !active {
+		return nil
+	}
+	return db.Create(&model.AppSession{
+		Id:        uuid.New().String(),
+		DeviceId:  deviceId,
+		AppName:   report.AppName,
+		AppTitle:  report.AppTitle,
+		StartedAt: report.Timestamp,
+		EndedAt:   report.Timestamp,
+	}).Error
+}
+
+// Rebuild 由历史记录重建 from 之后的应用会话, 用于批量补传: 补传的上报可能早于已有的会话,
+// 从包含 from 的会话(或之前最近开始的会话)起删除之后的会话, 按时间顺序重新合并该时刻以来的全部历史记录
+func Rebuild(db *gorm.DB, deviceId string, from int64, gap time.Duration) error {
+	var anchor model.AppSession
+	err := db.Where("device_id = ? AND started_at <= ?", deviceId, from).Order("started_at DESC").First(&anchor).Error
+	if err == nil {
+		from = anchor.StartedAt
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	// 更早的会话在 from 之前都已结束, 不受影响
+	if err := db.Where("device_id = ? AND started_at >= ?", deviceId, from).Delete(&model.AppSession{}).Error; err != nil {
+		return err
+	}
+
+	var rows []model.DeviceStatusHistory
+	if err := db.Select("timestamp", "foreground_app_name", "foreground_app_title", "other_screen_on").
+		Where("device_id = ? AND timestamp >= ?", deviceId, from).
+		Order("timestamp ASC").
+		Find(&rows).Error; err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := Track(db, deviceId, NewReport(row.Timestamp, row.Foreground, row.Other), gap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Segment 时间线中的一段应用使用记录
+type Segment struct {
+	AppName  string `json:"app_name"`
+	AppTitle string `json:"app_title"`
+	Start    int64  `json:"start"`    // 开始时间(毫秒)
+	End      int64  `json:"end"`      // 结束时间(毫秒)
+	Duration int64  `json:"duration"` // 时长(毫秒)
+}
+
+// AppTotal 单个应用的使用统计
+type AppTotal struct {
+	AppName  string `json:"app_name"`
+	Duration int64  `json:"duration"` // 总时长(毫秒)
+	Sessions int    `json:"sessions"` // 会话数
+}
+
+// Summarize 汇总 [from, to) 内的应用使用情况, 跨越边界的会话按边界截断
+func Summarize(db *gorm.DB, deviceId string, from, to int64) ([]AppTotal, []Segment, error) {
+	var sessions []model.AppSession
+	if err := db.Where("device_id = ? AND started_at < ? AND ended_at > ?", deviceId, to, from).
+		Order("started_at ASC").
+		Find(&sessions).Error; err != nil {
+		return nil, nil, err
+	}
+
+	timeline := make([]Segment, 0, len(sessions))
+	totals := make(map[string]*AppTotal)
+	for _, session := range sessions {
+		start := max(session.StartedAt, from)
+		end := min(session.EndedAt, to)
+		if end <= start {
+			continue
+		}
+		timeline = append(timeline, Segment{
+			AppName:  session.AppName,
+			AppTitle: session.AppTitle,
+			Start:    start,
+			End:      end,
+			Duration: end - start,
+		})
+		total, ok := totals[session.AppName]
+		if !ok {
+			total = &AppTotal{AppName: session.AppName}
+			totals[session.AppName] = total
+		}
+		total.Duration += end - start
+		total.Sessions++
+	}
+
+	apps := make([]AppTotal, 0, len(totals))
+	for _, total := range totals {
+		apps = append(apps, *total)
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		if apps[i].Duration != apps[j].Duration {
+			return apps[i].Duration > apps[j].Duration
+		}
+		return apps[i].AppName < apps[j].AppName
+	})
+	return apps, timeline, nil
+}
@@ -0,0 +1,190 @@
+package usage
+
+import (
+	"sloth-tracker/api/internal/testdb"
+	"sloth-tracker/api/model"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 测试基准时间
+var base = time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+const gap = 5 * time.Minute
+
+func setupDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return testdb.Open(t, &model.AppSession{}, &model.DeviceStatusHistory{})
+}
+
+// 上报一次前台应用
+func track(t *testing.T, db *gorm.DB, at time.Duration, app, title string, screenOff bool) {
+	t.Helper()
+	report := Report{Timestamp: base.Add(at).UnixMilli(), AppName: app, AppTitle: title, ScreenOff: screenOff}
+	if err := Track(db, "device", report, gap); err != nil {
+		t.Fatalf("记录会话失败: %v", err)
+	}
+}
+
+// 保存一条历史记录(不更新会话), 模拟补传的快照
+func history(t *testing.T, db *gorm.DB, at time.Duration, app, title string) {
+	t.Helper()
+	row := model.DeviceStatusHistory{
+		Id:         uuid.New().String(),
+		DeviceId:   "device",
+		Timestamp:  base.Add(at).UnixMilli(),
+		Foreground: model.ForegroundStatus{AppName: app, AppTitle: title},
+		Other:      model.OtherStatus{ScreenOn: 1},
+	}
+	if err := db.Create(&row).Error; err != nil {
+		t.Fatalf("保存历史记录失败: %v", err)
+	}
+}
+
+func sessions(t *testing.T, db *gorm.DB) []model.AppSession {
+	t.Helper()
+	var rows []model.AppSession
+	if err := db.Order("started_at ASC").Find(&rows).Error; err != nil {
+		t.Fatalf("查询会话失败: %v", err)
+	}
+	return rows
+}
+
+func ms(d time.Duration) int64 {
+	return base.Add(d).UnixMilli()
+}
+
+func TestTrackSplitsOnAppChange(t *testing.T) {
+	db := setupDB(t)
+	track(t, db, 0, "Code", "main.go", false)
+	track(t, db, time.Minute, "Code", "main.go", false)
+	track(t, db, 2*time.Minute, "Browser", "Docs", false)
+	track(t, db, 3*time.Minute, "Browser", "Docs", false)
+
+	rows := sessions(t, db)
+	if len(rows) != 2 {
+		t.Fatalf("会话数 = %d, 期望 2", len(rows))
+	}
+	// 切换应用时前一个会话持续到切换时刻
+	if rows[0].AppName != "Code" || rows[0].EndedAt != ms(2*time.Minute) || !rows[0].Closed {
+		t.Errorf("第一个会话 = %+v", rows[0])
+	}
+	if rows[1].AppName != "Browser" || rows[1].StartedAt != ms(2*time.Minute) || rows[1].EndedAt != ms(3*time.Minute) || rows[1].Closed {
+		t.Errorf("第二个会话 = %+v", rows[1])
+	}
+}
+
+func TestTrackSplitsOnScreenOffAndGap(t *testing.T) {
+	db := setupDB(t)
+	track(t, db, 0, "Code", "main.go", false)
+	track(t, db, time.Minute, "Code", "main.go", true)
+	track(t, db, 2*time.Minute, "Code", "main.go", false)
+	track(t, db, 3*time.Minute, "Code", "main.go", false)
+	// 超过间隔阈值, 视为中断
+	track(t, db, 20*time.Minute, "Code", "main.go", false)
+
+	rows := sessions(t, db)
+	if len(rows) != 3 {
+		t.Fatalf("会话数 = %d, 期望 3", len(rows))
+	}
+	if rows[0].EndedAt != ms(time.Minute) {
+		t.Errorf("熄屏应结束会话, 结束时间 = %d", rows[0].EndedAt)
+	}
+	// 中断时会话停在最后一次上报, 不计入空白时间
+	if rows[1].StartedAt != ms(2*time.Minute) || rows[1].EndedAt != ms(3*time.Minute) {
+		t.Errorf("第二个会话 = %+v", rows[1])
+	}
+	if rows[2].StartedAt != ms(20*time.Minute) {
+		t.Errorf("第三个会话 = %+v", rows[2])
+	}
+}
+
+func TestTrackIgnoresOldReports(t *testing.T) {
+	db := setupDB(t)
+	track(t, db, time.Minute, "Code", "main.go", false)
+	track(t, db, 2*time.Minute, "Code", "main.go", false)
+	track(t, db, 30*time.Second, "Browser", "Docs", false)
+
+	rows := sessions(t, db)
+	if len(rows) != 1 || rows[0].Closed {
+		t.Fatalf("旧上报不应改变会话: %+v", rows)
+	}
+}
+
+func TestSummarizeClipsToRange(t *testing.T) {
+	db := setupDB(t)
+	track(t, db, -10*time.Minute, "Code", "main.go", false)
+	track(t, db, -5*time.Minute, "Code", "main.go", false)
+	track(t, db, 0, "Code", "main.go", false)
+	track(t, db, 5*time.Minute, "Browser", "Docs", false)
+	track(t, db, 7*time.Minute, "Code", "README.md", false)
+	track(t, db, 10*time.Minute, "Code", "README.md", false)
+
+	apps, timeline, err := Summarize(db, "device", ms(-2*time.Minute), ms(9*time.Minute))
+	if err != nil {
+		t.Fatalf("汇总失败: %v", err)
+	}
+	if len(timeline) != 3 {
+		t.Fatalf("时间线长度 = %d, 期望 3", len(timeline))
+	}
+	if timeline[0].Start != ms(-2*time.Minute) || timeline[2].End != ms(9*time.Minute) {
+		t.Errorf("时间线未按范围截断: %+v", timeline)
+	}
+	if len(apps) != 2 {
+		t.Fatalf("应用数 = %d, 期望 2", len(apps))
+	}
+	// Code: 7 分钟(-2..5) + 2 分钟(7..9), Browser: 2 分钟
+	if apps[0].AppName != "Code" || apps[0].Duration != (9*time.Minute).Milliseconds() || apps[0].Sessions != 2 {
+		t.Errorf("Code 统计 = %+v", apps[0])
+	}
+	if apps[1].AppName != "Browser" || apps[1].Duration != (2*time.Minute).Milliseconds() {
+		t.Errorf("Browser 统计 = %+v", apps[1])
+	}
+}
+
+func TestRebuildMergesBackfill(t *testing.T) {
+	db := setupDB(t)
+	// 实时上报: 先保存历史记录再更新会话
+	for _, at := range []time.Duration{10 * time.Minute, 12 * time.Minute, 14 * time.Minute} {
+		history(t, db, at, "Code", "main.go")
+		track(t, db, at, "Code", "main.go", false)
+	}
+
+	// 补传早于已有会话的快照, 其中一条落在会话中间
+	history(t, db, 0, "Browser", "Docs")
+	history(t, db, 2*time.Minute, "Browser", "Docs")
+	history(t, db, 13*time.Minute, "Browser", "Docs")
+	if err := Rebuild(db, "device", ms(0), gap); err != nil {
+		t.Fatalf("重建会话失败: %v", err)
+	}
+
+	rows := sessions(t, db)
+	want := []struct {
+		app        string
+		start, end time.Duration
+		closed     bool
+	}{
+		{"Browser", 0, 2 * time.Minute, true},
+		{"Code", 10 * time.Minute, 13 * time.Minute, true},
+		{"Browser", 13 * time.Minute, 14 * time.Minute, true},
+		{"Code", 14 * time.Minute, 14 * time.Minute, false},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("会话数 = %d, 期望 %d: %+v", len(rows), len(want), rows)
+	}
+	for i, w := range want {
+		if rows[i].AppName != w.app || rows[i].StartedAt != ms(w.start) || rows[i].EndedAt != ms(w.end) || rows[i].Closed != w.closed {
+			t.Errorf("第 %d 个会话 = %+v, 期望 %+v", i+1, rows[i], w)
+		}
+	}
+
+	// 之后的实时上报继续延续最后一个会话
+	history(t, db, 15*time.Minute, "Code", "main.go")
+	track(t, db, 15*time.Minute, "Code", "main.go", false)
+	if rows := sessions(t, db); len(rows) != 4 || rows[3].EndedAt != ms(15*time.Minute) {
+		t.Errorf("重建后实时上报未延续会话: %+v", rows)
+	}
+}
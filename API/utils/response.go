@@ -2,7 +2,12 @@ package utils
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
+	"sloth-tracker/api/config"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // JSONResponse 统一的JSON响应
@@ -28,6 +33,32 @@ func Error(w http.ResponseWriter, status int, message string) {
 	})
 }
 
+// FieldError 单个字段的校验错误
+type FieldError struct {
+	Path   string `json:"path"`   // 字段路径(如 battery.level)
+	Reason string `json:"reason"` // 错误原因
+}
+
+// FieldErrors 多个字段的校验错误
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	reasons := make([]string, len(e))
+	for i, fieldError := range e {
+		reasons[i] = fieldError.Path + ": " + fieldError.Reason
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// ValidationError 参数校验失败响应, 列出每个不合法的字段
+func ValidationError(w http.ResponseWriter, errors FieldErrors) {
+	JSONResponse(w, http.StatusBadRequest, map[string]any{
+		"success": false,
+		"error":   "参数校验失败",
+		"fields":  errors,
+	})
+}
+
 // GetQueryParam 获取查询参数
 func GetQueryParam(r *http.Request, key string) string {
 	return r.URL.Query().Get(key)
@@ -41,3 +72,51 @@ func GetQueryParamDefault(r *http.Request, key, defaultValue string) string {
 	}
 	return value
 }
+
+// GetPagination 获取分页参数(page 从1开始, page_size 默认20, 最大100)
+func GetPagination(r *http.Request) (page, pageSize int) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err = strconv.Atoi(r.URL.Query().Get("page_size"))
+	if err != nil || pageSize < 1 {
+		pageSize = 20
+	}
+	return page, min(pageSize, 100)
+}
+
+// ClientIP 获取客户端IP, 仅在信任反向代理时读取 X-Forwarded-For.
+// 取最右侧的地址(由反向代理追加), 左侧的地址由客户端提供, 不可信
+func ClientIP(r *http.Request) string {
+	if config.Get().TrustProxy {
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			forwarded := values[len(values)-1]
+			ip := strings.TrimSpace(forwarded[strings.LastIndex(forwarded, ",")+1:])
+			if net.ParseIP(ip) != nil {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// GetTimeParam 读取时间查询参数, 支持毫秒时间戳或 RFC3339 格式, 参数为空时 ok 为 false
+func GetTimeParam(r *http.Request, key string) (ms int64, ok bool, err error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return 0, false, nil
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return ms, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, false, err
+	}
+	return t.UnixMilli(), true, nil
+}
@@ -0,0 +1,409 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sloth-tracker/api/events"
+	"sloth-tracker/api/model"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Options 投递参数
+type Options struct {
+	MaxAttempts  int           // 每次投递最多尝试次数
+	BaseDelay    time.Duration // 首次重试的等待时间, 之后每次翻倍
+	MaxDelay     time.Duration // 重试等待时间上限
+	Timeout      time.Duration // 单次请求超时
+	DisableAfter int           // 连续失败多少次后自动停用 Webhook
+	AllowPrivate bool          // 是否允许投递到本机或内网地址
+	PollInterval time.Duration // 后台检查待投递记录的间隔
+	Retention    time.Duration // 已完成的投递记录保留时长(0 表示永久保留)
+	Workers      int           // 并发投递数
+}
+
+const batchSize = 32 // 每轮最多处理的投递数
+
+// Dispatcher 订阅领域事件并投递到用户的 Webhook
+type Dispatcher struct {
+	db     *gorm.DB
+	opts   Options
+	client *http.Client
+	wake   chan struct{} // 唤醒后台投递任务
+}
+
+// NewDispatcher 创建投递器, 未设置的参数使用默认值
+func NewDispatcher(db *gorm.DB, opts Options) *Dispatcher {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 6
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = 30 * time.Second
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = time.Hour
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.DisableAfter <= 0 {
+		opts.DisableAfter = 20
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 5 * time.Second
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	if !opts.AllowPrivate {
+		// 在建立连接时检查解析后的地址, 防止通过域名绕过
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !public(ip) {
+				return fmt.Errorf("不允许投递到内网地址 %s", host)
+			}
+			return nil
+		}
+	}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   opts.Timeout,
+		ResponseHeaderTimeout: opts.Timeout,
+		MaxIdleConns:          16,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	return &Dispatcher{
+		db:   db,
+		opts: opts,
+		wake: make(chan struct{}, 1),
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   opts.Timeout,
+			// 不跟随重定向, 3xx 视为失败
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Start 订阅事件并在后台投递
+func (d *Dispatcher) Start(bus *events.Bus) {
+	// 状态更新远多于其他事件, 单独订阅, 避免处理不及时挤占其他事件的缓冲而被丢弃
+	bus.Subscribe("webhooks", 256, d.Handle,
+		events.TypePresenceChanged,
+		events.TypeShareRequested, events.TypeShareApproved, events.TypeShareRevoked,
+		events.TypeDeviceRegistered, events.TypeDeviceDeleted)
+	bus.Subscribe("webhooks-status", 1024, d.Handle, events.TypeStatusUpdated)
+
+	go func() {
+		ticker := time.NewTicker(d.opts.PollInterval)
+		defer ticker.Stop()
+		lastPrune := time.Time{}
+		for {
+			select {
+			case <-ticker.C:
+			case <-d.wake:
+			}
+			now := time.Now()
+			for {
+				n, err := d.RunDue(now)
+				if err != nil {
+					log.Printf("⚠️ Webhook 投递失败: %v", err)
+				}
+				if err != nil || n < batchSize {
+					break
+				}
+			}
+			if d.opts.Retention > 0 && now.Sub(lastPrune) >= time.Hour {
+				lastPrune = now
+				if err := Prune(d.db, now.Add(-d.opts.Retention)); err != nil {
+					log.Printf("⚠️ 清理 Webhook 投递记录失败: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Notify 唤醒后台任务立即投递新创建的记录
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Handle 将领域事件转换为 Webhook 投递
+func (d *Dispatcher) Handle(event events.Event) {
+	now := time.Now()
+	eventId := uuid.New().String()
+
+	var (
+		kind     string
+		deviceId string
+		users    []string
+		data     any
+	)
+	switch e := event.(type) {
+	case events.PresenceChanged:
+		switch e.To {
+		case "online":
+			// 上报间隔超过心跳超时的设备会在 stale 与 online 之间反复切换, 只通知从离线恢复
+			if e.From != "offline" {
+				return
+			}
+			kind = DeviceOnline
+		case "offline":
+			kind = DeviceOffline
+		default:
+			return
+		}
+		deviceId, users, data = e.DeviceId, audience(d.db, e.DeviceId), e
+	case events.StatusUpdated:
+		d.batteryLow(e, eventId, now)
+		return
+	case events.ShareRequested:
+		kind, deviceId, users, data = ShareRequested, e.DeviceId, []string{e.OwnerId}, e
+	case events.ShareApproved:
+		kind, deviceId, users, data = ShareApproved, e.DeviceId, []string{e.ViewerId}, e
+	case events.ShareRevoked:
+		kind, deviceId, users, data = ShareRevoked, e.DeviceId, []string{e.ViewerId}, e
+	case events.DeviceRegistered:
+		kind, deviceId, users, data = DeviceRegistered, e.DeviceId, []string{e.OwnerId}, e
+	case events.DeviceDeleted:
+		kind, deviceId, users, data = DeviceDeleted, e.DeviceId, []string{e.OwnerId}, e
+	default:
+		return
+	}
+
+	hooks, err := Subscribed(d.db, users, kind, deviceId)
+	if err != nil {
+		log.Printf("⚠️ 查询 Webhook 失败: %v", err)
+		return
+	}
+	for i := range hooks {
+		if _, err := Enqueue(d.db, &hooks[i], eventId, kind, data, now); err != nil {
+			log.Printf("⚠️ 创建 Webhook 投递失败: %v", err)
+		}
+	}
+	if len(hooks) > 0 {
+		d.Notify()
+	}
+}
+
+// 电量从阈值以上降到阈值及以下时触发 battery.low;
+// 充电状态为 0 表示电池分组未上报或被清零, 此时的电量不可信
+func (d *Dispatcher) batteryLow(e events.StatusUpdated, eventId string, now time.Time) {
+	if e.Previous == nil || e.Previous.Battery.Charging == 0 || e.Status.Battery.Charging == 0 {
+		return
+	}
+	if e.Status.Battery.Level >= e.Previous.Battery.Level {
+		return
+	}
+	hooks, err := Subscribed(d.db, audience(d.db, e.DeviceId), BatteryLow, e.DeviceId)
+	if err != nil {
+		log.Printf("⚠️ 查询 Webhook 失败: %v", err)
+		return
+	}
+	queued := 0
+	for i := range hooks {
+		threshold := hooks[i].BatteryThreshold
+		if e.Previous.Battery.Level <= threshold || e.Status.Battery.Level > threshold {
+			continue
+		}
+		data := map[string]any{
+			"device_id": e.DeviceId,
+			"level":     e.Status.Battery.Level,
+			"previous":  e.Previous.Battery.Level,
+			"threshold": threshold,
+			"charging":  e.Status.Battery.Charging,
+		}
+		if _, err := Enqueue(d.db, &hooks[i], eventId, BatteryLow, data, now); err != nil {
+			log.Printf("⚠️ 创建 Webhook 投递失败: %v", err)
+			continue
+		}
+		queued++
+	}
+	if queued > 0 {
+		d.Notify()
+	}
+}
+
+// RunDue 投递一批到期的记录, 返回处理的数量
+func (d *Dispatcher) RunDue(now time.Time) (int, error) {
+	var due []model.WebhookDelivery
+	if err := d.db.Where("status = ? AND next_attempt_at <= ?", Pending, now).
+		Order("next_attempt_at").Limit(batchSize).Find(&due).Error; err != nil {
+		return 0, err
+	}
+
+	queue := make(chan *model.WebhookDelivery)
+	var wg sync.WaitGroup
+	for range min(d.opts.Workers, len(due)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for delivery := range queue {
+				d.attempt(delivery, now)
+			}
+		}()
+	}
+	for i := range due {
+		queue <- &due[i]
+	}
+	close(queue)
+	wg.Wait()
+	return len(due), nil
+}
+
+// 发送一次请求并记录结果
+func (d *Dispatcher) attempt(delivery *model.WebhookDelivery, now time.Time) {
+	var hook model.Webhook
+	if err := d.db.Where("id = ?", delivery.WebhookId).Limit(1).Find(&hook).Error; err != nil {
+		log.Printf("⚠️ 查询 Webhook 失败: %v", err)
+		return
+	}
+	if hook.Id == "" || !hook.Enabled {
+		d.db.Model(delivery).Updates(map[string]any{
+			"status":       Failed,
+			"error":        "Webhook 已停用或删除",
+			"completed_at": now,
+		})
+		return
+	}
+
+	code, duration, failure := d.send(&hook, delivery, now)
+	attempts := delivery.Attempts + 1
+	updates := map[string]any{
+		"attempts":    attempts,
+		"status_code": code,
+		"error":       truncate(failure),
+		"duration_ms": duration.Milliseconds(),
+	}
+	if failure == "" {
+		updates["status"] = Succeeded
+		updates["completed_at"] = now
+		if err := d.db.Model(delivery).Updates(updates).Error; err != nil {
+			log.Printf("⚠️ 更新 Webhook 投递失败: %v", err)
+		}
+		if hook.Failures != 0 {
+			d.db.Model(&hook).Update("failures", 0)
+		}
+		return
+	}
+
+	if attempts >= d.opts.MaxAttempts {
+		updates["status"] = Failed
+		updates["completed_at"] = now
+	} else {
+		updates["next_attempt_at"] = now.Add(d.backoff(attempts))
+	}
+	if err := d.db.Model(delivery).Updates(updates).Error; err != nil {
+		log.Printf("⚠️ 更新 Webhook 投递失败: %v", err)
+	}
+	d.recordFailure(&hook, now)
+}
+
+// 发送请求, 返回状态码, 耗时与失败原因(成功时为空)
+func (d *Dispatcher) send(hook *model.Webhook, delivery *model.WebhookDelivery, now time.Time) (int, time.Duration, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, 0, err.Error()
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "SlothTracker-Webhook/1.0")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.Id)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, timestamp, delivery.Payload))
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	duration := time.Since(start)
+	if err != nil {
+		return 0, duration, err.Error()
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, duration, fmt.Sprintf("接收方返回 HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, duration, ""
+}
+
+// 累计连续失败次数, 达到上限时停用 Webhook 并放弃其未完成的投递
+func (d *Dispatcher) recordFailure(hook *model.Webhook, now time.Time) {
+	if err := d.db.Model(hook).UpdateColumn("failures", gorm.Expr("failures + 1")).Error; err != nil {
+		log.Printf("⚠️ 更新 Webhook 失败: %v", err)
+		return
+	}
+	var failures int
+	d.db.Model(&model.Webhook{}).Where("id = ?", hook.Id).Select("failures").Scan(&failures)
+	if failures < d.opts.DisableAfter {
+		return
+	}
+
+	result := d.db.Model(&model.Webhook{}).Where("id = ? AND enabled = ?", hook.Id, true).Updates(map[string]any{
+		"enabled":         false,
+		"disabled_reason": fmt.Sprintf("连续 %d 次投递失败, 已自动停用", failures),
+	})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+	d.db.Model(&model.WebhookDelivery{}).Where("webhook_id = ? AND status = ?", hook.Id, Pending).Updates(map[string]any{
+		"status":       Failed,
+		"error":        "Webhook 已自动停用",
+		"completed_at": now,
+	})
+	log.Printf("⚠️ Webhook %s 连续 %d 次投递失败, 已自动停用", hook.Id, failures)
+}
+
+// 第 attempts 次失败后的重试等待时间
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.opts.BaseDelay
+	for i := 1; i < attempts && delay < d.opts.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, d.opts.MaxDelay)
+}
+
+// 设备所有者与已授权的查看者
+func audience(db *gorm.DB, deviceId string) []string {
+	var device model.Device
+	if err := db.Select("id", "owner_id").Where("id = ?", deviceId).Limit(1).Find(&device).Error; err != nil || device.Id == "" {
+		return nil
+	}
+	var viewers []string
+	db.Model(&model.SharedDevice{}).Where("device_id = ? AND authorization = ?", deviceId, 1).Pluck("viewer_id", &viewers)
+	return append([]string{device.OwnerId}, viewers...)
+}
+
+// 是否为公网地址
+func public(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip))
+}
+
+// 运营商级 NAT 共享地址(RFC 6598), 同样属于内网
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
@@ -0,0 +1,200 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sloth-tracker/api/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 可订阅的事件类型
+const (
+	DeviceOnline     = "device.online"     // 设备恢复在线
+	DeviceOffline    = "device.offline"    // 设备离线
+	BatteryLow       = "battery.low"       // 电量降到阈值以下
+	ShareRequested   = "share.requested"   // 收到查看设备的申请(发给设备所有者)
+	ShareApproved    = "share.approved"    // 查看申请已通过(发给申请人)
+	ShareRevoked     = "share.revoked"     // 共享被撤销(发给查看者)
+	DeviceRegistered = "device.registered" // 注册了新设备
+	DeviceDeleted    = "device.deleted"    // 设备已注销
+	Test             = "webhook.test"      // 手动发送的测试事件, 无需订阅
+)
+
+// Events 可订阅的事件类型
+var Events = []string{
+	DeviceOnline, DeviceOffline, BatteryLow,
+	ShareRequested, ShareApproved, ShareRevoked,
+	DeviceRegistered, DeviceDeleted,
+}
+
+// 投递状态
+const (
+	Pending   = "pending"   // 等待投递或重试
+	Succeeded = "succeeded" // 接收方返回 2xx
+	Failed    = "failed"    // 重试次数用尽或 Webhook 已停用
+)
+
+// 请求头
+const (
+	HeaderEvent     = "X-Sloth-Event"     // 事件类型
+	HeaderDelivery  = "X-Sloth-Delivery"  // 投递ID, 重试时不变, 可用于去重
+	HeaderTimestamp = "X-Sloth-Timestamp" // 签名时间戳(秒)
+	HeaderSignature = "X-Sloth-Signature" // sha256=<HMAC-SHA256(密钥, "时间戳.请求体")>
+)
+
+const (
+	MaxPerUser              = 20 // 每个用户最多创建的 Webhook 数
+	DefaultBatteryThreshold = 20 // battery.low 默认阈值(%)
+	maxURLLen               = 2048
+	maxErrorLen             = 500
+)
+
+var (
+	ErrInvalid = errors.New("Webhook 配置不正确")
+	ErrTooMany = fmt.Errorf("每个用户最多创建 %d 个 Webhook", MaxPerUser)
+)
+
+// Payload 投递的请求体
+type Payload struct {
+	Id        string    `json:"id"`   // 事件ID
+	Type      string    `json:"type"` // 事件类型
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// ValidateURL 校验接收地址, 仅支持 http 与 https
+func ValidateURL(raw string) error {
+	if raw == "" || len(raw) > maxURLLen {
+		return fmt.Errorf("%w: url 不能为空且不超过 %d 个字符", ErrInvalid, maxURLLen)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url 应为 http 或 https 地址", ErrInvalid)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: url 不能包含用户名或密码", ErrInvalid)
+	}
+	return nil
+}
+
+// ValidateEvents 校验订阅的事件类型, 返回去重排序后的列表
+func ValidateEvents(list []string) ([]string, error) {
+	if len(list) == 0 {
+		return nil, fmt.Errorf("%w: events 不能为空", ErrInvalid)
+	}
+	result := make([]string, 0, len(list))
+	for _, kind := range list {
+		if !slices.Contains(Events, kind) {
+			return nil, fmt.Errorf("%w: 未知的事件类型 %q", ErrInvalid, kind)
+		}
+		if !slices.Contains(result, kind) {
+			result = append(result, kind)
+		}
+	}
+	slices.Sort(result)
+	return result, nil
+}
+
+// ValidateThreshold 校验电量阈值
+func ValidateThreshold(threshold int) error {
+	if threshold < 1 || threshold > 99 {
+		return fmt.Errorf("%w: battery_threshold 应在 1 到 99 之间", ErrInvalid)
+	}
+	return nil
+}
+
+// NewSecret 生成签名密钥
+func NewSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// Sign 计算签名, 签名内容为 "时间戳.请求体"
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 校验签名, 时间戳与 now 相差超过 tolerance 时视为重放
+func Verify(secret, timestamp, signature string, body []byte, tolerance time.Duration, now time.Time) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if diff := now.Sub(time.Unix(ts, 0)); diff > tolerance || diff < -tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, ts, body)), []byte(signature))
+}
+
+// Enqueue 为 Webhook 创建一次投递, 由后台任务发送
+func Enqueue(db *gorm.DB, hook *model.Webhook, eventId, kind string, data any, now time.Time) (*model.WebhookDelivery, error) {
+	body, err := json.Marshal(Payload{Id: eventId, Type: kind, CreatedAt: now, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	delivery := &model.WebhookDelivery{
+		Id:            uuid.New().String(),
+		WebhookId:     hook.Id,
+		EventId:       eventId,
+		Event:         kind,
+		Payload:       body,
+		Status:        Pending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	if err := db.Create(delivery).Error; err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// Subscribed 查询订阅了该事件的已启用 Webhook
+func Subscribed(db *gorm.DB, userIds []string, kind, deviceId string) ([]model.Webhook, error) {
+	if len(userIds) == 0 {
+		return nil, nil
+	}
+	var hooks []model.Webhook
+	if err := db.Where("user_id IN ? AND enabled = ? AND (device_id = '' OR device_id = ?)", userIds, true, deviceId).
+		Find(&hooks).Error; err != nil {
+		return nil, err
+	}
+	result := hooks[:0]
+	for _, hook := range hooks {
+		if slices.Contains(hook.Events, kind) {
+			result = append(result, hook)
+		}
+	}
+	return result, nil
+}
+
+// Prune 删除早于 before 且已完成的投递记录
+func Prune(db *gorm.DB, before time.Time) error {
+	return db.Where("status <> ? AND created_at < ?", Pending, before).Delete(&model.WebhookDelivery{}).Error
+}
+
+// 截断过长的错误信息
+func truncate(s string) string {
+	if len(s) <= maxErrorLen {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxErrorLen], "")
+}
@@ -0,0 +1,359 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sloth-tracker/api/events"
+	"sloth-tracker/api/internal/testdb"
+	"sloth-tracker/api/model"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func setupDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return testdb.Open(t, &model.Device{}, &model.SharedDevice{}, &model.Webhook{}, &model.WebhookDelivery{})
+}
+
+// 本地接收方, 记录收到的请求并按 status 返回
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, status int) (*receiver, *httptest.Server) {
+	rec := &receiver{status: status}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, r)
+		rec.bodies = append(rec.bodies, body)
+		status := rec.status
+		rec.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return rec, server
+}
+
+func (rec *receiver) count() int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return len(rec.requests)
+}
+
+func createHook(t *testing.T, db *gorm.DB, userId, url string, kinds ...string) *model.Webhook {
+	t.Helper()
+	hook := &model.Webhook{
+		Id:               "hook-" + userId,
+		UserId:           userId,
+		URL:              url,
+		Secret:           "whsec_test",
+		Events:           kinds,
+		BatteryThreshold: DefaultBatteryThreshold,
+		Enabled:          true,
+	}
+	if err := db.Create(hook).Error; err != nil {
+		t.Fatalf("创建 Webhook 失败: %v", err)
+	}
+	return hook
+}
+
+func loadDelivery(t *testing.T, db *gorm.DB, id string) model.WebhookDelivery {
+	t.Helper()
+	var delivery model.WebhookDelivery
+	if err := db.First(&delivery, "id = ?", id).Error; err != nil {
+		t.Fatalf("查询投递失败: %v", err)
+	}
+	return delivery
+}
+
+func TestValidate(t *testing.T) {
+	for _, raw := range []string{"https://example.com/hook", "http://example.com:8080/a?b=c"} {
+		if err := ValidateURL(raw); err != nil {
+			t.Errorf("%s 应合法: %v", raw, err)
+		}
+	}
+	for _, raw := range []string{"", "ftp://example.com", "https://", "https://user:pw@example.com", "example.com"} {
+		if err := ValidateURL(raw); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q 应不合法, 得到 %v", raw, err)
+		}
+	}
+
+	kinds, err := ValidateEvents([]string{DeviceOffline, BatteryLow, DeviceOffline})
+	if err != nil || strings.Join(kinds, ",") != "battery.low,device.offline" {
+		t.Errorf("events 应去重排序, 得到 %v %v", kinds, err)
+	}
+	if _, err := ValidateEvents([]string{"device.exploded"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("未知事件应不合法, 得到 %v", err)
+	}
+	if _, err := ValidateEvents(nil); !errors.Is(err, ErrInvalid) {
+		t.Errorf("空 events 应不合法, 得到 %v", err)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"1"}`)
+	signature := Sign("secret", now.Unix(), body)
+	if !strings.HasPrefix(signature, "sha256=") {
+		t.Fatalf("签名格式错误: %s", signature)
+	}
+	if !Verify("secret", "1700000000", signature, body, 5*time.Minute, now) {
+		t.Error("签名应校验通过")
+	}
+	if Verify("other", "1700000000", signature, body, 5*time.Minute, now) {
+		t.Error("密钥不同时应校验失败")
+	}
+	if Verify("secret", "1700000000", signature, []byte(`{"id":"2"}`), 5*time.Minute, now) {
+		t.Error("请求体被修改时应校验失败")
+	}
+	if Verify("secret", "1700000000", signature, body, 5*time.Minute, now.Add(10*time.Minute)) {
+		t.Error("时间戳过旧时应校验失败")
+	}
+}
+
+func TestDeliverSigned(t *testing.T) {
+	db := setupDB(t)
+	rec, server := newReceiver(t, http.StatusNoContent)
+	hook := createHook(t, db, "u1", server.URL, DeviceOffline)
+	d := NewDispatcher(db, Options{AllowPrivate: true})
+
+	now := time.Now()
+	delivery, err := Enqueue(db, hook, "event-1", DeviceOffline, events.PresenceChanged{DeviceId: "d1", From: "stale", To: "offline"}, now)
+	if err != nil {
+		t.Fatalf("创建投递失败: %v", err)
+	}
+	if n, err := d.RunDue(now); err != nil || n != 1 {
+		t.Fatalf("应投递 1 条, 得到 %d %v", n, err)
+	}
+
+	if rec.count() != 1 {
+		t.Fatalf("接收方应收到 1 个请求, 得到 %d", rec.count())
+	}
+	req, body := rec.requests[0], rec.bodies[0]
+	if req.Header.Get(HeaderEvent) != DeviceOffline || req.Header.Get(HeaderDelivery) != delivery.Id {
+		t.Errorf("请求头错误: %v", req.Header)
+	}
+	if !Verify(hook.Secret, req.Header.Get(HeaderTimestamp), req.Header.Get(HeaderSignature), body, time.Minute, now) {
+		t.Error("签名应校验通过")
+	}
+	var payload struct {
+		Id   string                 `json:"id"`
+		Type string                 `json:"type"`
+		Data events.PresenceChanged `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Id != "event-1" || payload.Type != DeviceOffline || payload.Data.DeviceId != "d1" {
+		t.Errorf("请求体错误: %s", body)
+	}
+
+	got := loadDelivery(t, db, delivery.Id)
+	if got.Status != Succeeded || got.Attempts != 1 || got.StatusCode != http.StatusNoContent || got.CompletedAt == nil {
+		t.Errorf("投递记录错误: %+v", got)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	db := setupDB(t)
+	rec, server := newReceiver(t, http.StatusInternalServerError)
+	hook := createHook(t, db, "u1", server.URL, DeviceOffline)
+	d := NewDispatcher(db, Options{AllowPrivate: true, MaxAttempts: 3, BaseDelay: time.Minute})
+
+	now := time.Now()
+	delivery, _ := Enqueue(db, hook, "event-1", DeviceOffline, nil, now)
+
+	d.RunDue(now)
+	got := loadDelivery(t, db, delivery.Id)
+	if got.Status != Pending || got.Attempts != 1 || got.StatusCode != 500 || !got.NextAttemptAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("首次失败后应 1 分钟后重试: %+v", got)
+	}
+
+	// 未到重试时间时不投递
+	if n, _ := d.RunDue(now.Add(30 * time.Second)); n != 0 {
+		t.Fatalf("未到重试时间不应投递, 得到 %d", n)
+	}
+
+	now = now.Add(time.Minute)
+	d.RunDue(now)
+	got = loadDelivery(t, db, delivery.Id)
+	if got.Attempts != 2 || !got.NextAttemptAt.Equal(now.Add(2*time.Minute)) {
+		t.Fatalf("第二次失败后应 2 分钟后重试: %+v", got)
+	}
+
+	// 接收方恢复后成功, 连续失败次数清零
+	rec.mu.Lock()
+	rec.status = http.StatusOK
+	rec.mu.Unlock()
+	now = now.Add(2 * time.Minute)
+	d.RunDue(now)
+	got = loadDelivery(t, db, delivery.Id)
+	if got.Status != Succeeded || got.Attempts != 3 {
+		t.Fatalf("第三次应投递成功: %+v", got)
+	}
+	var stored model.Webhook
+	db.First(&stored, "id = ?", hook.Id)
+	if stored.Failures != 0 || !stored.Enabled {
+		t.Errorf("成功后连续失败次数应清零: %+v", stored)
+	}
+	if rec.count() != 3 {
+		t.Errorf("接收方应收到 3 个请求, 得到 %d", rec.count())
+	}
+}
+
+func TestGiveUp(t *testing.T) {
+	db := setupDB(t)
+	_, server := newReceiver(t, http.StatusBadGateway)
+	hook := createHook(t, db, "u1", server.URL, DeviceOffline)
+	d := NewDispatcher(db, Options{AllowPrivate: true, MaxAttempts: 2, BaseDelay: time.Second})
+
+	now := time.Now()
+	delivery, _ := Enqueue(db, hook, "event-1", DeviceOffline, nil, now)
+	d.RunDue(now)
+	d.RunDue(now.Add(time.Second))
+
+	got := loadDelivery(t, db, delivery.Id)
+	if got.Status != Failed || got.Attempts != 2 || got.CompletedAt == nil {
+		t.Errorf("重试次数用尽后应放弃: %+v", got)
+	}
+}
+
+func TestAutoDisable(t *testing.T) {
+	db := setupDB(t)
+	rec, server := newReceiver(t, http.StatusInternalServerError)
+	hook := createHook(t, db, "u1", server.URL, DeviceOffline)
+	d := NewDispatcher(db, Options{AllowPrivate: true, DisableAfter: 3, Workers: 1})
+
+	now := time.Now()
+	for range 5 {
+		Enqueue(db, hook, "event", DeviceOffline, nil, now)
+	}
+	d.RunDue(now)
+
+	var stored model.Webhook
+	db.First(&stored, "id = ?", hook.Id)
+	if stored.Enabled || stored.Failures != 3 || stored.DisabledReason == "" {
+		t.Fatalf("连续失败 3 次后应停用: %+v", stored)
+	}
+	if rec.count() != 3 {
+		t.Errorf("停用后不应继续投递, 接收方收到 %d 个请求", rec.count())
+	}
+	var pending int64
+	db.Model(&model.WebhookDelivery{}).Where("status = ?", Pending).Count(&pending)
+	if pending != 0 {
+		t.Errorf("停用后未完成的投递应放弃, 剩余 %d", pending)
+	}
+}
+
+func TestRejectPrivate(t *testing.T) {
+	db := setupDB(t)
+	rec, server := newReceiver(t, http.StatusOK)
+	hook := createHook(t, db, "u1", server.URL, DeviceOffline)
+	d := NewDispatcher(db, Options{})
+
+	now := time.Now()
+	delivery, _ := Enqueue(db, hook, "event-1", DeviceOffline, nil, now)
+	d.RunDue(now)
+
+	got := loadDelivery(t, db, delivery.Id)
+	if rec.count() != 0 || got.Status != Pending || !strings.Contains(got.Error, "内网地址") {
+		t.Errorf("默认应拒绝投递到本机地址: %+v", got)
+	}
+}
+
+func TestPublic(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"100.63.255.255", true},
+		{"100.128.0.0", true},
+		{"2606:4700::1", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"::1", false},
+		{"fd00::1", false},
+	}
+	for _, tt := range tests {
+		if got := public(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("public(%s) = %v, 期望 %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	db := setupDB(t)
+	db.Create(&model.Device{Id: "d1", OwnerId: "owner"})
+	db.Create(&model.SharedDevice{Id: "s1", DeviceId: "d1", ViewerId: "viewer", Authorization: 1})
+	db.Create(&model.SharedDevice{Id: "s2", DeviceId: "d1", ViewerId: "pending", Authorization: 2})
+	owner := createHook(t, db, "owner", "https://example.com/owner", DeviceOnline, DeviceOffline, BatteryLow, ShareRequested)
+	viewer := createHook(t, db, "viewer", "https://example.com/viewer", DeviceOffline, ShareApproved)
+	createHook(t, db, "pending", "https://example.com/pending", DeviceOffline)
+	other := createHook(t, db, "other", "https://example.com/other", DeviceOffline)
+	db.Model(other).Update("device_id", "d2")
+	d := NewDispatcher(db, Options{})
+
+	count := func(hook *model.Webhook, kind string) int64 {
+		var n int64
+		db.Model(&model.WebhookDelivery{}).Where("webhook_id = ? AND event = ?", hook.Id, kind).Count(&n)
+		return n
+	}
+
+	// 离线通知发给所有者与已授权的查看者
+	d.Handle(events.PresenceChanged{DeviceId: "d1", From: "stale", To: "offline"})
+	d.Handle(events.PresenceChanged{DeviceId: "d1", From: "online", To: "stale"})
+	var total int64
+	db.Model(&model.WebhookDelivery{}).Count(&total)
+	if count(owner, DeviceOffline) != 1 || count(viewer, DeviceOffline) != 1 || total != 2 {
+		t.Errorf("离线通知应只发给所有者与已授权的查看者, 共 %d 条", total)
+	}
+
+	// 只有从离线恢复时发送上线通知
+	d.Handle(events.PresenceChanged{DeviceId: "d1", From: "stale", To: "online"})
+	if n := count(owner, DeviceOnline); n != 0 {
+		t.Errorf("stale 恢复为 online 不应通知, 实际 %d 条", n)
+	}
+	d.Handle(events.PresenceChanged{DeviceId: "d1", From: "offline", To: "online"})
+	if n := count(owner, DeviceOnline); n != 1 {
+		t.Errorf("从离线恢复应通知一次, 实际 %d 条", n)
+	}
+
+	// 电量从阈值以上降到阈值时只通知一次
+	status := func(level int) model.DeviceStatus {
+		return model.DeviceStatus{Battery: model.BatteryStatus{Level: level, Charging: 2}}
+	}
+	// 电池分组被清零(未上报)时电量为 0, 不视为电量降低
+	prev := status(25)
+	d.Handle(events.StatusUpdated{DeviceId: "d1", Status: model.DeviceStatus{}, Previous: &prev})
+	d.Handle(events.StatusUpdated{DeviceId: "d1", Status: status(20), Previous: &model.DeviceStatus{}})
+	if count(owner, BatteryLow) != 0 {
+		t.Errorf("未上报电池状态时不应触发 battery.low, 得到 %d", count(owner, BatteryLow))
+	}
+	d.Handle(events.StatusUpdated{DeviceId: "d1", Status: status(20), Previous: &prev})
+	prev = status(20)
+	d.Handle(events.StatusUpdated{DeviceId: "d1", Status: status(15), Previous: &prev})
+	d.Handle(events.StatusUpdated{DeviceId: "d1", Status: status(10)})
+	if count(owner, BatteryLow) != 1 {
+		t.Errorf("battery.low 应只触发 1 次, 得到 %d", count(owner, BatteryLow))
+	}
+
+	d.Handle(events.ShareRequested{DeviceId: "d1", OwnerId: "owner", ViewerId: "pending"})
+	d.Handle(events.ShareApproved{DeviceId: "d1", OwnerId: "owner", ViewerId: "viewer"})
+	if count(owner, ShareRequested) != 1 || count(viewer, ShareApproved) != 1 {
+		t.Error("共享事件应发给对应用户")
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	agentReplyTimeout = 10 * time.Second // 等待服务器确认上报的时间
+	agentReadTimeout  = 75 * time.Second // 超过该时间未收到服务器消息(含 ping)视为断开
+	agentRetryDelay   = time.Minute      // 连接失败后再次尝试前的等待时间, 期间使用 HTTP 上报
+)
+
+var errAgentClosed = errors.New("WebSocket 连接已断开")
+
+// 服务器通过 WebSocket 发送的消息
+type agentReply struct {
+	Type     string          `json:"type"`     // ack, error, message 或 command
+	Id       string          `json:"id"`       // 对应的消息ID
+	Error    string          `json:"error"`    // 错误信息
+	Fields   json.RawMessage `json:"fields"`   // 字段校验错误
+	Data     json.RawMessage `json:"data"`     // 服务器下发的消息或命令
+	Commands json.RawMessage `json:"commands"` // 随上报确认返回的待执行命令
+}
+
+// 与服务器保持的 WebSocket 连接, 用于上报状态和接收服务器下发的消息
+type AgentConn struct {
+	mu        sync.Mutex
+	conn      *websocket.Conn
+	key       string                     // 建立连接时使用的服务器地址与设备凭证
+	pending   map[string]chan agentReply // 等待确认的上报
+	retryAt   time.Time
+	OnMessage func(data json.RawMessage) // 收到服务器下发的消息时调用
+	OnCommand func(data json.RawMessage) // 收到服务器推送的命令时调用
+}
+
+// 通过 WebSocket 上报状态, 返回与 HTTP 上报相同格式的响应; 连接不可用时返回错误, 由调用方改用 HTTP 上报
+func (c *AgentConn) Report(serverUrl string, deviceId string, deviceSecret string, data map[string]any) (map[string]any, error) {
+	reply, err := c.request(serverUrl, deviceId, deviceSecret, "status", data)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Type == "ack" {
+		commands := reply.Commands
+		if len(commands) == 0 {
+			commands = json.RawMessage("[]")
+		}
+		return map[string]any{"success": true, "data": map[string]any{"message": "状态更新成功", "commands": commands}}, nil
+	}
+	return map[string]any{"success": false, "error": reply.Error, "fields": reply.Fields}, nil
+}
+
+// 通过 WebSocket 确认命令的执行结果, 返回格式同 Report
+func (c *AgentConn) AckCommand(serverUrl string, deviceId string, deviceSecret string, ack map[string]any) (map[string]any, error) {
+	reply, err := c.request(serverUrl, deviceId, deviceSecret, "command_ack", ack)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Type == "ack" {
+		return map[string]any{"success": true, "data": map[string]any{"message": "确认成功"}}, nil
+	}
+	return map[string]any{"success": false, "error": reply.Error}, nil
+}
+
+// 发送一条消息并等待服务器回复
+func (c *AgentConn) request(serverUrl string, deviceId string, deviceSecret string, kind string, data any) (agentReply, error) {
+	conn, err := c.connect(serverUrl, deviceId, deviceSecret)
+	if err != nil {
+		return agentReply{}, err
+	}
+
+	id := newSnapshotId()
+	reply := make(chan agentReply, 1)
+	c.mu.Lock()
+	c.pending[id] = reply
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	frame, err := json.Marshal(map[string]any{"type": kind, "id": id, "data": data})
+	if err != nil {
+		return agentReply{}, err
+	}
+	c.mu.Lock()
+	conn.SetWriteDeadline(time.Now().Add(agentReplyTimeout))
+	err = conn.WriteMessage(websocket.TextMessage, frame)
+	c.mu.Unlock()
+	if err != nil {
+		c.drop(conn)
+		return agentReply{}, err
+	}
+
+	select {
+	case r, ok := <-reply:
+		if !ok {
+			return agentReply{}, errAgentClosed
+		}
+		return r, nil
+	case <-time.After(agentReplyTimeout):
+		c.drop(conn)
+		return agentReply{}, errors.New("等待服务器回复超时")
+	}
+}
+
+// 获取连接, 未连接或凭证变化时重新连接
+func (c *AgentConn) connect(serverUrl string, deviceId string, deviceSecret string) (*websocket.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := serverUrl + "\n" + deviceId + "\n" + deviceSecret
+	if c.conn != nil && c.key == key {
+		return c.conn, nil
+	}
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	if c.key == key && time.Now().Before(c.retryAt) {
+		return nil, errAgentClosed
+	}
+	c.key = key
+
+	url := fmt.Sprintf("%s/api/agent/ws?device_id=%s", serverUrl, deviceId)
+	url = strings.Replace(url, "http", "ws", 1)
+	header := http.Header{}
+	header.Set("X-Device-Secret", deviceSecret)
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		c.retryAt = time.Now().Add(agentRetryDelay)
+		return nil, err
+	}
+
+	c.conn = conn
+	c.pending = make(map[string]chan agentReply)
+	conn.SetReadDeadline(time.Now().Add(agentReadTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(agentReadTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(agentReplyTimeout))
+	})
+	go c.read(conn)
+	log.Println("已建立 WebSocket 连接")
+	return conn, nil
+}
+
+// 读取服务器消息直到连接断开
+func (c *AgentConn) read(conn *websocket.Conn) {
+	defer c.drop(conn)
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("WebSocket 连接断开: %v", err)
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(agentReadTimeout))
+
+		var reply agentReply
+		if err := json.Unmarshal(message, &reply); err != nil {
+			continue
+		}
+		switch reply.Type {
+		case "message":
+			if c.OnMessage != nil {
+				c.OnMessage(reply.Data)
+			}
+			continue
+		case "command":
+			if c.OnCommand != nil {
+				c.OnCommand(reply.Data)
+			}
+			continue
+		}
+		c.mu.Lock()
+		if pending, ok := c.pending[reply.Id]; ok {
+			pending <- reply
+		}
+		c.mu.Unlock()
+	}
+}
+
+// 关闭连接, 等待确认的上报改用 HTTP
+func (c *AgentConn) drop(conn *websocket.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != conn {
+		return
+	}
+	conn.Close()
+	c.conn = nil
+	c.retryAt = time.Now().Add(agentRetryDelay)
+	for id, pending := range c.pending {
+		close(pending)
+		delete(c.pending, id)
+	}
+}
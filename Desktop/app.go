@@ -5,16 +5,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 type App struct {
-	ctx  context.Context
-	tray *TrayManager
+	ctx    context.Context
+	tray   *TrayManager
+	buffer StatusBuffer
+	agent  AgentConn
 }
 
 func NewApp() *App {
@@ -25,6 +29,12 @@ func NewApp() *App {
 
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
+	a.agent.OnMessage = func(data json.RawMessage) {
+		runtime.EventsEmit(ctx, "server-message", data)
+	}
+	a.agent.OnCommand = func(data json.RawMessage) {
+		runtime.EventsEmit(ctx, "server-command", data)
+	}
 	a.tray.Startup(ctx)
 	a.tray.StartTray()
 	log.Println("应用启动完成, 托盘已初始化")
@@ -75,8 +85,8 @@ func (a *App) WindowSetPosition(x, y int) {
 }
 
 // 更新设备状态
-func (a *App) UpdateStatus(serverUrl string, userId string, deviceId string) any {
-	url := fmt.Sprintf("%s/api/status/update?user_id=%s&device_id=%s", serverUrl, userId, deviceId)
+func (a *App) UpdateStatus(serverUrl string, deviceId string, deviceSecret string) any {
+	url := fmt.Sprintf("%s/api/status/update?device_id=%s", serverUrl, deviceId)
 	// 获取电池信息
 	batteryInfo, err := status.GetBatteryInfo()
 	if err != nil {
@@ -131,6 +141,25 @@ func (a *App) UpdateStatus(serverUrl string, userId string, deviceId string) any
 			"is_low_power_mode":   otherInfo.IsLowPowerMode,
 		},
 	}
+	// 记录采集时间, 上报失败时缓存快照, 恢复连接后批量补传
+	snapshot := map[string]any{
+		"id":        newSnapshotId(),
+		"timestamp": time.Now().UnixMilli(),
+	}
+	for key, value := range data {
+		snapshot[key] = value
+	}
+	if err := a.buffer.Flush(serverUrl, deviceId, deviceSecret); err != nil {
+		log.Printf("补传缓存状态失败: %v", err)
+	}
+	// 优先通过 WebSocket 上报, 连接不可用时改用 HTTP
+	agentResp, err := a.agent.Report(serverUrl, deviceId, deviceSecret, data)
+	if err == nil {
+		return agentResp
+	}
+	if !errors.Is(err, errAgentClosed) {
+		log.Printf("WebSocket 上报失败, 改用 HTTP 上报: %v", err)
+	}
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		log.Printf("JSON 编码失败: %v", err)
@@ -143,26 +172,69 @@ func (a *App) UpdateStatus(serverUrl string, userId string, deviceId string) any
 		return "请求创建失败"
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Device-Secret", deviceSecret)
 	// 发送请求
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("请求发送失败: %v", err)
-		return "请求失败"
+		count := a.buffer.Add(snapshot)
+		return fmt.Sprintf("请求失败, 已缓存 %d 条状态, 恢复连接后自动补传", count)
 	}
 	defer resp.Body.Close()
 	// 读取响应
 	respBody := new(bytes.Buffer)
-	_, err = respBody.ReadFrom(resp.Body)
-	if err != nil {
-		log.Printf("读取响应失败: %v", err)
-		return "读取响应失败"
-	}
+	_, readErr := respBody.ReadFrom(resp.Body)
 	var respData map[string]any
 	err = json.Unmarshal(respBody.Bytes(), &respData)
+	// 与补传相同, 参数错误(400)重试也不会成功, 其余失败都缓存快照
+	if resp.StatusCode != http.StatusBadRequest && (readErr != nil || err != nil || respData["success"] != true || resp.StatusCode >= 300) {
+		log.Printf("上报失败: HTTP %d, %s", resp.StatusCode, respBody.String())
+		count := a.buffer.Add(snapshot)
+		return fmt.Sprintf("上报失败, 已缓存 %d 条状态, 恢复连接后自动补传", count)
+	}
 	if err != nil {
 		log.Printf("JSON 解码失败: %v", err)
 		return "JSON 解码失败"
 	}
 	return respData
 }
+
+// 确认命令的执行结果
+func (a *App) AckCommand(serverUrl string, deviceId string, deviceSecret string, commandId string, success bool, result string) any {
+	ack := map[string]any{
+		"id":      commandId,
+		"success": success,
+		"result":  result,
+	}
+	// 优先通过 WebSocket 确认, 连接不可用时改用 HTTP
+	agentResp, err := a.agent.AckCommand(serverUrl, deviceId, deviceSecret, ack)
+	if err == nil {
+		return agentResp
+	}
+	url := fmt.Sprintf("%s/api/command/ack?device_id=%s", serverUrl, deviceId)
+	jsonData, err := json.Marshal(ack)
+	if err != nil {
+		log.Printf("JSON 编码失败: %v", err)
+		return "JSON 编码失败"
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		log.Printf("请求创建失败: %v", err)
+		return "请求创建失败"
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Device-Secret", deviceSecret)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("确认命令失败: %v", err)
+		return "确认命令失败"
+	}
+	defer resp.Body.Close()
+	var respData map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
+		log.Printf("JSON 解码失败: %v", err)
+		return "JSON 解码失败"
+	}
+	return respData
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	maxBufferedSnapshots = 2000             // 最多缓存的快照数量, 超出后丢弃最早的快照
+	batchSize            = 500              // 每次批量上报的快照数量(与服务端上限一致)
+	flushBaseDelay       = 10 * time.Second // 补传失败后的初始重试间隔, 每次失败翻倍
+	flushMaxDelay        = 10 * time.Minute // 重试间隔上限
+)
+
+// 离线期间缓存的状态快照
+type StatusBuffer struct {
+	mu        sync.Mutex
+	snapshots []map[string]any
+	failures  int       // 连续补传失败次数
+	retryAt   time.Time // 补传失败后, 该时间之前不再重试
+}
+
+// 生成快照ID, 服务端据此去重
+func newSnapshotId() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// 缓存一条快照, 返回当前缓存数量
+func (b *StatusBuffer) Add(snapshot map[string]any) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.snapshots = append(b.snapshots, snapshot)
+	if len(b.snapshots) > maxBufferedSnapshots {
+		b.snapshots = b.snapshots[len(b.snapshots)-maxBufferedSnapshots:]
+	}
+	return len(b.snapshots)
+}
+
+// 补传缓存的快照, 上报成功的快照会从缓存中移除;
+// 仅在服务端判定数据格式错误(400)时丢弃该批快照, 其他失败(如凭证失效, 限流, 服务器错误)保留快照并稍后重试
+func (b *StatusBuffer) Flush(serverUrl string, deviceId string, deviceSecret string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.snapshots) == 0 || time.Now().Before(b.retryAt) {
+		return nil
+	}
+
+	url := fmt.Sprintf("%s/api/status/batch?device_id=%s", serverUrl, deviceId)
+	for len(b.snapshots) > 0 {
+		count := min(len(b.snapshots), batchSize)
+		jsonData, err := json.Marshal(map[string]any{
+			"snapshots": b.snapshots[:count],
+		})
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Device-Secret", deviceSecret)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return b.retryLater(err)
+		}
+		var respData struct {
+			Success bool   `json:"success"`
+			Error   string `json:"error"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&respData)
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusBadRequest:
+			// 数据格式错误, 重试也不会成功, 丢弃这批快照
+			log.Printf("补传状态被拒绝, 已丢弃 %d 条快照: %s", count, respData.Error)
+		case err != nil:
+			return b.retryLater(fmt.Errorf("HTTP %d: %w", resp.StatusCode, err))
+		case !respData.Success || resp.StatusCode >= 300:
+			return b.retryLater(fmt.Errorf("HTTP %d: %s", resp.StatusCode, respData.Error))
+		}
+		b.snapshots = b.snapshots[count:]
+		b.failures = 0
+		b.retryAt = time.Time{}
+	}
+	return nil
+}
+
+// 记录补传失败, 按指数退避推迟下次重试
+func (b *StatusBuffer) retryLater(err error) error {
+	delay := flushBaseDelay
+	for i := 0; i < b.failures && delay < flushMaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, flushMaxDelay)
+	b.failures++
+	b.retryAt = time.Now().Add(delay)
+	return fmt.Errorf("%w, %d 条快照将在 %s 后重试", err, len(b.snapshots), delay)
+}
@@ -4,6 +4,40 @@ import router from "./router"
 import "./assets/style/theme.css"
 import ToastPlugin from "vue-toast-notification"
 import "vue-toast-notification/dist/theme-bootstrap.css"
+import axios from "axios"
+
+// 请求时携带登录令牌
+axios.interceptors.request.use((request) => {
+	const CONFIG = JSON.parse(localStorage.getItem("config"))
+	if (CONFIG && CONFIG.token) {
+		request.headers.Authorization = `Bearer ${CONFIG.token}`
+	}
+	return request
+})
+
+// 访问令牌过期时使用刷新令牌换取新令牌并重试
+axios.interceptors.response.use(async (response) => {
+	const CONFIG = JSON.parse(localStorage.getItem("config"))
+	const REQUEST = response.config
+	if (response.status !== 401 || REQUEST._retried || !CONFIG || !CONFIG.refreshToken || REQUEST.url.endsWith("/api/user/refresh")) {
+		return response
+	}
+	REQUEST._retried = true
+	const RES = await axios.post(`${CONFIG.serverUrl}/api/user/refresh`, {
+		refresh_token: CONFIG.refreshToken
+	}, {
+		validateStatus: () => true
+	})
+	if (!RES.data.success) {
+		return response
+	}
+	localStorage.setItem("config", JSON.stringify({
+		...CONFIG,
+		token: RES.data.data.access_token,
+		refreshToken: RES.data.data.refresh_token
+	}))
+	return axios(REQUEST)
+})
 
 createApp(App)
 	.use(router)
@@ -1,6 +1,8 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function AckCommand(arg1:string,arg2:string,arg3:string,arg4:string,arg5:boolean,arg6:string):Promise<any>;
+
 export function HideWindow():Promise<void>;
 
 export function ShowWindow():Promise<void>;
@@ -2,6 +2,10 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function AckCommand(arg1, arg2, arg3, arg4, arg5, arg6) {
+  return window['go']['main']['App']['AckCommand'](arg1, arg2, arg3, arg4, arg5, arg6);
+}
+
 export function HideWindow() {
   return window['go']['main']['App']['HideWindow']();
 }
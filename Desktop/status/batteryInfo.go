@@ -29,7 +29,8 @@ func GetBatteryInfo() (*BatteryStatus, error) {
 		charging = 3
 	}
 
-	level := int(b.Current / b.Full * 100)
+	// 部分电池报告的当前容量会略高于满电容量, 服务器只接受 0~100
+	level := min(int(b.Current/b.Full*100), 100)
 	capacity := int(b.Design)
 	info := &BatteryStatus{
 		Charging:    charging,